@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+)
+
+// Request and named event counters for /metrics
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[string]uint64 // "method status" -> count
+	seconds  float64
+	counters map[string]uint64
+}
+
+var metrics = newMetrics()
+
+func newMetrics() *Metrics {
+	return &Metrics{requests: map[string]uint64{}, counters: map[string]uint64{}}
+}
+
+// Increment a named counter, exported as-is
+func (m *Metrics) Inc(name string) {
+	m.mu.Lock()
+	m.counters[name]++
+	m.mu.Unlock()
+}
+
+// Middleware counting requests of the public app
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		if err != nil {
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				return herr
+			}
+		}
+
+		m.mu.Lock()
+		m.requests[fmt.Sprintf("%s %d", c.Method(), c.Response().StatusCode())]++
+		m.seconds += time.Since(start).Seconds()
+		m.mu.Unlock()
+		return nil
+	}
+}
+
+// GET metrics in Prometheus text format
+func (m *Metrics) Handler(c *fiber.Ctx) error {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, k := range keys {
+		method, status, _ := strings.Cut(k, " ")
+		fmt.Fprintf(&b, "http_requests_total{method=%q,status=%q} %d\n", method, status, m.requests[k])
+	}
+	b.WriteString("# TYPE http_request_duration_seconds_sum counter\n")
+	fmt.Fprintf(&b, "http_request_duration_seconds_sum %g\n", m.seconds)
+
+	names := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s counter\n%s %d\n", name, name, m.counters[name])
+	}
+	m.mu.Unlock()
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+	return c.SendString(b.String())
+}
+
+// 503 from /readyz, localized like every other error
+func backendUnreachable(c *fiber.Ctx, backend string) error {
+	return c.Status(503).JSON(fiber.Map{
+		"status": "unavailable",
+		"error":  translate(requestLanguage(c), ErrBackendUnreachable, fiber.Map{"backend": backend}),
+		"code":   ErrBackendUnreachable,
+	})
+}
+
+// Routes needing ADMIN_TOKEN: registry and report writes, audit export
+func registerAdminRoutes(router fiber.Router) {
+	router.Put("/custom-fields/:name", requireAdminToken(), requireMongo, putCustomField)
+	router.Delete("/custom-fields/:name", requireAdminToken(), requireMongo, deleteCustomField)
+	router.Put("/reports/:name", requireAdminToken(), requireMongo, putReport)
+	router.Delete("/reports/:name", requireAdminToken(), requireMongo, deleteReport)
+
+	// Check exports with ./api audit verify --file
+	router.Get("/audit", requireAdminToken(), requireMongo, exportAuditHandler)
+}
+
+// Without an admin listener: the admin routes and /metrics on the public app
+func registerPublicAdminRoutes(app *fiber.App) {
+	app.Get("/metrics", requireAdminToken(), metrics.Handler)
+	registerAdminRoutes(app)
+}
+
+// Operational app served on ADMIN_ADDR: health, metrics, debug and, registered
+// by main, the admin routes. verifier lets SIGNING_ADMIN_CLIENTS in, may be nil.
+func newAdminApp(token string, verifier *RequestVerifier) *fiber.App {
+	admin := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		ErrorHandler:          errorHandler,
+	})
+	admin.Use(recover.New())
+	if audit != nil {
+		admin.Use(audit.Middleware())
+	}
+	if ipFilter != nil {
+		admin.Use(ipFilter.Middleware())
+	}
+	if verifier != nil {
+		admin.Use(verifier.Middleware())
+	}
+
+	// The listener is meant to be private, still guard debug with the token when set
+	if token != "" {
+		admin.Use("/debug", adminAuth(token))
+	}
+
+	// Liveness
+	admin.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+
+	// Readiness, checks MongoDB when it is the backend
+	admin.Get("/readyz", func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		if pg, ok := users.(*postgresStore); ok {
+			if err := pg.pool.Ping(ctx); err != nil {
+				return backendUnreachable(c, "PostgreSQL")
+			}
+		}
+		if client == nil {
+			return c.JSON(fiber.Map{"status": "ready"})
+		}
+
+		if err := client.Ping(ctx, nil); err != nil {
+			return backendUnreachable(c, "MongoDB")
+		}
+		return c.JSON(fiber.Map{"status": "ready"})
+	})
+
+	admin.Get("/metrics", metrics.Handler)
+	registerDebugRoutes(admin)
+
+	return admin
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func testGet(t *testing.T, app *fiber.App, method, path, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestAdminApp(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	admin := newAdminApp("admin-token", nil)
+	registerAdminRoutes(admin)
+
+	tests := []struct {
+		method, path, token string
+		status              int
+		contains            string
+	}{
+		{"GET", "/healthz", "", 200, `"ok"`},
+		{"GET", "/readyz", "", 200, `"ready"`},
+		{"GET", "/metrics", "", 200, "http_requests_total"},
+		{"GET", "/debug/runtime", "", 401, ErrAdminUnauthorized},
+		{"GET", "/debug/runtime", "admin-token", 200, "goroutines"},
+		{"PUT", "/custom-fields/dept", "", 401, ErrAdminUnauthorized},
+		{"GET", "/audit", "", 401, ErrAdminUnauthorized},
+		{"GET", "/no-such-route", "", 404, ErrRouteNotFound},
+	}
+	for _, tt := range tests {
+		status, body := testGet(t, admin, tt.method, tt.path, tt.token)
+		if status != tt.status || !strings.Contains(body, tt.contains) {
+			t.Errorf("%s %s: got %d %s, want %d containing %q", tt.method, tt.path, status, body, tt.status, tt.contains)
+		}
+	}
+}
+
+// Without ADMIN_ADDR the counters stay reachable, behind the token
+func TestPublicAdminRoutes(t *testing.T) {
+	for _, tt := range []struct {
+		env, token string
+		status     int
+	}{
+		{"admin-token", "", 401},
+		{"admin-token", "wrong", 401},
+		{"admin-token", "admin-token", 200},
+		{"", "", 403},
+	} {
+		t.Setenv("ADMIN_TOKEN", tt.env)
+		app := fiber.New()
+		registerPublicAdminRoutes(app)
+		if status, body := testGet(t, app, "GET", "/metrics", tt.token); status != tt.status {
+			t.Errorf("ADMIN_TOKEN=%q, token %q: got %d %s, want %d", tt.env, tt.token, status, body, tt.status)
+		}
+	}
+}
+
+// Admin clients signing their requests get past ADMIN_TOKEN checks on the
+// admin listener too
+func TestAdminAppSignedAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	admin := newAdminApp("admin-token", testVerifier(false))
+	registerAdminRoutes(admin)
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"unsigned", httptest.NewRequest("GET", "/debug/runtime", nil), 401, ErrAdminUnauthorized},
+		{"plain client", testSigned{method: "GET", target: "/debug/runtime", client: "billing", secret: testBillingSecret, timestamp: now, nonce: "0123456789abcdef"}.request(), 401, ErrAdminUnauthorized},
+		{"admin client", testSigned{method: "GET", target: "/debug/runtime", client: "ops", secret: testOpsSecret, timestamp: now, nonce: "0123456789abcdef"}.request(), 200, ""},
+		{"forged", testSigned{method: "GET", target: "/debug/runtime", client: "ops", secret: testBillingSecret, timestamp: now, nonce: "fedcba9876543210"}.request(), 401, ErrSignatureInvalid},
+		// Past the token check, the registry needs MongoDB
+		{"admin route", testSigned{method: "PUT", target: "/custom-fields/dept", client: "ops", secret: testOpsSecret, timestamp: now, nonce: "00112233445566778899"}.request(), 501, ErrFeatureUnavailable},
+	}
+	for _, tt := range tests {
+		status, code := testResponse(t, admin, tt.req)
+		if status != tt.status || (tt.code != "" && code != tt.code) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, code, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audit entry kinds
+const (
+	AuditDenied   = "denied"   // 401 and 403 answers
+	AuditAdmin    = "admin"    // any request authorized by the admin token or an admin client signature
+	AuditMutation = "mutation" // successful writes by anyone else
+)
+
+// c.Locals keys set by the authenticating middleware: who made the request,
+// and whether it was authorized as an admin
+const (
+	auditActorKey = "audit_actor"
+	auditAdminKey = "audit_admin"
+)
+
+const (
+	auditActorAdmin     = "admin"
+	auditActorAnonymous = "anonymous"
+)
+
+// prev_hash of the first entry
+var auditGenesis = strings.Repeat("0", 64)
+
+// One audited request. Hash covers every other field, PrevHash included, so
+// editing, removing or reordering entries breaks the chain.
+type AuditEntry struct {
+	Seq      int64             `json:"seq" bson:"_id"`
+	At       time.Time         `json:"at" bson:"at"`
+	Kind     string            `json:"kind" bson:"kind"`
+	Action   string            `json:"action" bson:"action"` // method and route, "PUT /custom-fields/:name"
+	Actor    string            `json:"actor" bson:"actor"`
+	IP       string            `json:"ip" bson:"ip"`
+	Status   int               `json:"status" bson:"status"`
+	Details  map[string]string `json:"details,omitempty" bson:"details,omitempty"` // route parameters, dry_run
+	PrevHash string            `json:"prev_hash" bson:"prev_hash"`
+	Hash     string            `json:"hash" bson:"hash"`
+}
+
+// Hex SHA-256 of the entry as JSON without its hash, an HMAC when key is set
+func (e AuditEntry) digest(key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e) // fixed field order, sorted map keys
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var auditCollection *mongo.Collection
+
+// Appends to the audit collection. Sequence numbers are _ids, so concurrent
+// instances cannot fork the chain: the loser of a race reloads the head.
+// Requests queue their entries; a single writer appends them in batches.
+type AuditLog struct {
+	coll    *mongo.Collection
+	key     []byte
+	head    *AuditEntry // last appended, nil until loaded; owned by the writer
+	queue   chan AuditEntry
+	stopped chan struct{}
+}
+
+// Entries waiting for the writer before requests wait too, and entries per insert
+const (
+	auditQueueSize = 4096
+	auditBatchSize = 256
+)
+
+// AUDIT_KEY turns the hashes into HMACs, so rewriting the chain needs the key
+func newAuditLog(ctx context.Context, coll *mongo.Collection) (*AuditLog, error) {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "at", Value: 1}}})
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{
+		coll:    coll,
+		key:     []byte(os.Getenv("AUDIT_KEY")),
+		queue:   make(chan AuditEntry, auditQueueSize),
+		stopped: make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Set up with the Mongo backend, nil otherwise
+var audit *AuditLog
+
+// Last entry, or a zero entry carrying the genesis hash
+func auditHead(ctx context.Context, coll *mongo.Collection) (AuditEntry, error) {
+	var head AuditEntry
+	err := coll.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&head)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return AuditEntry{Hash: auditGenesis}, nil
+	}
+	return head, err
+}
+
+// Queue an entry; only waits when the writer is auditQueueSize entries behind
+func (l *AuditLog) record(e AuditEntry) {
+	l.queue <- e
+}
+
+// Writer: appends whatever is queued in one insert, in queue order
+func (l *AuditLog) run() {
+	defer close(l.stopped)
+	for e := range l.queue {
+		batch := []AuditEntry{e}
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case e, ok := <-l.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := l.append(ctx, batch); err != nil {
+			metrics.Inc("audit_write_errors_total")
+			fmt.Printf("⚠️  Failed to audit %d request(s) from %s: %v\n", len(batch), batch[0].Action, err)
+		}
+		cancel()
+	}
+}
+
+// Write the queued entries and stop the writer, once no request can queue more
+func (l *AuditLog) Close() {
+	close(l.queue)
+	<-l.stopped
+}
+
+// Chain batch onto the head and insert it. The insert is ordered, so after a
+// duplicate _id the entries before it are in and the rest are chained again.
+func (l *AuditLog) append(ctx context.Context, batch []AuditEntry) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		if l.head == nil {
+			head, err := auditHead(ctx, l.coll)
+			if err != nil {
+				return err
+			}
+			l.head = &head
+		}
+		docs := make([]any, len(batch))
+		prev := *l.head
+		for i := range batch {
+			e := &batch[i]
+			// Mongo keeps milliseconds, hash what will be read back
+			e.At = e.At.UTC().Truncate(time.Millisecond)
+			e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+			e.Hash = e.digest(l.key)
+			docs[i], prev = *e, *e
+		}
+		_, err := l.coll.InsertMany(ctx, docs)
+		if err == nil {
+			l.head = &prev
+			return nil
+		}
+		// Another instance appended first, or an earlier insert went through after all
+		l.head = nil
+		var bulk mongo.BulkWriteException
+		if !errors.As(err, &bulk) || len(bulk.WriteErrors) == 0 || !mongo.IsDuplicateKeyError(bulk.WriteErrors[0]) {
+			return err
+		}
+		batch = batch[bulk.WriteErrors[0].Index:]
+	}
+	return errors.New("audit head kept moving")
+}
+
+// Records denied requests, admin requests and successful writes once they
+// were answered, queued for the writer. Failures are logged, the request
+// already happened.
+func (l *AuditLog) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				return herr
+			}
+		}
+
+		status := c.Response().StatusCode()
+		actor, _ := c.Locals(auditActorKey).(string)
+		admin, _ := c.Locals(auditAdminKey).(bool)
+		var kind string
+		switch {
+		case status == 401 || status == 403:
+			kind = AuditDenied
+		case admin:
+			kind = AuditAdmin
+		case c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead && c.Method() != fiber.MethodOptions && status < 400:
+			kind = AuditMutation
+		default:
+			return nil
+		}
+		if actor == "" {
+			actor = auditActorAnonymous
+		}
+
+		entry := AuditEntry{
+			At:     time.Now(),
+			Kind:   kind,
+			Action: c.Method() + " " + c.Route().Path,
+			Actor:  actor,
+			IP:     clientIP(c),
+			Status: status,
+		}
+		details := map[string]string{}
+		for _, param := range c.Route().Params {
+			details[param] = c.Params(param)
+		}
+		if isDryRun(c) {
+			details["dry_run"] = "true"
+		}
+		if len(details) > 0 {
+			entry.Details = details
+		}
+
+		l.record(entry)
+		return nil
+	}
+}
+
+// Entries by sequence number and time, bounds inclusive and zero when open
+type AuditRange struct {
+	From, To     int64
+	Since, Until time.Time
+}
+
+func (r AuditRange) filter() bson.M {
+	filter := bson.M{}
+	seq := bson.M{}
+	if r.From > 0 {
+		seq["$gte"] = r.From
+	}
+	if r.To > 0 {
+		seq["$lte"] = r.To
+	}
+	if len(seq) > 0 {
+		filter["_id"] = seq
+	}
+	at := bson.M{}
+	if !r.Since.IsZero() {
+		at["$gte"] = r.Since
+	}
+	if !r.Until.IsZero() {
+		at["$lte"] = r.Until
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+	return filter
+}
+
+// Visit the entries of r in sequence order
+func eachAuditEntry(ctx context.Context, coll *mongo.Collection, r AuditRange, fn func(AuditEntry) error) error {
+	cursor, err := coll.Find(ctx, r.filter(), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var e AuditEntry
+		if err := cursor.Decode(&e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Checks entries handed over in sequence order. The first one is linked to
+// prev, or taken on trust when prev is nil (an export starting mid-chain).
+type auditVerifier struct {
+	key      []byte
+	prev     *AuditEntry
+	checked  int
+	problems []string
+}
+
+func (v *auditVerifier) check(e AuditEntry) {
+	v.checked++
+	if e.Hash != e.digest(v.key) {
+		v.problems = append(v.problems, fmt.Sprintf("entry %d: hash mismatch, the entry was altered", e.Seq))
+	}
+	if v.prev != nil {
+		switch {
+		case e.Seq <= v.prev.Seq:
+			v.problems = append(v.problems, fmt.Sprintf("entry %d is out of order, after entry %d", e.Seq, v.prev.Seq))
+		case e.Seq != v.prev.Seq+1:
+			v.problems = append(v.problems, fmt.Sprintf("entries %d to %d are missing", v.prev.Seq+1, e.Seq-1))
+		case e.PrevHash != v.prev.Hash:
+			v.problems = append(v.problems, fmt.Sprintf("entry %d: prev_hash does not match entry %d", e.Seq, v.prev.Seq))
+		}
+	}
+	v.prev = &e
+}
+
+// ./api audit verify|export
+func runAudit(args []string) error {
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "export") {
+		return errors.New("usage: go-fiber-api audit verify|export [flags]")
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("audit "+cmd, flag.ExitOnError)
+	from := fs.Int64("from", 0, "first sequence number")
+	to := fs.Int64("to", 0, "last sequence number")
+	since := fs.String("since", "", "first time, RFC 3339 or YYYY-MM-DD")
+	until := fs.String("until", "", "last time, RFC 3339 or YYYY-MM-DD")
+	file := fs.String("file", "", "verify: an export instead of the database")
+	out := fs.String("out", "", "export: NDJSON file instead of stdout")
+	fs.Parse(args[1:])
+
+	r := AuditRange{From: *from, To: *to}
+	for _, t := range []struct {
+		raw  string
+		into *time.Time
+	}{{*since, &r.Since}, {*until, &r.Until}} {
+		if t.raw == "" {
+			continue
+		}
+		parsed, err := parseTime(t.raw)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", t.raw)
+		}
+		*t.into = parsed
+	}
+
+	loadEnv()
+	v := &auditVerifier{key: []byte(os.Getenv("AUDIT_KEY"))}
+	if cmd == "verify" && *file != "" {
+		if err := verifyAuditFile(*file, v); err != nil {
+			return err
+		}
+		return reportAuditVerify(v, "")
+	}
+
+	connectMongoDB()
+	defer client.Disconnect(context.Background())
+	ctx := context.Background()
+
+	if cmd == "export" {
+		w := io.Writer(os.Stdout)
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return exportAudit(ctx, r, w)
+	}
+
+	// Link the first entry to its predecessor unless it starts the chain
+	first := true
+	err := eachAuditEntry(ctx, auditCollection, r, func(e AuditEntry) error {
+		if first {
+			first = false
+			if e.Seq == 1 {
+				v.prev = &AuditEntry{Hash: auditGenesis}
+			} else {
+				var prev AuditEntry
+				err := auditCollection.FindOne(ctx, bson.M{"_id": e.Seq - 1}).Decode(&prev)
+				switch {
+				case err == nil:
+					v.prev = &prev
+				case errors.Is(err, mongo.ErrNoDocuments):
+					v.problems = append(v.problems, fmt.Sprintf("entry %d, before the range, is missing", e.Seq-1))
+				default:
+					return err
+				}
+			}
+		}
+		v.check(e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	head, err := auditHead(ctx, auditCollection)
+	if err != nil {
+		return err
+	}
+	return reportAuditVerify(v, fmt.Sprintf("head is entry %d, hash %s; compare with the head recorded at earlier checks to detect truncation", head.Seq, head.Hash))
+}
+
+// Entries of an export, whose first prev_hash is taken on trust
+func verifyAuditFile(path string, v *auditVerifier) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if v.checked == 0 {
+			fmt.Printf("🔗 Export starts at entry %d, after hash %s\n", e.Seq, e.PrevHash)
+		}
+		v.check(e)
+	}
+	return scanner.Err()
+}
+
+func reportAuditVerify(v *auditVerifier, note string) error {
+	for _, problem := range v.problems {
+		fmt.Printf("❌ %s\n", problem)
+	}
+	if note != "" {
+		fmt.Printf("🔗 %s\n", note)
+	}
+	if len(v.problems) > 0 {
+		return fmt.Errorf("audit chain broken: %d problem(s) in %d entries", len(v.problems), v.checked)
+	}
+	fmt.Printf("✅ Audit chain intact: %d entries\n", v.checked)
+	return nil
+}
+
+// Entries of r as NDJSON, one per line
+func exportAudit(ctx context.Context, r AuditRange, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return eachAuditEntry(ctx, auditCollection, r, func(e AuditEntry) error {
+		return enc.Encode(e)
+	})
+}
+
+// GET /audit?from=&to=&since=&until= streams entries as NDJSON, for verify --file
+func exportAuditHandler(c *fiber.Ctx) error {
+	var r AuditRange
+	var errs []*FieldError
+	for _, p := range []struct {
+		param string
+		into  *int64
+	}{{"from", &r.From}, {"to", &r.To}} {
+		if raw := c.Query(p.param); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 1 {
+				errs = append(errs, &FieldError{Field: p.param, Code: ErrFieldTooSmall, Params: fiber.Map{"min": 1}})
+				continue
+			}
+			*p.into = n
+		}
+	}
+	for _, p := range []struct {
+		param string
+		into  *time.Time
+	}{{"since", &r.Since}, {"until", &r.Until}} {
+		if raw := c.Query(p.param); raw != "" {
+			t, err := parseTime(raw)
+			if err != nil {
+				return apiError(c, 400, ErrInvalidTimestamp, fiber.Map{"param": p.param})
+			}
+			*p.into = t
+		}
+	}
+	if len(errs) > 0 {
+		return validationError(c, errs)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Attachment("audit.ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := exportAudit(ctx, r, w); err != nil {
+			// Headers are gone, a truncated export fails verify --file
+			fmt.Printf("⚠️  Audit export failed: %v\n", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
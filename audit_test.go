@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Chain of n entries linked from the genesis hash
+func testAuditChain(key []byte, n int) []AuditEntry {
+	chain := make([]AuditEntry, n)
+	prev := auditGenesis
+	for i := range chain {
+		e := AuditEntry{
+			Seq:      int64(i + 1),
+			At:       time.Date(2026, 1, 2, 3, 4, i, 0, time.UTC),
+			Kind:     AuditMutation,
+			Action:   "PUT /users/:id",
+			Actor:    "client:billing",
+			IP:       "192.0.2.1",
+			Status:   200,
+			Details:  map[string]string{"id": "42"},
+			PrevHash: prev,
+		}
+		e.Hash = e.digest(key)
+		chain[i], prev = e, e.Hash
+	}
+	return chain
+}
+
+func TestAuditDigest(t *testing.T) {
+	e := testAuditChain(nil, 1)[0]
+	if len(e.Hash) != 64 {
+		t.Fatalf("hash %q is not hex SHA-256", e.Hash)
+	}
+	if e.digest(nil) != e.Hash {
+		t.Error("digest depends on the stored hash")
+	}
+	if e.digest([]byte("key")) == e.Hash {
+		t.Error("AUDIT_KEY does not change the digest")
+	}
+
+	changed := e
+	changed.Details = map[string]string{"id": "43"}
+	if changed.digest(nil) == e.Hash {
+		t.Error("details are not covered")
+	}
+	changed = e
+	changed.PrevHash = auditGenesis[1:] + "1"
+	if changed.digest(nil) == e.Hash {
+		t.Error("prev_hash is not covered")
+	}
+}
+
+func TestAuditVerifier(t *testing.T) {
+	key := []byte("audit-key")
+	tests := []struct {
+		name     string
+		key      []byte // verifier key, the chain is built with key
+		edit     func([]AuditEntry) []AuditEntry
+		problems []string
+	}{
+		{
+			name: "intact",
+			key:  key,
+			edit: func(c []AuditEntry) []AuditEntry { return c },
+		},
+		{
+			name: "altered entry",
+			key:  key,
+			edit: func(c []AuditEntry) []AuditEntry {
+				c[1].Status = 403
+				return c
+			},
+			problems: []string{"entry 2: hash mismatch, the entry was altered"},
+		},
+		{
+			name: "altered and rehashed without the key",
+			key:  key,
+			edit: func(c []AuditEntry) []AuditEntry {
+				c[1].Actor = "admin"
+				c[1].Hash = c[1].digest(nil)
+				return c
+			},
+			problems: []string{
+				"entry 2: hash mismatch, the entry was altered",
+				"entry 3: prev_hash does not match entry 2",
+			},
+		},
+		{
+			name:     "removed entries",
+			key:      key,
+			edit:     func(c []AuditEntry) []AuditEntry { return append(c[:1], c[3:]...) },
+			problems: []string{"entries 2 to 3 are missing"},
+		},
+		{
+			name: "reordered entries",
+			key:  key,
+			edit: func(c []AuditEntry) []AuditEntry {
+				c[1], c[2] = c[2], c[1]
+				return c
+			},
+			problems: []string{
+				"entries 2 to 2 are missing",
+				"entry 2 is out of order, after entry 3",
+				"entries 3 to 3 are missing",
+			},
+		},
+		{
+			name: "relinked to another chain",
+			key:  key,
+			edit: func(c []AuditEntry) []AuditEntry {
+				c[2].PrevHash = auditGenesis
+				c[2].Hash = c[2].digest(key)
+				return c
+			},
+			problems: []string{
+				"entry 3: prev_hash does not match entry 2",
+				"entry 4: prev_hash does not match entry 3",
+			},
+		},
+		{
+			name: "wrong key",
+			key:  []byte("other-key"),
+			edit: func(c []AuditEntry) []AuditEntry { return c[:2] },
+			problems: []string{
+				"entry 1: hash mismatch, the entry was altered",
+				"entry 2: hash mismatch, the entry was altered",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &auditVerifier{key: tt.key, prev: &AuditEntry{Hash: auditGenesis}}
+			for _, e := range tt.edit(testAuditChain(key, 4)) {
+				v.check(e)
+			}
+			if !reflect.DeepEqual(v.problems, tt.problems) {
+				t.Errorf("got %q, want %q", v.problems, tt.problems)
+			}
+		})
+	}
+}
+
+// Exports may start mid-chain, their first entry is taken on trust
+func TestAuditVerifierMidChain(t *testing.T) {
+	v := &auditVerifier{}
+	for _, e := range testAuditChain(nil, 5)[2:] {
+		v.check(e)
+	}
+	if v.checked != 3 || v.problems != nil {
+		t.Errorf("got %d checked, problems %q; want 3, none", v.checked, v.problems)
+	}
+}
+
+// Which requests are queued, and as what
+func TestAuditMiddleware(t *testing.T) {
+	l := &AuditLog{queue: make(chan AuditEntry, 10)}
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Use(l.Middleware())
+	app.Use(func(c *fiber.Ctx) error {
+		if actor := c.Get("X-Actor"); actor != "" {
+			c.Locals(auditActorKey, actor)
+			c.Locals(auditAdminKey, actor == auditActorAdmin)
+		}
+		return c.Next()
+	})
+	app.Get("/users/:id", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+	app.Get("/secret", func(c *fiber.Ctx) error { return c.SendStatus(403) })
+	app.Put("/users/:id", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+	app.Post("/users", func(c *fiber.Ctx) error { return c.SendStatus(400) })
+
+	tests := []struct {
+		method, path, actor string
+		want                *AuditEntry
+	}{
+		{"GET", "/users/42", "", nil},
+		{"POST", "/users", "", nil},
+		{"GET", "/secret", "", &AuditEntry{Kind: AuditDenied, Action: "GET /secret", Actor: auditActorAnonymous, Status: 403}},
+		{"GET", "/users/42", auditActorAdmin, &AuditEntry{Kind: AuditAdmin, Action: "GET /users/:id", Actor: auditActorAdmin, Status: 200, Details: map[string]string{"id": "42"}}},
+		{"PUT", "/users/42?dry_run=true", "client:billing", &AuditEntry{Kind: AuditMutation, Action: "PUT /users/:id", Actor: "client:billing", Status: 200, Details: map[string]string{"id": "42", "dry_run": "true"}}},
+		{"DELETE", "/nowhere", "", nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.actor != "" {
+			req.Header.Set("X-Actor", tt.actor)
+		}
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+		var got *AuditEntry
+		select {
+		case e := <-l.queue:
+			e.At, e.IP = time.Time{}, ""
+			got = &e
+		default:
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: queued %+v, want %+v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// Entries queued concurrently by two instances sharing a collection form one chain
+func TestAuditWriter(t *testing.T) {
+	db := testMongoDB(t)
+	ctx := context.Background()
+	coll := db.Collection("audit")
+
+	key := []byte("audit-key")
+	t.Setenv("AUDIT_KEY", string(key))
+	var logs []*AuditLog
+	for range 2 {
+		l, err := newAuditLog(ctx, coll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, l)
+	}
+
+	const perLog = 300
+	var wg sync.WaitGroup
+	for _, l := range logs {
+		for i := range perLog {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.record(AuditEntry{At: time.Now(), Kind: AuditMutation, Action: fmt.Sprintf("PUT /users/%d", i), Actor: auditActorAnonymous, Status: 200})
+			}()
+		}
+	}
+	wg.Wait()
+	for _, l := range logs {
+		l.Close()
+	}
+
+	v := &auditVerifier{key: key, prev: &AuditEntry{Hash: auditGenesis}}
+	if err := eachAuditEntry(ctx, coll, AuditRange{}, func(e AuditEntry) error {
+		v.check(e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v.checked != 2*perLog || v.problems != nil {
+		t.Errorf("got %d entries, problems %q; want %d, none", v.checked, v.problems, 2*perLog)
+	}
+}
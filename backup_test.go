@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Archive of the given documents per collection, the way runBackup writes it;
+// edit may change the manifest first
+func testArchive(t *testing.T, collections map[string][]bson.M, edit func(*BackupManifest)) string {
+	t.Helper()
+	dir := t.TempDir()
+	manifest := BackupManifest{FormatVersion: backupFormatVersion, Database: "test"}
+	var spools []*os.File
+	for _, name := range []string{"users", "audit"} {
+		docs, ok := collections[name]
+		if !ok {
+			continue
+		}
+		spool, err := os.Create(filepath.Join(dir, name+".spool"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer spool.Close()
+
+		hash := sha256.New()
+		for _, doc := range docs {
+			raw, err := bson.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spool.Write(raw)
+			hash.Write(raw)
+		}
+		manifest.Collections = append(manifest.Collections, BackupCollection{
+			Name: name, File: name + ".bson", Documents: int64(len(docs)), SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+		spools = append(spools, spool)
+	}
+	if edit != nil {
+		edit(&manifest)
+	}
+	path := filepath.Join(dir, "backup.tar.gz")
+	if err := writeArchive(path, manifest, spools); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	collections := map[string][]bson.M{
+		"users": {{"_id": "a", "name": "Ann", "age": int32(30)}, {"_id": "b", "name": "Bob"}},
+		"audit": {},
+	}
+	path := testArchive(t, collections, nil)
+	if err := verifySidecar(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]bson.M{"audit": {}}
+	manifest, err := readArchive(path, func(collection string, doc bson.Raw) error {
+		var m bson.M
+		if err := bson.Unmarshal(doc, &m); err != nil {
+			return err
+		}
+		got[collection] = append(got[collection], m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Database != "test" || len(manifest.Collections) != 2 {
+		t.Errorf("manifest %+v", manifest)
+	}
+	if !reflect.DeepEqual(got, collections) {
+		t.Errorf("read %v, wrote %v", got, collections)
+	}
+}
+
+func TestBackupRejectsDamage(t *testing.T) {
+	users := map[string][]bson.M{"users": {{"_id": "a", "name": "Ann"}}}
+	tests := []struct {
+		name string
+		edit func(*BackupManifest)
+		want string
+	}{
+		{"wrong version", func(m *BackupManifest) { m.FormatVersion = 2 }, "unsupported format version 2"},
+		{"count", func(m *BackupManifest) { m.Collections[0].Documents = 2 }, "expected 2 documents, found 1"},
+		{"checksum", func(m *BackupManifest) { m.Collections[0].SHA256 = "00" }, "users.bson: checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testArchive(t, users, tt.edit)
+			_, err := readArchive(path, func(string, bson.Raw) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySidecar(t *testing.T) {
+	path := testArchive(t, map[string][]bson.M{"users": {}}, nil)
+	os.WriteFile(path+".sha256", []byte(strings.Repeat("0", 64)+"  backup.tar.gz\n"), 0o644)
+	if err := verifySidecar(path); err == nil {
+		t.Error("tampered sidecar accepted")
+	}
+	os.Remove(path + ".sha256")
+	if err := verifySidecar(path); err != nil {
+		t.Errorf("missing sidecar: %v", err)
+	}
+}
+
+func TestReadBSONDocument(t *testing.T) {
+	valid, _ := bson.Marshal(bson.M{"a": 1})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"valid", valid, ""},
+		{"truncated", valid[:len(valid)-2], "truncated document"},
+		{"short size", valid[:2], "truncated document"},
+		{"bad size", []byte{1, 0, 0, 0}, "invalid document size 1"},
+	}
+	for _, tt := range tests {
+		doc, err := readBSONDocument(bufio.NewReader(bytes.NewReader(tt.data)))
+		switch {
+		case tt.want == "" && (err != nil || !bytes.Equal(doc, valid)):
+			t.Errorf("%s: got %v %v", tt.name, doc, err)
+		case tt.want != "" && (err == nil || err.Error() != tt.want):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Custom field types
+const (
+	CustomString  = "string"
+	CustomInteger = "integer"
+	CustomNumber  = "number"
+	CustomBoolean = "boolean"
+)
+
+var customFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// Admin-defined user attribute, stored under User.Custom
+type CustomField struct {
+	Name        string `json:"name" bson:"_id"`
+	Type        string `json:"type" bson:"type"`
+	Required    bool   `json:"required" bson:"required"`
+	Enum        []any  `json:"enum,omitempty" bson:"enum,omitempty"`
+	Default     any    `json:"default,omitempty" bson:"default,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+var customFieldCollection *mongo.Collection
+
+// Definitions from the custom_fields collection, cached for validation and listing
+type customFieldRegistry struct {
+	mu     sync.RWMutex
+	fields map[string]CustomField
+}
+
+var customFields = &customFieldRegistry{fields: map[string]CustomField{}}
+
+func (r *customFieldRegistry) load(ctx context.Context) error {
+	cursor, err := customFieldCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var list []CustomField
+	if err := cursor.All(ctx, &list); err != nil {
+		return err
+	}
+	fields := make(map[string]CustomField, len(list))
+	for _, f := range list {
+		fields[f.Name] = f
+	}
+
+	r.mu.Lock()
+	r.fields = fields
+	r.mu.Unlock()
+	return nil
+}
+
+// Pick up definitions changed by other instances
+func (r *customFieldRegistry) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := r.load(ctx); err != nil {
+			fmt.Printf("⚠️  Custom field refresh failed, keeping previous definitions: %v\n", err)
+		}
+		cancel()
+	}
+}
+
+func (r *customFieldRegistry) get(name string) (CustomField, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.fields[name]
+	return f, ok
+}
+
+// Definitions sorted by name
+func (r *customFieldRegistry) list() []CustomField {
+	r.mu.RLock()
+	list := make([]CustomField, 0, len(r.fields))
+	for _, f := range r.fields {
+		list = append(list, f)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Check custom values against the definitions, converting JSON numbers to
+// int64/float64 and filling defaults. Returns nil instead of an empty map so
+// every backend reads the user back the same way.
+func (r *customFieldRegistry) validate(custom map[string]any) (map[string]any, []*FieldError) {
+	var errs []*FieldError
+	out := map[string]any{}
+
+	keys := make([]string, 0, len(custom))
+	for k := range custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		field, ok := r.get(k)
+		if !ok {
+			errs = append(errs, &FieldError{Field: "custom." + k, Code: ErrFieldUnknown})
+			continue
+		}
+		if custom[k] == nil {
+			continue
+		}
+		v, ferr := field.coerce("custom."+k, custom[k])
+		if ferr != nil {
+			errs = append(errs, ferr)
+			continue
+		}
+		out[k] = v
+	}
+
+	for _, field := range r.list() {
+		if custom[field.Name] != nil {
+			continue // set, or already reported as invalid
+		}
+		switch {
+		case field.Default != nil:
+			out[field.Name] = field.Default
+		case field.Required:
+			errs = append(errs, &FieldError{Field: "custom." + field.Name, Code: ErrFieldRequired})
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, errs
+	}
+	return out, errs
+}
+
+// Value converted to the field type and checked against the enum
+func (f CustomField) coerce(path string, v any) (any, *FieldError) {
+	wrongType := &FieldError{Field: path, Code: ErrFieldWrongType, Params: fiber.Map{"type": f.Type}}
+
+	var value any
+	switch f.Type {
+	case CustomString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, wrongType
+		}
+		value = s
+	case CustomBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, wrongType
+		}
+		value = b
+	case CustomInteger:
+		n, ok := customNumber(v)
+		if !ok || n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+			return nil, wrongType
+		}
+		value = int64(n)
+	case CustomNumber:
+		n, ok := customNumber(v)
+		if !ok {
+			return nil, wrongType
+		}
+		value = n
+	default:
+		return nil, wrongType
+	}
+
+	if len(f.Enum) > 0 && !customInEnum(f.Enum, value) {
+		return nil, &FieldError{Field: path, Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": customChoices(f.Enum)}}
+	}
+	return value, nil
+}
+
+// Parse a query string value as the field type
+func (f CustomField) parse(path, raw string) (any, *FieldError) {
+	var (
+		v   any
+		err error
+	)
+	switch f.Type {
+	case CustomString:
+		v = raw
+	case CustomBoolean:
+		v, err = strconv.ParseBool(raw)
+	case CustomInteger:
+		v, err = strconv.ParseInt(raw, 10, 64)
+	case CustomNumber:
+		v, err = strconv.ParseFloat(raw, 64)
+	}
+	if err != nil {
+		return nil, &FieldError{Field: path, Code: ErrFieldWrongType, Params: fiber.Map{"type": f.Type}}
+	}
+	return v, nil
+}
+
+// Check a definition, normalizing its enum and default to the field type
+func (f *CustomField) check() []*FieldError {
+	var errs []*FieldError
+	if !customFieldName.MatchString(f.Name) {
+		errs = append(errs, &FieldError{Field: "name", Code: ErrFieldInvalidFormat, Params: fiber.Map{"pattern": customFieldName.String()}})
+	}
+	switch f.Type {
+	case CustomString, CustomInteger, CustomNumber, CustomBoolean:
+	default:
+		return append(errs, &FieldError{Field: "type", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "string, integer, number, boolean"}})
+	}
+
+	enum := f.Enum
+	f.Enum = nil
+	bare := *f // entries are only type checked, not against the enum being built
+	for i, v := range enum {
+		value, ferr := bare.coerce(fmt.Sprintf("enum.%d", i), v)
+		if ferr != nil {
+			errs = append(errs, ferr)
+			continue
+		}
+		f.Enum = append(f.Enum, value)
+	}
+	if f.Default != nil {
+		value, ferr := f.coerce("default", f.Default)
+		if ferr != nil {
+			errs = append(errs, ferr)
+		}
+		f.Default = value
+	}
+	return errs
+}
+
+// JSON Schema of a single value, for the OpenAPI document
+func (f CustomField) schema() fiber.Map {
+	s := fiber.Map{"type": f.Type}
+	if len(f.Enum) > 0 {
+		s["enum"] = f.Enum
+	}
+	if f.Default != nil {
+		s["default"] = f.Default
+	}
+	if f.Description != "" {
+		s["description"] = f.Description
+	}
+	return s
+}
+
+// JSON Schema of User.Custom for the current definitions. Strict is for request
+// bodies; stored users may still hold values of deleted fields.
+func (r *customFieldRegistry) schema(strict bool) fiber.Map {
+	props := fiber.Map{}
+	required := []string{}
+	for _, f := range r.list() {
+		props[f.Name] = f.schema()
+		if f.Required && f.Default == nil {
+			required = append(required, f.Name)
+		}
+	}
+	s := fiber.Map{"type": "object", "properties": props}
+	if strict {
+		s["additionalProperties"] = false
+		if len(required) > 0 {
+			s["required"] = required
+		}
+	}
+	return s
+}
+
+func customNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func customInEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if customEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func customChoices(enum []any) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Equality as Mongo sees it: numbers compare by value whatever their Go type
+func customEqual(a, b any) bool {
+	if x, ok := customNumber(a); ok {
+		y, ok := customNumber(b)
+		return ok && x == y
+	}
+	return a == b
+}
+
+// Order of two values of a field of type typ. Values of another type count as
+// missing, and missing sorts first like null does in Mongo.
+func compareCustom(typ string, a, b any) int {
+	rank := func(v any) int {
+		switch v.(type) {
+		case string:
+			if typ == CustomString {
+				return 1
+			}
+		case bool:
+			if typ == CustomBoolean {
+				return 1
+			}
+		default:
+			if _, ok := customNumber(v); ok && (typ == CustomInteger || typ == CustomNumber) {
+				return 1
+			}
+		}
+		return 0
+	}
+	ra, rb := rank(a), rank(b)
+	if ra != rb || ra == 0 {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	}
+	x, _ := customNumber(a)
+	y, _ := customNumber(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// GET /custom-fields
+func listCustomFields(c *fiber.Ctx) error {
+	return c.JSON(customFields.list())
+}
+
+// PUT /custom-fields/:name creates or replaces a definition. The type is fixed
+// once created, as stored values would no longer match it.
+func putCustomField(c *fiber.Ctx) error {
+	var field CustomField
+	if err := c.BodyParser(&field); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	field.Name = c.Params("name")
+	if errs := field.check(); len(errs) > 0 {
+		return validationError(c, errs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current CustomField
+	err := customFieldCollection.FindOne(ctx, bson.M{"_id": field.Name}).Decode(&current)
+	switch {
+	case err == nil && current.Type != field.Type:
+		return apiError(c, 409, ErrCustomFieldTypeChange, fiber.Map{"name": field.Name, "type": current.Type})
+	case err != nil && !errors.Is(err, mongo.ErrNoDocuments):
+		return apiError(c, 500, ErrCustomFieldSaveFailed)
+	}
+
+	if _, err := customFieldCollection.ReplaceOne(ctx, bson.M{"_id": field.Name}, field, options.Replace().SetUpsert(true)); err != nil {
+		return apiError(c, 500, ErrCustomFieldSaveFailed)
+	}
+	if err := customFields.load(ctx); err != nil {
+		return apiError(c, 500, ErrCustomFieldSaveFailed)
+	}
+	if field.Type == CustomString {
+		refreshTextIndex()
+	}
+
+	status := 200
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		status = 201
+	}
+	return c.Status(status).JSON(field)
+}
+
+// DELETE /custom-fields/:name. Stored values are kept but no longer accepted on write.
+func deleteCustomField(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var field CustomField
+	err := customFieldCollection.FindOneAndDelete(ctx, bson.M{"_id": c.Params("name")}).Decode(&field)
+	if err != nil {
+		return lookupError(c, err, ErrCustomFieldNotFound)
+	}
+	if err := customFields.load(ctx); err != nil {
+		return apiError(c, 500, ErrCustomFieldSaveFailed)
+	}
+	if field.Type == CustomString {
+		refreshTextIndex()
+	}
+	return c.JSON(fiber.Map{"message": "Custom field deleted", "field": field})
+}
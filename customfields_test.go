@@ -0,0 +1,181 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testRegistry() *customFieldRegistry {
+	return &customFieldRegistry{fields: map[string]CustomField{
+		"dept":   {Name: "dept", Type: CustomString, Enum: []any{"Sales", "Eng"}},
+		"level":  {Name: "level", Type: CustomInteger, Required: true},
+		"score":  {Name: "score", Type: CustomNumber},
+		"active": {Name: "active", Type: CustomBoolean, Default: true},
+	}}
+}
+
+// Field and code of each error, in order
+func errorCodes(errs []*FieldError) [][2]string {
+	var codes [][2]string
+	for _, e := range errs {
+		codes = append(codes, [2]string{e.Field, e.Code})
+	}
+	return codes
+}
+
+func TestCustomFieldValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom map[string]any
+		want   map[string]any
+		errs   [][2]string
+	}{
+		{
+			name:   "JSON numbers converted, default filled",
+			custom: map[string]any{"dept": "Eng", "level": float64(3), "score": float64(1.5)},
+			want:   map[string]any{"dept": "Eng", "level": int64(3), "score": 1.5, "active": true},
+		},
+		{
+			name:   "explicit value wins over default",
+			custom: map[string]any{"level": float64(1), "active": false},
+			want:   map[string]any{"level": int64(1), "active": false},
+		},
+		{
+			name:   "required missing",
+			custom: nil,
+			want:   map[string]any{"active": true},
+			errs:   [][2]string{{"custom.level", ErrFieldRequired}},
+		},
+		{
+			name:   "null counts as missing",
+			custom: map[string]any{"level": nil},
+			want:   map[string]any{"active": true},
+			errs:   [][2]string{{"custom.level", ErrFieldRequired}},
+		},
+		{
+			name:   "unknown field",
+			custom: map[string]any{"level": float64(1), "shoe": "42"},
+			want:   map[string]any{"level": int64(1), "active": true},
+			errs:   [][2]string{{"custom.shoe", ErrFieldUnknown}},
+		},
+		{
+			name:   "wrong types, reported in key order",
+			custom: map[string]any{"score": "high", "level": float64(1.5), "dept": float64(1)},
+			want:   map[string]any{"active": true},
+			errs: [][2]string{
+				{"custom.dept", ErrFieldWrongType},
+				{"custom.level", ErrFieldWrongType},
+				{"custom.score", ErrFieldWrongType},
+			},
+		},
+		{
+			name:   "not in enum",
+			custom: map[string]any{"dept": "sales", "level": float64(1)},
+			want:   map[string]any{"level": int64(1), "active": true},
+			errs:   [][2]string{{"custom.dept", ErrFieldInvalidChoice}},
+		},
+		{
+			name:   "integer past float precision",
+			custom: map[string]any{"level": float64(1 << 54)},
+			want:   map[string]any{"active": true},
+			errs:   [][2]string{{"custom.level", ErrFieldWrongType}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := testRegistry().validate(tt.custom)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values: got %#v, want %#v", got, tt.want)
+			}
+			if codes := errorCodes(errs); !reflect.DeepEqual(codes, tt.errs) {
+				t.Errorf("errors: got %v, want %v", codes, tt.errs)
+			}
+		})
+	}
+}
+
+func TestCustomFieldValidateEmptyIsNil(t *testing.T) {
+	r := &customFieldRegistry{fields: map[string]CustomField{}}
+	got, errs := r.validate(map[string]any{})
+	if got != nil || errs != nil {
+		t.Errorf("got %#v, %v; want nil, nil", got, errs)
+	}
+}
+
+func TestCustomFieldCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		field     CustomField
+		wantEnum  []any
+		wantDeflt any
+		errs      [][2]string
+	}{
+		{
+			name:      "integer enum and default normalized",
+			field:     CustomField{Name: "level", Type: CustomInteger, Enum: []any{float64(1), float64(2)}, Default: float64(2)},
+			wantEnum:  []any{int64(1), int64(2)},
+			wantDeflt: int64(2),
+		},
+		{
+			name:  "bad name",
+			field: CustomField{Name: "Dept", Type: CustomString},
+			errs:  [][2]string{{"name", ErrFieldInvalidFormat}},
+		},
+		{
+			name:  "unknown type",
+			field: CustomField{Name: "dept", Type: "date"},
+			errs:  [][2]string{{"type", ErrFieldInvalidChoice}},
+		},
+		{
+			name:     "enum entry of the wrong type",
+			field:    CustomField{Name: "dept", Type: CustomString, Enum: []any{"Sales", float64(3)}},
+			wantEnum: []any{"Sales"},
+			errs:     [][2]string{{"enum.1", ErrFieldWrongType}},
+		},
+		{
+			name:     "default outside the enum",
+			field:    CustomField{Name: "dept", Type: CustomString, Enum: []any{"Sales"}, Default: "Eng"},
+			wantEnum: []any{"Sales"},
+			errs:     [][2]string{{"default", ErrFieldInvalidChoice}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.field
+			errs := f.check()
+			if codes := errorCodes(errs); !reflect.DeepEqual(codes, tt.errs) {
+				t.Errorf("errors: got %v, want %v", codes, tt.errs)
+			}
+			if len(errs) > 0 && tt.wantEnum == nil {
+				return
+			}
+			if !reflect.DeepEqual(f.Enum, tt.wantEnum) {
+				t.Errorf("enum: got %#v, want %#v", f.Enum, tt.wantEnum)
+			}
+			if !reflect.DeepEqual(f.Default, tt.wantDeflt) {
+				t.Errorf("default: got %#v, want %#v", f.Default, tt.wantDeflt)
+			}
+		})
+	}
+}
+
+func TestCustomFieldParse(t *testing.T) {
+	tests := []struct {
+		typ, raw string
+		want     any
+		ok       bool
+	}{
+		{CustomString, "Sales", "Sales", true},
+		{CustomInteger, "42", int64(42), true},
+		{CustomInteger, "4.2", nil, false},
+		{CustomNumber, "4.2", 4.2, true},
+		{CustomBoolean, "true", true, true},
+		{CustomBoolean, "yes", nil, false},
+	}
+	for _, tt := range tests {
+		got, ferr := CustomField{Name: "f", Type: tt.typ}.parse("custom.f", tt.raw)
+		if (ferr == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: got %#v, %v", tt.typ, tt.raw, got, ferr)
+		}
+	}
+}
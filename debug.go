@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
+)
+
+var startedAt = time.Now()
+
+// Require "Authorization: Bearer <ADMIN_TOKEN>" or a request signed by an admin client
+func adminAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !signedAdmin(c) {
+			given := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				return apiError(c, 401, ErrAdminUnauthorized)
+			}
+			c.Locals(auditActorKey, auditActorAdmin)
+		}
+		c.Locals(auditAdminKey, true)
+		return c.Next()
+	}
+}
+
+// Admin-only routes that are refused, rather than open, when ADMIN_TOKEN is
+// not set; requests signed by an admin client are still let through
+func requireAdminToken() fiber.Handler {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return func(c *fiber.Ctx) error {
+			if !signedAdmin(c) {
+				return apiError(c, 403, ErrAdminTokenUnset)
+			}
+			c.Locals(auditAdminKey, true)
+			return c.Next()
+		}
+	}
+	return adminAuth(token)
+}
+
+// Enable block and mutex profiling from env, both are off by default
+func configureProfiling() {
+	if v := os.Getenv("DEBUG_BLOCK_PROFILE_RATE"); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			runtime.SetBlockProfileRate(rate)
+		} else {
+			fmt.Printf("⚠️  Ignoring invalid DEBUG_BLOCK_PROFILE_RATE=%q\n", v)
+		}
+	}
+	if v := os.Getenv("DEBUG_MUTEX_PROFILE_FRACTION"); v != "" {
+		if fraction, err := strconv.Atoi(v); err == nil {
+			runtime.SetMutexProfileFraction(fraction)
+		} else {
+			fmt.Printf("⚠️  Ignoring invalid DEBUG_MUTEX_PROFILE_FRACTION=%q\n", v)
+		}
+	}
+}
+
+// Register /debug/pprof/* and /debug/runtime, guards must be added by the caller
+func registerDebugRoutes(app *fiber.App) {
+	configureProfiling()
+
+	app.Use(pprof.New())
+	app.Get("/debug/runtime", runtimeStats)
+}
+
+// GET runtime stats
+func runtimeStats(c *fiber.Ctx) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var lastGC time.Time
+	if mem.LastGC > 0 {
+		lastGC = time.Unix(0, int64(mem.LastGC)).UTC()
+	}
+
+	return c.JSON(fiber.Map{
+		"go_version":     runtime.Version(),
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"memory": fiber.Map{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"stack_inuse_bytes": mem.StackInuse,
+		},
+		"gc": fiber.Map{
+			"num_gc":          mem.NumGC,
+			"pause_total_ns":  mem.PauseTotalNs,
+			"last_gc":         lastGC,
+			"next_gc_bytes":   mem.NextGC,
+			"gc_cpu_fraction": mem.GCCPUFraction,
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func testDebugApp() *fiber.App {
+	app := fiber.New()
+	app.Use("/debug", adminAuth("admin-token"))
+	registerDebugRoutes(app)
+	app.Get("/public", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	return app
+}
+
+func TestDebugRoutesNeedToken(t *testing.T) {
+	tests := []struct {
+		path, auth string
+		status     int
+	}{
+		{"/debug/runtime", "", 401},
+		{"/debug/runtime", "Bearer wrong", 401},
+		{"/debug/runtime", "Bearer admin-token", 200},
+		{"/debug/pprof/", "", 401},
+		{"/debug/pprof/", "Bearer admin-token", 200},
+		{"/debug/pprof/goroutine?debug=1", "Bearer admin-token", 200},
+		{"/public", "", 200},
+	}
+	app := testDebugApp()
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set(fiber.HeaderAuthorization, tt.auth)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s with %q: got %d, want %d", tt.path, tt.auth, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestRuntimeStats(t *testing.T) {
+	req := httptest.NewRequest("GET", "/debug/runtime", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer admin-token")
+	resp, err := testDebugApp().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var stats struct {
+		GoVersion  string `json:"go_version"`
+		Goroutines int    `json:"goroutines"`
+		Memory     struct {
+			HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+		} `json:"memory"`
+	}
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if stats.GoVersion == "" || stats.Goroutines < 1 || stats.Memory.HeapAllocBytes == 0 {
+		t.Errorf("implausible stats: %s", body)
+	}
+}
+
+// Without ADMIN_TOKEN admin routes are refused, not open
+func TestRequireAdminTokenUnset(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	app := fiber.New()
+	app.Put("/custom-fields/:name", requireAdminToken(), func(c *fiber.Ctx) error { return c.SendStatus(204) })
+
+	req := httptest.NewRequest("PUT", "/custom-fields/dept", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer ")
+	status, code := testResponse(t, app, req)
+	if status != 403 || code != ErrAdminTokenUnset {
+		t.Errorf("got %d %q, want 403 %q", status, code, ErrAdminTokenUnset)
+	}
+}
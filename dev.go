@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// Sample data for --dev, with a few near-duplicates for /users/duplicates
+var devSampleUsers = []User{
+	{Name: "Alice Martin", Age: 34},
+	{Name: "Alicia Martin", Age: 34},
+	{Name: "Bob Smith", Age: 27},
+	{Name: "Bobby Smith", Age: 28},
+	{Name: "Carol Jones", Age: 45},
+	{Name: "Dave Brown", Age: 19},
+	{Name: "Eve Davis", Age: 52},
+	{Name: "Frank Miller", Age: 61},
+	{Name: "Grace Lee", Age: 23},
+	{Name: "Heidi Wilson", Age: 38},
+	{Name: "Ivan Taylor", Age: 30},
+	{Name: "Judy Moore", Age: 0},
+}
+
+func seedDevStore(store UserStore) error {
+	list := append([]User(nil), devSampleUsers...)
+	return store.InsertMany(context.Background(), list)
+}
+
+func printDevWarning() {
+	line := strings.Repeat("⚠️ ", 30)
+	fmt.Println(line)
+	fmt.Println("⚠️  DEVELOPMENT MODE - NOT FOR PRODUCTION")
+	fmt.Println("⚠️  In-memory store: every change is lost on exit")
+	fmt.Println("⚠️  CORS allows any origin, requests are logged in full")
+	fmt.Println(line)
+}
+
+// Verbose request logging and permissive CORS for local frontends
+func registerDevMiddleware(app *fiber.App) {
+	app.Use(logger.New(logger.Config{
+		Format: "${time} ${status} ${method} ${url} ${latency} lang=${reqHeader:Accept-Language} body=${body} ${error}\n",
+	}))
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE,HEAD,OPTIONS",
+		ExposeHeaders: "Location,Retry-After,Content-Language,Preference-Applied,X-Total-Count,X-Total-Count-Estimated",
+	}))
+}
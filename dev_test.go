@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The sample users are valid and include the near-duplicates they promise
+func TestSeedDevStore(t *testing.T) {
+	store := newMemoryStore()
+	if err := seedDevStore(store); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := store.List(context.Background(), ListQuery{})
+	if len(list) != len(devSampleUsers) {
+		t.Fatalf("seeded %d users, want %d", len(list), len(devSampleUsers))
+	}
+	for i := range list {
+		if errs := validateUser(&list[i]); len(errs) > 0 {
+			t.Errorf("%s: invalid sample user: %v", list[i].Name, errs[0].Code)
+		}
+	}
+	if !devSampleUsers[0].ID.IsZero() {
+		t.Error("seeding assigned IDs to the shared sample slice")
+	}
+
+	pairs, _ := duplicatePairs(list, 0.85, maxDuplicateComparisons)
+	found := map[string]bool{}
+	for _, p := range pairs {
+		found[p.A.Name+" / "+p.B.Name] = true
+	}
+	for _, want := range []string{"Alice Martin / Alicia Martin", "Bob Smith / Bobby Smith"} {
+		if !found[want] {
+			t.Errorf("duplicates %v miss %s", found, want)
+		}
+	}
+}
+
+func TestDevMiddlewareCORS(t *testing.T) {
+	app := fiber.New()
+	registerDevMiddleware(app)
+	app.Get("/users", func(c *fiber.Ctx) error { return c.JSON([]User{}) })
+
+	req := httptest.NewRequest("OPTIONS", "/users", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 204 || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("preflight: got %d, allow origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	req = httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); got == "" {
+		t.Error("no exposed headers, clients cannot read X-Total-Count or Location")
+	}
+}
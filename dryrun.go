@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Dry run requested via "Prefer: dry-run" or ?dry_run=true
+func isDryRun(c *fiber.Ctx) bool {
+	if c.QueryBool("dry_run", false) {
+		return true
+	}
+	for _, pref := range strings.Split(c.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "dry-run") {
+			return true
+		}
+	}
+	return false
+}
+
+// Would-be result of a mutating request, nothing was persisted
+func dryRunResult(c *fiber.Ctx, status int, body fiber.Map) error {
+	c.Set("Preference-Applied", "dry-run")
+	body["dry_run"] = true
+	return c.Status(status).JSON(body)
+}
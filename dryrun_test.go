@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Swap the active store for an empty memory one for the test
+func testMemoryUsers(t *testing.T) *memoryStore {
+	t.Helper()
+	store := newMemoryStore()
+	prev := users
+	users = store
+	t.Cleanup(func() { users = prev })
+	return store
+}
+
+func TestIsDryRun(t *testing.T) {
+	tests := []struct {
+		query, prefer string
+		want          bool
+	}{
+		{"", "", false},
+		{"?dry_run=true", "", true},
+		{"?dry_run=1", "", true},
+		{"?dry_run=false", "", false},
+		{"", "dry-run", true},
+		{"", "return=minimal, Dry-Run", true},
+		{"", "return=minimal", false},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error { return c.JSON(isDryRun(c)) })
+		req := httptest.NewRequest("GET", "/"+tt.query, nil)
+		if tt.prefer != "" {
+			req.Header.Set("Prefer", tt.prefer)
+		}
+		if _, body := testResponse(t, app, req); body != map[bool]string{true: "true", false: "false"}[tt.want] {
+			t.Errorf("query %q, Prefer %q: got %s, want %v", tt.query, tt.prefer, body, tt.want)
+		}
+	}
+}
+
+// Dry runs answer like the real write but leave the store alone
+func TestLegacyUserDryRun(t *testing.T) {
+	store := testMemoryUsers(t)
+	ctx := context.Background()
+	ann := User{Name: "Ann", Age: 30, Tags: []string{"vip"}}
+	if err := store.Insert(ctx, &ann); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	legacyUserResource.Register(app)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               map[string]any
+	}{
+		{"POST", "/user", `{"name":"Ann","age":5}`, 201, map[string]any{"warnings": []any{`1 user(s) already named "Ann"`}}},
+		{"PUT", "/user/Ann", `{"name":"Anna","age":31}`, 200, map[string]any{"before": map[string]any{"_id": ann.ID.Hex(), "name": "Ann", "age": 30.0, "tags": []any{"vip"}}}},
+		{"DELETE", "/user/Ann", "", 200, map[string]any{}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path+"?dry_run=true", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		var body map[string]any
+		json.Unmarshal(raw, &body)
+		if resp.StatusCode != tt.status || body["dry_run"] != true || resp.Header.Get("Preference-Applied") != "dry-run" {
+			t.Errorf("%s %s: got %d %s, want %d dry run", tt.method, tt.path, resp.StatusCode, raw, tt.status)
+		}
+		for k, v := range tt.want {
+			if !reflect.DeepEqual(body[k], v) {
+				t.Errorf("%s %s: %s = %v, want %v", tt.method, tt.path, k, body[k], v)
+			}
+		}
+	}
+
+	list, _ := store.List(ctx, ListQuery{})
+	if len(list) != 1 || !reflect.DeepEqual(list[0], ann) {
+		t.Errorf("store changed by dry runs: %+v", list)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Old user ID pointing at the user it was merged into
+type Redirect struct {
+	ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	TargetID primitive.ObjectID `json:"target_id" bson:"target_id"`
+	MergedAt time.Time          `json:"merged_at" bson:"merged_at"`
+}
+
+// Candidate duplicate pair
+type DuplicatePair struct {
+	A         User    `json:"a"`
+	B         User    `json:"b"`
+	Score     float64 `json:"score"`
+	NameScore float64 `json:"name_score"`
+	AgeScore  float64 `json:"age_score"`
+}
+
+// Lowercase, drop punctuation, collapse whitespace
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Jaro-Winkler similarity in [0,1]
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	window := max(len(ra), len(rb))/2 - 1
+	window = max(window, 0)
+	matchA := make([]bool, len(ra))
+	matchB := make([]bool, len(rb))
+
+	matches := 0
+	for i := range ra {
+		lo, hi := max(0, i-window), min(len(rb), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchB[j] && ra[i] == rb[j] {
+				matchA[i], matchB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, j := 0, 0
+	for i := range ra {
+		if !matchA[i] {
+			continue
+		}
+		for !matchB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// Name similarity, tolerant to word order ("Smith John")
+func nameSimilarity(a, b string) float64 {
+	na, nb := normalizeName(a), normalizeName(b)
+	if na == nb {
+		return 1
+	}
+	ta, tb := strings.Fields(na), strings.Fields(nb)
+	sort.Strings(ta)
+	sort.Strings(tb)
+	return max(jaroWinkler(na, nb), jaroWinkler(strings.Join(ta, " "), strings.Join(tb, " ")))
+}
+
+// Age proximity, unknown (0) ages are neutral
+func ageSimilarity(a, b int) float64 {
+	if a == 0 || b == 0 {
+		return 0.5
+	}
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return max(0, 1-float64(diff)/10)
+}
+
+func scorePair(a, b User) DuplicatePair {
+	name := nameSimilarity(a.Name, b.Name)
+	age := ageSimilarity(a.Age, b.Age)
+	return DuplicatePair{A: a, B: b, NameScore: name, AgeScore: age, Score: 0.8*name + 0.2*age}
+}
+
+// GET /users/duplicates?threshold=0.85&limit=50
+func findDuplicates(c *fiber.Ctx) error {
+	threshold := c.QueryFloat("threshold", 0.85)
+	limit := c.QueryInt("limit", 50)
+	if threshold < 0 || threshold > 1 || limit < 1 {
+		return apiError(c, 400, ErrInvalidQuery, fiber.Map{"param": "threshold/limit"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := users.List(ctx, ListQuery{})
+	if err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+
+	pairs, truncated := duplicatePairs(list, threshold, maxDuplicateComparisons)
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	if truncated {
+		c.Set("X-Duplicates-Truncated", "true")
+	}
+	return c.JSON(pairs)
+}
+
+// Pairs scored per request; past it the scan stops and says so
+const maxDuplicateComparisons = 1_000_000
+
+// Blocking keys of a name: the first two letters of each word. Only users
+// sharing a key are compared, so "Jon Smith" meets "Smith, John" but a typo in
+// the first letters of every word goes unnoticed.
+func duplicateKeys(name string) []string {
+	var keys []string
+	for _, word := range strings.Fields(normalizeName(name)) {
+		runes := []rune(word)
+		key := string(runes[:min(2, len(runes))])
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Candidate pairs scoring at least threshold, best first. Users are compared
+// within their blocks, each pair once under the first key they share;
+// truncated is set when maxComparisons ran out.
+func duplicatePairs(list []User, threshold float64, maxComparisons int) (pairs []DuplicatePair, truncated bool) {
+	keys := make([][]string, len(list))
+	blocks := map[string][]int{}
+	for i, u := range list {
+		keys[i] = duplicateKeys(u.Name)
+		for _, k := range keys[i] {
+			blocks[k] = append(blocks[k], i)
+		}
+	}
+	order := make([]string, 0, len(blocks))
+	for k := range blocks {
+		order = append(order, k)
+	}
+	sort.Strings(order)
+
+	pairs = []DuplicatePair{}
+	comparisons := 0
+scan:
+	for _, k := range order {
+		block := blocks[k]
+		for x := range block {
+			for _, j := range block[x+1:] {
+				i := block[x]
+				if firstShared(keys[i], keys[j]) != k {
+					continue
+				}
+				if comparisons == maxComparisons {
+					truncated = true
+					break scan
+				}
+				comparisons++
+				if pair := scorePair(list[i], list[j]); pair.Score >= threshold {
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Score > pairs[j].Score })
+	return pairs, truncated
+}
+
+// First key of two sorted lists found in both
+func firstShared(a, b []string) string {
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			return a[i]
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return ""
+}
+
+// Merge request, Resolve maps field -> "source" | "target" (ages also "min" | "max")
+type MergeRequest struct {
+	SourceID primitive.ObjectID `json:"source_id"`
+	TargetID primitive.ObjectID `json:"target_id"`
+	Resolve  map[string]string  `json:"resolve"`
+}
+
+// Field that differed between source and target
+type MergeConflict struct {
+	Field  string `json:"field"`
+	Source any    `json:"source"`
+	Target any    `json:"target"`
+	Chosen string `json:"chosen"`
+}
+
+// Combine source into target. Target wins by default, empty target fields are filled from source.
+func mergeUsers(source, target User, resolve map[string]string) (User, []MergeConflict, *FieldError) {
+	merged := target
+	conflicts := []MergeConflict{}
+
+	switch {
+	case target.Name == "":
+		merged.Name = source.Name
+	case source.Name != "" && source.Name != target.Name:
+		choice := resolve["name"]
+		switch choice {
+		case "source":
+			merged.Name = source.Name
+		case "", "target":
+			choice = "target"
+		default:
+			return User{}, nil, &FieldError{Field: "resolve.name", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "source, target"}}
+		}
+		conflicts = append(conflicts, MergeConflict{Field: "name", Source: source.Name, Target: target.Name, Chosen: choice})
+	}
+
+	switch {
+	case target.Age == 0:
+		merged.Age = source.Age
+	case source.Age != 0 && source.Age != target.Age:
+		choice := resolve["age"]
+		switch choice {
+		case "source":
+			merged.Age = source.Age
+		case "min":
+			merged.Age = min(source.Age, target.Age)
+		case "max":
+			merged.Age = max(source.Age, target.Age)
+		case "", "target":
+			choice = "target"
+		default:
+			return User{}, nil, &FieldError{Field: "resolve.age", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "source, target, min, max"}}
+		}
+		conflicts = append(conflicts, MergeConflict{Field: "age", Source: source.Age, Target: target.Age, Chosen: choice})
+	}
+
+	switch {
+	case target.Location == nil:
+		merged.Location = source.Location
+	case source.Location != nil && !slices.Equal(source.Location.Coordinates, target.Location.Coordinates):
+		choice := resolve["location"]
+		switch choice {
+		case "source":
+			merged.Location = source.Location
+		case "", "target":
+			choice = "target"
+		default:
+			return User{}, nil, &FieldError{Field: "resolve.location", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "source, target"}}
+		}
+		conflicts = append(conflicts, MergeConflict{Field: "location", Source: source.Location, Target: target.Location, Chosen: choice})
+	}
+
+	// Tags are unioned, target first; past maxTags the source's extra ones are dropped
+	merged.Tags = slices.Clone(target.Tags)
+	for _, tag := range source.Tags {
+		if len(merged.Tags) < maxTags && !slices.Contains(merged.Tags, tag) {
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+
+	// Custom fields one by one, resolved as "custom.<field>"
+	keys := make([]string, 0, len(source.Custom))
+	for k := range source.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		// Copy, the target map belongs to the store
+		merged.Custom = make(map[string]any, len(target.Custom)+len(keys))
+		maps.Copy(merged.Custom, target.Custom)
+	}
+	for _, k := range keys {
+		sv, tv := source.Custom[k], target.Custom[k]
+		switch {
+		case tv == nil:
+			merged.Custom[k] = sv
+		case sv != nil && !customEqual(sv, tv):
+			path := "custom." + k
+			choice := resolve[path]
+			switch choice {
+			case "source":
+				merged.Custom[k] = sv
+			case "", "target":
+				choice = "target"
+			default:
+				return User{}, nil, &FieldError{Field: "resolve." + path, Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "source, target"}}
+			}
+			conflicts = append(conflicts, MergeConflict{Field: path, Source: sv, Target: tv, Chosen: choice})
+		}
+	}
+
+	return merged, conflicts, nil
+}
+
+// POST /users/merge
+func mergeUsersHandler(c *fiber.Ctx) error {
+	var req MergeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	if req.SourceID.IsZero() || req.TargetID.IsZero() {
+		return apiError(c, 400, ErrMergeIDsRequired)
+	}
+	if req.SourceID == req.TargetID {
+		return apiError(c, 400, ErrMergeSelf)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	source, err := users.Get(ctx, req.SourceID)
+	if err != nil {
+		return lookupError(c, err, ErrSourceNotFound)
+	}
+	target, err := users.Get(ctx, req.TargetID)
+	if err != nil {
+		return lookupError(c, err, ErrTargetNotFound)
+	}
+
+	merged, conflicts, ferr := mergeUsers(source, target, req.Resolve)
+	if ferr != nil {
+		return validationError(c, []*FieldError{ferr})
+	}
+
+	if isDryRun(c) {
+		redirect := Redirect{ID: source.ID, TargetID: target.ID, MergedAt: time.Now().UTC()}
+		return dryRunResult(c, 200, fiber.Map{
+			"message":   "Users would be merged",
+			"user":      merged,
+			"conflicts": conflicts,
+			"redirect":  redirect,
+		})
+	}
+
+	redirect := Redirect{ID: source.ID, TargetID: target.ID, MergedAt: time.Now().UTC()}
+	if err := withMongoTransaction(ctx, func(ctx context.Context) error {
+		return commitMerge(ctx, merged, source.ID, redirect)
+	}); err != nil {
+		fmt.Printf("⚠️  Merge of %s into %s failed: %v\n", source.ID.Hex(), target.ID.Hex(), err)
+		return apiError(c, 500, ErrMergeFailed)
+	}
+
+	return c.JSON(fiber.Map{
+		"message":   "Users merged successfully",
+		"user":      merged,
+		"conflicts": conflicts,
+		"redirect":  redirect,
+	})
+}
+
+// Write a merge. Order matters without a transaction: the source is only
+// deleted once the target is written, and the redirect comes last so it never
+// points at a target that was not saved. Each user keeps its own history: the
+// target gets one "merge" entry, the source's ends with its deletion.
+func commitMerge(ctx context.Context, merged User, sourceID primitive.ObjectID, redirect Redirect) error {
+	var err error
+	if h, ok := users.(*historyStore); ok {
+		err = h.UpsertMerged(ctx, merged, sourceID)
+	} else {
+		err = users.Upsert(ctx, merged)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := users.Delete(ctx, sourceID); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if _, err := redirectCollection.ReplaceOne(ctx, bson.M{"_id": sourceID}, redirect, options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+	// Collapse chains: anything merged into the source now points at the target
+	_, err = redirectCollection.UpdateMany(ctx, bson.M{"target_id": sourceID}, bson.M{"$set": bson.M{"target_id": merged.ID}})
+	return err
+}
+
+// Whether writes can share a transaction: replica sets and sharded clusters
+// run them, a standalone server does not, and the migration secondary's
+// client cannot join one
+func mongoTransactions(ctx context.Context) bool {
+	if dualWriting {
+		return false
+	}
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// Run fn in a transaction where MongoDB supports one, directly otherwise
+func withMongoTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !mongoTransactions(ctx) {
+		return fn(ctx)
+	}
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sctx mongo.SessionContext) (any, error) {
+		return nil, fn(sctx)
+	})
+	return err
+}
+
+// GET /users/:id for an ID merged into another user answers 301 to the survivor
+func followRedirect(c *fiber.Ctx, key string) error {
+	if redirectCollection == nil {
+		return apiError(c, 404, ErrUserNotFound)
+	}
+	id, _ := primitive.ObjectIDFromHex(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var redirect Redirect
+	if err := redirectCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&redirect); err != nil {
+		return lookupError(c, err, ErrUserNotFound)
+	}
+	return c.Redirect("/users/"+redirect.TargetID.Hex(), fiber.StatusMovedPermanently)
+}
+
+// 404 for missing documents, 500 otherwise
+func lookupError(c *fiber.Ctx, err error, notFound string) error {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
+		return apiError(c, 404, notFound)
+	}
+	return apiError(c, 500, ErrFetchFailed)
+}
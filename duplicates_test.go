@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"John Smith", "john  smith!", 1, 1},
+		{"John Smith", "Smith, John", 1, 1},
+		{"John Smith", "Jon Smith", 0.9, 0.99},
+		{"John Smith", "Mary Jones", 0, 0.8},
+		{"", "", 1, 1},
+		{"Ann", "", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := nameSimilarity(tt.a, tt.b); got < tt.min || got > tt.max {
+			t.Errorf("nameSimilarity(%q, %q) = %.3f, want in [%.2f, %.2f]", tt.a, tt.b, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"John Smith", []string{"jo", "sm"}},
+		{"Smith, John", []string{"jo", "sm"}},
+		{"Jo Jones", []string{"jo"}},
+		{"Ó X", []string{"x", "ó"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := duplicateKeys(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("duplicateKeys(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFirstShared(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want string
+	}{
+		{[]string{"jo", "sm"}, []string{"sm", "zz"}, "sm"},
+		{[]string{"ab", "jo", "sm"}, []string{"jo", "sm"}, "jo"},
+		{[]string{"ab"}, []string{"cd"}, ""},
+		{nil, []string{"cd"}, ""},
+	}
+	for _, tt := range tests {
+		if got := firstShared(tt.a, tt.b); got != tt.want {
+			t.Errorf("firstShared(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicatePairs(t *testing.T) {
+	list := []User{
+		{Name: "John Smith", Age: 40},
+		{Name: "Mary Jones", Age: 30},
+		{Name: "Smith, John", Age: 40},
+		{Name: "Jon Smith", Age: 41},
+		{Name: "Peter Pan", Age: 12},
+	}
+
+	pairs, truncated := duplicatePairs(list, 0.85, maxDuplicateComparisons)
+	if truncated {
+		t.Error("truncated without reaching the cap")
+	}
+	var got []string
+	for _, p := range pairs {
+		got = append(got, p.A.Name+" / "+p.B.Name)
+	}
+	// Each pair once, even when it shares both keys, best first
+	want := []string{"John Smith / Smith, John", "John Smith / Jon Smith", "Smith, John / Jon Smith"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i].Score > pairs[i-1].Score {
+			t.Errorf("pairs not sorted by score: %v", pairs)
+		}
+	}
+
+	if pairs, _ := duplicatePairs(list, 1.01, maxDuplicateComparisons); len(pairs) != 0 {
+		t.Errorf("threshold above 1: got %d pairs", len(pairs))
+	}
+}
+
+func TestDuplicatePairsTruncated(t *testing.T) {
+	list := make([]User, 10)
+	for i := range list {
+		list[i] = User{Name: fmt.Sprintf("Ann %d", i)}
+	}
+	// 10 users in one block make 45 comparisons
+	if _, truncated := duplicatePairs(list, 0, 45); truncated {
+		t.Error("45 comparisons allowed: got truncated")
+	}
+	pairs, truncated := duplicatePairs(list, 0, 10)
+	if !truncated || len(pairs) != 10 {
+		t.Errorf("10 comparisons allowed: got %d pairs, truncated %v", len(pairs), truncated)
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	here, there := &GeoPoint{Type: "Point", Coordinates: []float64{1, 2}}, &GeoPoint{Type: "Point", Coordinates: []float64{3, 4}}
+	source := User{Name: "Jon Smith", Age: 41, Location: here, Tags: []string{"b", "c"}, Custom: map[string]any{"dept": "ops", "team": "red"}}
+	target := User{Name: "John Smith", Age: 40, Tags: []string{"a", "b"}, Custom: map[string]any{"dept": "dev"}}
+
+	tests := []struct {
+		name      string
+		source    User
+		target    User
+		resolve   map[string]string
+		want      User
+		conflicts []string
+		errField  string
+	}{
+		{
+			name: "target wins, gaps filled from source", source: source, target: target,
+			want:      User{Name: "John Smith", Age: 40, Location: here, Tags: []string{"a", "b", "c"}, Custom: map[string]any{"dept": "dev", "team": "red"}},
+			conflicts: []string{"name:target", "age:target", "custom.dept:target"},
+		},
+		{
+			name: "resolved to source", source: source, target: target,
+			resolve:   map[string]string{"name": "source", "age": "max", "custom.dept": "source"},
+			want:      User{Name: "Jon Smith", Age: 41, Location: here, Tags: []string{"a", "b", "c"}, Custom: map[string]any{"dept": "ops", "team": "red"}},
+			conflicts: []string{"name:source", "age:max", "custom.dept:source"},
+		},
+		{
+			name: "location conflict", source: User{Location: here}, target: User{Name: "Ann", Location: there},
+			resolve:   map[string]string{"location": "source"},
+			want:      User{Name: "Ann", Location: here},
+			conflicts: []string{"location:source"},
+		},
+		{name: "unknown age choice", source: source, target: target, resolve: map[string]string{"age": "avg"}, errField: "resolve.age"},
+		{name: "unknown custom choice", source: source, target: target, resolve: map[string]string{"custom.dept": "both"}, errField: "resolve.custom.dept"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts, ferr := mergeUsers(tt.source, tt.target, tt.resolve)
+			if tt.errField != "" {
+				if ferr == nil || ferr.Field != tt.errField || ferr.Code != ErrFieldInvalidChoice {
+					t.Fatalf("got error %+v, want %s on %s", ferr, ErrFieldInvalidChoice, tt.errField)
+				}
+				return
+			}
+			if ferr != nil {
+				t.Fatalf("unexpected error %+v", ferr)
+			}
+			if !reflect.DeepEqual(merged, tt.want) {
+				t.Errorf("merged %+v, want %+v", merged, tt.want)
+			}
+			var got []string
+			for _, c := range conflicts {
+				got = append(got, c.Field+":"+c.Chosen)
+			}
+			if !reflect.DeepEqual(got, tt.conflicts) {
+				t.Errorf("conflicts %q, want %q", got, tt.conflicts)
+			}
+		})
+	}
+
+	// The target's custom map belongs to the store and stays untouched
+	if len(target.Custom) != 1 || target.Custom["dept"] != "dev" {
+		t.Errorf("target custom fields changed: %v", target.Custom)
+	}
+}
+
+func TestMergeUsersTagLimit(t *testing.T) {
+	var source, target User
+	for i := range maxTags {
+		target.Tags = append(target.Tags, fmt.Sprintf("t%d", i))
+		source.Tags = append(source.Tags, fmt.Sprintf("s%d", i))
+	}
+	merged, _, _ := mergeUsers(source, target, nil)
+	if len(merged.Tags) != maxTags || merged.Tags[0] != "t0" {
+		t.Errorf("got %d tags starting %q, want the target's %d", len(merged.Tags), merged.Tags[0], maxTags)
+	}
+}
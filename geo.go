@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Earth radius used by MongoDB for spherical distances, in meters
+const earthRadius = 6378100.0
+
+// Largest /users/near radius: half the equator
+const maxNearRadius = 20_037_500.0
+
+// GeoJSON point, coordinates are [longitude, latitude]
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+func newGeoPoint(lng, lat float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+func (p *GeoPoint) lng() float64 { return p.Coordinates[0] }
+func (p *GeoPoint) lat() float64 { return p.Coordinates[1] }
+
+func (p *GeoPoint) clone() *GeoPoint {
+	if p == nil {
+		return nil
+	}
+	return &GeoPoint{Type: p.Type, Coordinates: append([]float64(nil), p.Coordinates...)}
+}
+
+// Check a location: a Point with a longitude in [-180, 180] and a latitude in [-90, 90]
+func (p *GeoPoint) check(path string) []*FieldError {
+	if p.Type != "Point" {
+		return []*FieldError{{Field: path + ".type", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "Point"}}}
+	}
+	if len(p.Coordinates) != 2 {
+		return []*FieldError{{Field: path + ".coordinates", Code: ErrFieldCoordinates}}
+	}
+	var errs []*FieldError
+	if ferr := checkCoordinate(path+".coordinates.0", p.lng(), 180); ferr != nil {
+		errs = append(errs, ferr)
+	}
+	if ferr := checkCoordinate(path+".coordinates.1", p.lat(), 90); ferr != nil {
+		errs = append(errs, ferr)
+	}
+	return errs
+}
+
+func checkCoordinate(path string, v, max float64) *FieldError {
+	if math.IsNaN(v) || v < -max || v > max {
+		return &FieldError{Field: path, Code: ErrFieldOutOfRange, Params: fiber.Map{"min": -max, "max": max}}
+	}
+	return nil
+}
+
+// Great circle distance in meters
+func geoDistance(a, b *GeoPoint) float64 {
+	rad := math.Pi / 180
+	lat1, lat2 := a.lat()*rad, b.lat()*rad
+	dLat, dLng := lat2-lat1, (b.lng()-a.lng())*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Users within Radius meters of Center
+type GeoCircle struct {
+	Center *GeoPoint
+	Radius float64
+}
+
+func (g GeoCircle) contains(p *GeoPoint) bool {
+	return p != nil && geoDistance(g.Center, p) <= g.Radius
+}
+
+// Mongo filter, $centerSphere takes the radius in radians
+func (g GeoCircle) mongo() bson.M {
+	return bson.M{"location": bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{g.Center.Coordinates, g.Radius / earthRadius},
+	}}}
+}
+
+// Longitude/latitude rectangle, inclusive. MinLng > MaxLng crosses the antimeridian.
+type GeoBox struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+func (b GeoBox) wraps() bool {
+	return b.MinLng > b.MaxLng
+}
+
+func (b GeoBox) contains(p *GeoPoint) bool {
+	if p == nil || p.lat() < b.MinLat || p.lat() > b.MaxLat {
+		return false
+	}
+	if b.wraps() {
+		return p.lng() >= b.MinLng || p.lng() <= b.MaxLng
+	}
+	return p.lng() >= b.MinLng && p.lng() <= b.MaxLng
+}
+
+// Mongo filter as coordinate ranges, matching contains exactly; a 2dsphere
+// $geoWithin polygon would follow great circles instead of parallels
+func (b GeoBox) mongo() bson.M {
+	lat := bson.M{"location.coordinates.1": bson.M{"$gte": b.MinLat, "$lte": b.MaxLat}}
+	if b.wraps() {
+		return bson.M{"$and": bson.A{lat, bson.M{"$or": bson.A{
+			bson.M{"location.coordinates.0": bson.M{"$gte": b.MinLng}},
+			bson.M{"location.coordinates.0": bson.M{"$lte": b.MaxLng}},
+		}}}}
+	}
+	return bson.M{"$and": bson.A{lat, bson.M{"location.coordinates.0": bson.M{"$gte": b.MinLng, "$lte": b.MaxLng}}}}
+}
+
+// ?bbox=minLng,minLat,maxLng,maxLat
+func parseBBox(param, raw string) (any, *FieldError) {
+	invalid := &FieldError{Field: param, Code: ErrFieldInvalidFormat, Params: fiber.Map{"pattern": "minLng,minLat,maxLng,maxLat"}}
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return nil, invalid
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, invalid
+		}
+		v[i] = f
+	}
+	box := GeoBox{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+	for i, max := range []float64{180, 90, 180, 90} {
+		if ferr := checkCoordinate(param, v[i], max); ferr != nil {
+			return nil, ferr
+		}
+	}
+	if box.MinLat > box.MaxLat {
+		return nil, invalid
+	}
+	return box, nil
+}
+
+// Float query parameter within [min, max]
+func floatQuery(c *fiber.Ctx, param string, min, max float64) (float64, *FieldError) {
+	raw := c.Query(param)
+	if raw == "" {
+		return 0, &FieldError{Field: param, Code: ErrFieldRequired}
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || v < min || v > max {
+		return 0, &FieldError{Field: param, Code: ErrFieldOutOfRange, Params: fiber.Map{"min": min, "max": max}}
+	}
+	return v, nil
+}
+
+// User with its distance from the queried point, in meters
+type UserDistance struct {
+	User
+	Distance float64 `json:"distance"`
+}
+
+// GET /users/near?lat=&lng=&radius= lists users within radius meters, nearest first
+func usersNear(c *fiber.Ctx) error {
+	var errs []*FieldError
+	lat, ferr := floatQuery(c, "lat", -90, 90)
+	if ferr != nil {
+		errs = append(errs, ferr)
+	}
+	lng, ferr := floatQuery(c, "lng", -180, 180)
+	if ferr != nil {
+		errs = append(errs, ferr)
+	}
+	radius, ferr := floatQuery(c, "radius", 0, maxNearRadius)
+	if ferr != nil {
+		errs = append(errs, ferr)
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > maxListLimit {
+		errs = append(errs, &FieldError{Field: "limit", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 1, "max": maxListLimit}})
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		errs = append(errs, &FieldError{Field: "offset", Code: ErrFieldTooSmall, Params: fiber.Map{"min": 0}})
+	}
+	if len(errs) > 0 {
+		return validationError(c, errs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	center := newGeoPoint(lng, lat)
+	list, err := users.List(ctx, ListQuery{Near: &GeoCircle{Center: center, Radius: radius}, Limit: limit, Offset: offset})
+	if err != nil {
+		fmt.Printf("⚠️  Near query failed: %v\n", err)
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	result := make([]UserDistance, len(list))
+	for i, u := range list {
+		result[i] = UserDistance{User: u, Distance: math.Round(geoDistance(center, u.Location)*100) / 100}
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGeoPointCheck(t *testing.T) {
+	tests := []struct {
+		point GeoPoint
+		want  [][2]string
+	}{
+		{GeoPoint{Type: "Point", Coordinates: []float64{2.35, 48.85}}, nil},
+		{GeoPoint{Type: "Point", Coordinates: []float64{-180, 90}}, nil},
+		{GeoPoint{Type: "Polygon", Coordinates: []float64{0, 0}}, [][2]string{{"location.type", ErrFieldInvalidChoice}}},
+		{GeoPoint{Type: "Point", Coordinates: []float64{0}}, [][2]string{{"location.coordinates", ErrFieldCoordinates}}},
+		{GeoPoint{Type: "Point", Coordinates: []float64{48.85, 180.1}}, [][2]string{{"location.coordinates.1", ErrFieldOutOfRange}}},
+		{GeoPoint{Type: "Point", Coordinates: []float64{-181, math.NaN()}}, [][2]string{{"location.coordinates.0", ErrFieldOutOfRange}, {"location.coordinates.1", ErrFieldOutOfRange}}},
+	}
+	for _, tt := range tests {
+		if got := errorCodes(tt.point.check("location")); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.point, got, tt.want)
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	paris, london := newGeoPoint(2.3522, 48.8566), newGeoPoint(-0.1276, 51.5072)
+	tests := []struct {
+		name     string
+		a, b     *GeoPoint
+		min, max float64
+	}{
+		{"same point", paris, paris, 0, 0},
+		{"paris to london", paris, london, 343_000, 345_000},
+		{"across the antimeridian", newGeoPoint(179.5, 0), newGeoPoint(-179.5, 0), 111_000, 111_400},
+		{"antipodes", newGeoPoint(0, 0), newGeoPoint(180, 0), maxNearRadius - 1000, maxNearRadius + 1000},
+	}
+	for _, tt := range tests {
+		if got := geoDistance(tt.a, tt.b); got < tt.min || got > tt.max {
+			t.Errorf("%s: got %.0f m, want in [%.0f, %.0f]", tt.name, got, tt.min, tt.max)
+		}
+		if geoDistance(tt.a, tt.b) != geoDistance(tt.b, tt.a) {
+			t.Errorf("%s: distance not symmetric", tt.name)
+		}
+	}
+}
+
+func TestGeoCircleContains(t *testing.T) {
+	near := GeoCircle{Center: newGeoPoint(180, -16.75), Radius: 200_000}
+	tests := []struct {
+		p    *GeoPoint
+		want bool
+	}{
+		{newGeoPoint(179.5, -16.5), true},
+		{newGeoPoint(-179.5, -17), true},
+		{newGeoPoint(175, -16.75), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := near.contains(tt.p); got != tt.want {
+			t.Errorf("contains(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want any
+		code string
+	}{
+		{"-1,45,3,52", GeoBox{MinLng: -1, MinLat: 45, MaxLng: 3, MaxLat: 52}, ""},
+		{" 179 , -20, -179, -10", GeoBox{MinLng: 179, MinLat: -20, MaxLng: -179, MaxLat: -10}, ""},
+		{"1,2,3", nil, ErrFieldInvalidFormat},
+		{"a,2,3,4", nil, ErrFieldInvalidFormat},
+		{"0,10,1,5", nil, ErrFieldInvalidFormat},
+		{"-181,0,1,1", nil, ErrFieldOutOfRange},
+		{"0,0,1,91", nil, ErrFieldOutOfRange},
+	}
+	for _, tt := range tests {
+		got, ferr := parseBBox("bbox", tt.raw)
+		code := ""
+		if ferr != nil {
+			code = ferr.Code
+		}
+		if code != tt.code || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBBox(%q) = %v %q, want %v %q", tt.raw, got, code, tt.want, tt.code)
+		}
+	}
+}
+
+func TestGeoBoxContains(t *testing.T) {
+	france := GeoBox{MinLng: -5, MinLat: 42, MaxLng: 8, MaxLat: 51}
+	fiji := GeoBox{MinLng: 177, MinLat: -20, MaxLng: -178, MaxLat: -12}
+	tests := []struct {
+		name string
+		box  GeoBox
+		p    *GeoPoint
+		want bool
+	}{
+		{"inside", france, newGeoPoint(2.35, 48.85), true},
+		{"on the edge", france, newGeoPoint(8, 42), true},
+		{"east of it", france, newGeoPoint(13.4, 48.85), false},
+		{"north of it", france, newGeoPoint(2.35, 52), false},
+		{"wrapping, east side", fiji, newGeoPoint(178.4, -18.1), true},
+		{"wrapping, west side", fiji, newGeoPoint(-179.9, -16), true},
+		{"wrapping, between", fiji, newGeoPoint(0, -16), false},
+		{"no location", france, nil, false},
+	}
+	for _, tt := range tests {
+		if got := tt.box.contains(tt.p); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUsersNear(t *testing.T) {
+	store := testMemoryUsers(t)
+	for _, u := range []User{
+		{Name: "Lyon", Location: newGeoPoint(4.8357, 45.764)},
+		{Name: "Paris", Location: newGeoPoint(2.3522, 48.8566)},
+		{Name: "London", Location: newGeoPoint(-0.1276, 51.5072)},
+		{Name: "Nowhere"},
+	} {
+		if err := store.Insert(context.Background(), &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/users/near", usersNear)
+
+	req := httptest.NewRequest("GET", "/users/near?lat=48.85&lng=2.35&radius=500000", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []UserDistance
+	json.NewDecoder(resp.Body).Decode(&list)
+	var names []string
+	for _, u := range list {
+		names = append(names, u.Name)
+	}
+	if resp.StatusCode != 200 || !reflect.DeepEqual(names, []string{"Paris", "London", "Lyon"}) {
+		t.Fatalf("got %d %v, want Paris, London, Lyon", resp.StatusCode, names)
+	}
+	if list[0].Distance > 1000 || list[1].Distance < 340_000 || list[1].Distance > 350_000 {
+		t.Errorf("distances %.2f, %.2f", list[0].Distance, list[1].Distance)
+	}
+
+	for _, query := range []string{"lat=48.85&lng=2.35", "lat=91&lng=0&radius=1", "lat=0&lng=0&radius=-1", "lat=0&lng=0&radius=1&limit=0"} {
+		req := httptest.NewRequest("GET", "/users/near?"+query, nil)
+		if status, code := testResponse(t, app, req); status != 400 || code != ErrValidationFailed {
+			t.Errorf("%s: got %d %q, want 400 %q", query, status, code, ErrValidationFailed)
+		}
+	}
+}
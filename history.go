@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// History operations
+const (
+	HistoryCreate = "create"
+	HistoryUpdate = "update"
+	HistoryDelete = "delete"
+	HistoryMerge  = "merge" // on the target; the source's own history ends with its delete
+)
+
+// One change to a user, Before is nil for creates and After for deletes
+type HistoryEntry struct {
+	ID         primitive.ObjectID  `json:"_id" bson:"_id"`
+	UserID     primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Op         string              `json:"op" bson:"op"`
+	At         time.Time           `json:"at" bson:"at"`
+	Before     *User               `json:"before,omitempty" bson:"before,omitempty"`
+	After      *User               `json:"after,omitempty" bson:"after,omitempty"`
+	MergedFrom *primitive.ObjectID `json:"merged_from,omitempty" bson:"merged_from,omitempty"` // merges only
+}
+
+// Undo the change in state, entries are undone newest first
+func (e HistoryEntry) undo(state map[primitive.ObjectID]User) {
+	if e.Before == nil {
+		delete(state, e.UserID)
+	} else {
+		state[e.UserID] = *e.Before
+	}
+}
+
+var (
+	historyCollection     *mongo.Collection
+	historyMetaCollection *mongo.Collection
+	// Zero while history is not recorded
+	historyStartedAt time.Time
+)
+
+// Start of recorded history, set once when history is first enabled
+func ensureHistory(ctx context.Context) (time.Time, error) {
+	_, err := historyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "at", Value: 1}}},
+		{Keys: bson.D{{Key: "merged_from", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var meta struct {
+		StartedAt time.Time `bson:"started_at"`
+	}
+	err = historyMetaCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": "users"},
+		bson.M{"$setOnInsert": bson.M{"started_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&meta)
+	return meta.StartedAt, err
+}
+
+// Records every change of the wrapped store into the history collection
+type historyStore struct {
+	UserStore
+}
+
+func newHistoryStore(inner UserStore) *historyStore {
+	return &historyStore{UserStore: inner}
+}
+
+// History failures are logged, the write itself already happened
+func (s *historyStore) record(ctx context.Context, op string, before, after *User) {
+	entry := HistoryEntry{Op: op, Before: before, After: after}
+	if after != nil {
+		entry.UserID = after.ID
+	} else {
+		entry.UserID = before.ID
+	}
+	s.insert(ctx, entry)
+}
+
+func (s *historyStore) insert(ctx context.Context, entry HistoryEntry) {
+	entry.ID, entry.At = primitive.NewObjectID(), time.Now().UTC()
+	if _, err := historyCollection.InsertOne(ctx, entry); err != nil {
+		metrics.Inc("history_write_errors_total")
+		fmt.Printf("⚠️  Failed to record history for %s: %v\n", entry.UserID.Hex(), err)
+	}
+}
+
+func (s *historyStore) Insert(ctx context.Context, user *User) error {
+	if err := s.UserStore.Insert(ctx, user); err != nil {
+		return err
+	}
+	after := *user
+	s.record(ctx, HistoryCreate, nil, &after)
+	return nil
+}
+
+func (s *historyStore) InsertMany(ctx context.Context, list []User) error {
+	if err := s.UserStore.InsertMany(ctx, list); err != nil {
+		return err
+	}
+	for i := range list {
+		after := list[i]
+		s.record(ctx, HistoryCreate, nil, &after)
+	}
+	return nil
+}
+
+func (s *historyStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	before, err := s.UserStore.FindByName(ctx, name)
+	if err != nil {
+		return User{}, err
+	}
+	after, err := s.UserStore.UpdateByName(ctx, name, update)
+	if err != nil {
+		return after, err
+	}
+	s.record(ctx, HistoryUpdate, &before, &after)
+	return after, nil
+}
+
+func (s *historyStore) Upsert(ctx context.Context, user User) error {
+	before, getErr := s.UserStore.Get(ctx, user.ID)
+	if getErr != nil && !errors.Is(getErr, ErrNotFound) {
+		return getErr
+	}
+	if err := s.UserStore.Upsert(ctx, user); err != nil {
+		return err
+	}
+	if getErr != nil {
+		s.record(ctx, HistoryCreate, nil, &user)
+	} else {
+		s.record(ctx, HistoryUpdate, &before, &user)
+	}
+	return nil
+}
+
+// Save the result of merging sourceID into the target as one "merge" entry
+func (s *historyStore) UpsertMerged(ctx context.Context, merged User, sourceID primitive.ObjectID) error {
+	before, err := s.UserStore.Get(ctx, merged.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.UserStore.Upsert(ctx, merged); err != nil {
+		return err
+	}
+	s.insert(ctx, HistoryEntry{UserID: merged.ID, Op: HistoryMerge, Before: &before, After: &merged, MergedFrom: &sourceID})
+	return nil
+}
+
+func (s *historyStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	before, err := s.UserStore.DeleteByName(ctx, name)
+	if err != nil {
+		return before, err
+	}
+	s.record(ctx, HistoryDelete, &before, nil)
+	return before, nil
+}
+
+func (s *historyStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	before, err := s.UserStore.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.UserStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.record(ctx, HistoryDelete, &before, nil)
+	return nil
+}
+
+func (s *historyStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	return s.updateTags(ctx, id, func() (User, error) { return s.UserStore.AddTags(ctx, id, tags) })
+}
+
+func (s *historyStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	return s.updateTags(ctx, id, func() (User, error) { return s.UserStore.RemoveTags(ctx, id, tags) })
+}
+
+// Record a tag change as an update
+func (s *historyStore) updateTags(ctx context.Context, id primitive.ObjectID, change func() (User, error)) (User, error) {
+	before, err := s.UserStore.Get(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	after, err := change()
+	if err != nil {
+		return after, err
+	}
+	s.record(ctx, HistoryUpdate, &before, &after)
+	return after, nil
+}
+
+// Rewind current state to asOf by undoing every change recorded after it
+func usersAsOf(ctx context.Context, asOf time.Time, filter bson.M, current map[primitive.ObjectID]User) error {
+	filter["at"] = bson.M{"$gt": asOf}
+	cursor, err := historyCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry HistoryEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		entry.undo(current)
+	}
+	return cursor.Err()
+}
+
+// Parse ?as_of=, RFC 3339 or a plain date (midnight UTC)
+func parseAsOf(c *fiber.Ctx) (time.Time, bool, error) {
+	raw := c.Query("as_of")
+	if raw == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := parseTime(raw)
+	return t, true, err
+}
+
+// RFC 3339 or a plain date (midnight UTC)
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// as_of checked against the start of history. Zero when absent;
+// reject is set when the request has to be refused.
+func asOfParam(c *fiber.Ctx) (asOf time.Time, reject func() error) {
+	asOf, ok, err := parseAsOf(c)
+	if err != nil {
+		return asOf, func() error { return apiError(c, 400, ErrInvalidTimestamp, fiber.Map{"param": "as_of"}) }
+	}
+	if !ok {
+		return time.Time{}, nil
+	}
+	if historyStartedAt.IsZero() {
+		return asOf, func() error { return apiError(c, 501, ErrHistoryUnavailable) }
+	}
+	if asOf.Before(historyStartedAt) {
+		return asOf, func() error {
+			return apiError(c, 422, ErrHistoryTooOld, fiber.Map{"earliest": historyStartedAt.Format(time.RFC3339)})
+		}
+	}
+	return asOf, nil
+}
+
+// Answers GET /users and /users/:id from history when ?as_of= is given
+func usersAsOfHandler(c *fiber.Ctx) error {
+	asOf, reject := asOfParam(c)
+	if reject != nil {
+		return reject()
+	}
+	if asOf.IsZero() {
+		return c.Next()
+	}
+	if c.Params("id") == "" {
+		return listUsersAsOf(c, asOf)
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apiError(c, 400, ErrInvalidID)
+	}
+	return getUserAsOf(c, id, asOf)
+}
+
+// GET /users?as_of=, filters, sort, page and $ options apply to the rewound users
+func listUsersAsOf(c *fiber.Ctx, asOf time.Time) error {
+	// Parsed like userResource, which cannot be named here as it routes to this handler
+	r := newUserResource("/users", "id", usersByIDStore{})
+	q, ferr := r.parseQuery(c)
+	if ferr != nil {
+		return validationError(c, []*FieldError{ferr})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := users.List(ctx, ListQuery{})
+	if err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	state := usersByID(list)
+	if err := usersAsOf(ctx, asOf, bson.M{}, state); err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+
+	lq := userListQuery(q)
+	matched := []User{}
+	for _, u := range state {
+		if lq.matches(u) {
+			matched = append(matched, u)
+		}
+	}
+	total := ResourceCount{N: int64(len(matched))}
+	return r.sendList(c, q, lq.apply(matched), func() (ResourceCount, error) { return total, nil })
+}
+
+// GET /users/:id?as_of=
+func getUserAsOf(c *fiber.Ctx, id primitive.ObjectID, asOf time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state := map[primitive.ObjectID]User{}
+	user, err := users.Get(ctx, id)
+	if err == nil {
+		state[id] = user
+	} else if !errors.Is(err, ErrNotFound) {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+
+	if err := usersAsOf(ctx, asOf, bson.M{"user_id": id}, state); err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	user, ok := state[id]
+	if ok {
+		return c.JSON(user)
+	}
+
+	// Merged away by then: follow to the user it was merged into, which may
+	// itself have been merged later on
+	var merge HistoryEntry
+	err = historyCollection.FindOne(ctx, bson.M{"op": HistoryMerge, "merged_from": id, "at": bson.M{"$lte": asOf}}).Decode(&merge)
+	switch {
+	case err == nil:
+		return c.Redirect("/users/"+merge.UserID.Hex()+"?as_of="+url.QueryEscape(asOf.Format(time.RFC3339Nano)), fiber.StatusMovedPermanently)
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	return apiError(c, 404, ErrUserNotFoundAt, fiber.Map{"as_of": asOf.Format(time.RFC3339)})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scratch database wired into the package globals like setupMongoStore does,
+// with history on. Skipped unless MONGO_URI is set.
+func testMongoDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	scratchClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := scratchClient.Database("history_" + primitive.NewObjectID().Hex())
+
+	prevClient, prevUsers, prevUserColl, prevRedirects, prevOps := client, users, userCollection, redirectCollection, operationCollection
+	prevHistory, prevMeta, prevStarted := historyCollection, historyMetaCollection, historyStartedAt
+	t.Cleanup(func() {
+		client, users, userCollection, redirectCollection, operationCollection = prevClient, prevUsers, prevUserColl, prevRedirects, prevOps
+		historyCollection, historyMetaCollection, historyStartedAt = prevHistory, prevMeta, prevStarted
+		db.Drop(context.Background())
+		scratchClient.Disconnect(context.Background())
+	})
+
+	client = scratchClient
+	userCollection = db.Collection("users")
+	redirectCollection = db.Collection("user_redirects")
+	operationCollection = db.Collection("operations")
+	historyCollection = db.Collection("user_history")
+	historyMetaCollection = db.Collection("user_history_meta")
+	store := newMongoStore(userCollection)
+	if err := store.ensureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if historyStartedAt, err = ensureHistory(ctx); err != nil {
+		t.Fatal(err)
+	}
+	users = newHistoryStore(store)
+	return db
+}
+
+func TestHistoryUndo(t *testing.T) {
+	id := primitive.NewObjectID()
+	v1, v2 := User{ID: id, Name: "Ann", Age: 30}, User{ID: id, Name: "Ann", Age: 31}
+	entries := []HistoryEntry{
+		{UserID: id, Op: HistoryCreate, After: &v1},
+		{UserID: id, Op: HistoryUpdate, Before: &v1, After: &v2},
+		{UserID: id, Op: HistoryDelete, Before: &v2},
+	}
+
+	// Undone newest first, each step gives the state before that entry
+	state := map[primitive.ObjectID]User{}
+	want := []map[primitive.ObjectID]User{{id: v2}, {id: v1}, {}}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i].undo(state)
+		if !reflect.DeepEqual(state, want[len(entries)-1-i]) {
+			t.Errorf("after undoing %s: got %v, want %v", entries[i].Op, state, want[len(entries)-1-i])
+		}
+	}
+}
+
+// A merge keeps both users' histories apart; as_of reads of the source
+// before the merge see it, after it follow to the survivor
+func TestMergeHistory(t *testing.T) {
+	testMongoDB(t)
+	ctx := context.Background()
+
+	source := User{Name: "Jon Smith", Age: 41, Tags: []string{"vip"}}
+	target := User{Name: "John Smith", Age: 40}
+	if err := users.Insert(ctx, &source); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(ctx, &target); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	beforeMerge := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+
+	merged, _, _ := mergeUsers(source, target, nil)
+	redirect := Redirect{ID: source.ID, TargetID: target.ID, MergedAt: time.Now().UTC()}
+	if err := commitMerge(ctx, merged, source.ID, redirect); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	afterMerge := time.Now().UTC()
+
+	ops := func(id primitive.ObjectID) []string {
+		cursor, err := historyCollection.Find(ctx, bson.M{"user_id": id}, options.Find().SetSort(bson.D{{Key: "at", Value: 1}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []HistoryEntry
+		if err := cursor.All(ctx, &entries); err != nil {
+			t.Fatal(err)
+		}
+		var ops []string
+		for _, e := range entries {
+			ops = append(ops, e.Op)
+		}
+		return ops
+	}
+	if got := ops(source.ID); !reflect.DeepEqual(got, []string{HistoryCreate, HistoryDelete}) {
+		t.Errorf("source history %v, want create, delete", got)
+	}
+	if got := ops(target.ID); !reflect.DeepEqual(got, []string{HistoryCreate, HistoryMerge}) {
+		t.Errorf("target history %v, want create, merge", got)
+	}
+
+	app := fiber.New()
+	app.Get("/users/:id", usersAsOfHandler)
+	get := func(id primitive.ObjectID, at time.Time) (int, string, string) {
+		req := httptest.NewRequest("GET", "/users/"+id.Hex()+"?as_of="+at.Format(time.RFC3339Nano), nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var user User
+		json.NewDecoder(resp.Body).Decode(&user)
+		return resp.StatusCode, resp.Header.Get("Location"), user.Name
+	}
+
+	tests := []struct {
+		name     string
+		id       primitive.ObjectID
+		at       time.Time
+		status   int
+		location string
+		user     string
+	}{
+		{"target before", target.ID, beforeMerge, 200, "", "John Smith"},
+		{"target after", target.ID, afterMerge, 200, "", "John Smith"},
+		{"source before", source.ID, beforeMerge, 200, "", "Jon Smith"},
+		{"source after", source.ID, afterMerge, 301, "/users/" + target.ID.Hex() + "?as_of=", ""},
+	}
+	for _, tt := range tests {
+		status, location, name := get(tt.id, tt.at)
+		if status != tt.status || name != tt.user || !strings.HasPrefix(location, tt.location) {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", tt.name, status, location, name, tt.status, tt.location, tt.user)
+		}
+	}
+}
+
+// List parameters are checked before history is read
+func TestListUsersAsOfInvalidQuery(t *testing.T) {
+	prev := historyStartedAt
+	historyStartedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Cleanup(func() { historyStartedAt = prev })
+
+	app := fiber.New()
+	app.Get("/users", usersAsOfHandler)
+	for _, query := range []string{"limit=-1", "sort=height", "$filter=age%20gt", "custom.nope=1"} {
+		req := httptest.NewRequest("GET", "/users?as_of=2024-06-01&"+query, nil)
+		if status, code := testResponse(t, app, req); status != 400 || code != ErrValidationFailed {
+			t.Errorf("%s: got %d %q, want 400 %q", query, status, code, ErrValidationFailed)
+		}
+	}
+}
+
+// Filters, sort, page and counts apply to the users as they were
+func TestListUsersAsOf(t *testing.T) {
+	testMongoDB(t)
+	ctx := context.Background()
+
+	ann, bob, cid := User{Name: "Ann", Age: 30}, User{Name: "Bob", Age: 40}, User{Name: "Cid", Age: 50}
+	for _, u := range []*User{&ann, &bob, &cid} {
+		if err := users.Insert(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	asOf := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(10 * time.Millisecond)
+	if _, err := users.UpdateByName(ctx, "Ann", User{Name: "Ann", Age: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(ctx, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/users", usersAsOfHandler)
+	tests := []struct {
+		query string
+		names []string
+		total string
+	}{
+		{"", []string{"Ann", "Bob", "Cid"}, ""},
+		{"max_age=45&include_count=true", []string{"Ann", "Bob"}, "2"},
+		{"sort=-age&limit=1&include_count=true", []string{"Cid"}, "3"},
+		{"$filter=age%20ge%2040&$orderby=name%20desc", []string{"Cid", "Bob"}, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/users?as_of="+url.QueryEscape(asOf)+"&"+tt.query, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []User
+		json.NewDecoder(resp.Body).Decode(&list)
+		var names []string
+		for _, u := range list {
+			names = append(names, u.Name)
+		}
+		if resp.StatusCode != 200 || !reflect.DeepEqual(names, tt.names) || resp.Header.Get("X-Total-Count") != tt.total {
+			t.Errorf("%s: got %d %v total %q, want %v total %q", tt.query, resp.StatusCode, names, resp.Header.Get("X-Total-Count"), tt.names, tt.total)
+		}
+	}
+}
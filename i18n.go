@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes, stable across languages so clients can branch on them
+const (
+	ErrInvalidJSON           = "invalid_json"
+	ErrInvalidID             = "invalid_id"
+	ErrInvalidQuery          = "invalid_query"
+	ErrValidationFailed      = "validation_failed"
+	ErrUserNotFound          = "user_not_found"
+	ErrSourceNotFound        = "source_user_not_found"
+	ErrTargetNotFound        = "target_user_not_found"
+	ErrMergeIDsRequired      = "merge_ids_required"
+	ErrMergeSelf             = "merge_self"
+	ErrFetchFailed           = "fetch_failed"
+	ErrDecodeFailed          = "decode_failed"
+	ErrCreateFailed          = "create_failed"
+	ErrUpdateFailed          = "update_failed"
+	ErrDeleteFailed          = "delete_failed"
+	ErrMergeFailed           = "merge_failed"
+	ErrOperationNotFound     = "operation_not_found"
+	ErrOperationFinished     = "operation_finished"
+	ErrOperationStartFailed  = "operation_start_failed"
+	ErrResultUnavailable     = "result_unavailable"
+	ErrImportEmpty           = "import_empty"
+	ErrInvalidTimestamp      = "invalid_timestamp"
+	ErrHistoryTooOld         = "history_too_old"
+	ErrHistoryUnavailable    = "history_unavailable"
+	ErrUserNotFoundAt        = "user_not_found_at"
+	ErrFeatureUnavailable    = "feature_unavailable"
+	ErrSchemaViolation       = "schema_violation"
+	ErrFieldRequired         = "field_required"
+	ErrFieldTooLong          = "field_too_long"
+	ErrFieldOutOfRange       = "field_out_of_range"
+	ErrFieldTooSmall         = "field_too_small"
+	ErrFieldInvalidChoice    = "field_invalid_choice"
+	ErrFieldInvalidFormat    = "field_invalid_format"
+	ErrFieldUnknown          = "field_unknown"
+	ErrFieldWrongType        = "field_wrong_type"
+	ErrCustomFieldNotFound   = "custom_field_not_found"
+	ErrCustomFieldTypeChange = "custom_field_type_change"
+	ErrCustomFieldSaveFailed = "custom_field_save_failed"
+	ErrAdminTokenUnset       = "admin_token_unset"
+	ErrInvalidFilter         = "invalid_filter"
+	ErrFieldCoordinates      = "field_coordinates"
+	ErrFieldTooMany          = "field_too_many"
+	ErrReportNotFound        = "report_not_found"
+	ErrReportSaveFailed      = "report_save_failed"
+	ErrReportFailed          = "report_failed"
+	ErrReportTimeout         = "report_timeout"
+	ErrReportNotAllowed      = "report_not_allowed"
+	ErrReportStages          = "report_stages"
+	ErrReportParamUndeclared = "report_param_undeclared"
+	ErrReportParamTaken      = "report_param_taken"
+	ErrSearchNoTerms         = "search_no_terms"
+	ErrSignatureRequired     = "signature_required"
+	ErrSignatureInvalid      = "signature_invalid"
+	ErrSignatureExpired      = "signature_expired"
+	ErrSignatureReplayed     = "signature_replayed"
+	ErrIPForbidden           = "ip_forbidden"
+	ErrAdminUnauthorized     = "admin_unauthorized"
+	ErrBackendUnreachable    = "backend_unreachable"
+	ErrRouteNotFound         = "route_not_found"
+	ErrMethodNotAllowed      = "method_not_allowed"
+	ErrRequestFailed         = "request_failed"
+	ErrInternal              = "internal_error"
+)
+
+const defaultLanguage = "en"
+
+// Message catalogs, {placeholders} are filled from params
+var catalogs = map[string]map[string]string{
+	"en": {
+		ErrInvalidJSON:           "Invalid JSON",
+		ErrInvalidID:             "Invalid user ID",
+		ErrInvalidQuery:          "Invalid query parameter {param}",
+		ErrValidationFailed:      "Validation failed",
+		ErrUserNotFound:          "User not found",
+		ErrSourceNotFound:        "Source user not found",
+		ErrTargetNotFound:        "Target user not found",
+		ErrMergeIDsRequired:      "source_id and target_id are required",
+		ErrMergeSelf:             "Cannot merge a user into itself",
+		ErrFetchFailed:           "Failed to fetch users",
+		ErrDecodeFailed:          "Failed to decode users",
+		ErrCreateFailed:          "Failed to create user",
+		ErrUpdateFailed:          "Failed to update user",
+		ErrDeleteFailed:          "Failed to delete user",
+		ErrMergeFailed:           "Failed to merge users",
+		ErrOperationNotFound:     "Operation not found",
+		ErrOperationFinished:     "Operation is no longer running ({status})",
+		ErrOperationStartFailed:  "Failed to start operation",
+		ErrResultUnavailable:     "Operation result is not available",
+		ErrImportEmpty:           "Import requires a non-empty array of users",
+		ErrInvalidTimestamp:      "{param} must be an RFC 3339 timestamp or a YYYY-MM-DD date",
+		ErrHistoryTooOld:         "History only reaches back to {earliest}",
+		ErrHistoryUnavailable:    "Change history is not available",
+		ErrUserNotFoundAt:        "User did not exist at {as_of}",
+		ErrFeatureUnavailable:    "This feature requires the MongoDB storage backend",
+		ErrSchemaViolation:       "{pointer} does not match the schema: {reason}",
+		ErrFieldRequired:         "{field} is required",
+		ErrFieldTooLong:          "{field} must be at most {max} characters",
+		ErrFieldOutOfRange:       "{field} must be between {min} and {max}",
+		ErrFieldTooSmall:         "{field} must be at least {min}",
+		ErrFieldInvalidChoice:    "{field} must be one of: {choices}",
+		ErrFieldInvalidFormat:    "{field} must match {pattern}",
+		ErrFieldUnknown:          "{field} is not a defined field",
+		ErrFieldWrongType:        "{field} must be of type {type}",
+		ErrCustomFieldNotFound:   "Custom field not found",
+		ErrCustomFieldTypeChange: "{name} is a {type} field, its type cannot change",
+		ErrCustomFieldSaveFailed: "Failed to save custom field",
+		ErrAdminTokenUnset:       "Set ADMIN_TOKEN to manage this resource",
+		ErrInvalidFilter:         "{field} is invalid at position {pos}: {reason}",
+		ErrFieldCoordinates:      "{field} must be [longitude, latitude]",
+		ErrFieldTooMany:          "{field} can have at most {max} items",
+		ErrReportNotFound:        "Report not found",
+		ErrReportSaveFailed:      "Failed to save report",
+		ErrReportFailed:          "Report failed",
+		ErrReportTimeout:         "Report did not finish within {timeout_ms} ms",
+		ErrReportNotAllowed:      "{field}: {name} is not allowed in reports",
+		ErrReportStages:          "{field} must be a list of 1 to {max} single-key stages",
+		ErrReportParamUndeclared: "{field} uses undeclared parameter {name}",
+		ErrReportParamTaken:      "{field}: parameter {name} is declared twice or reserved",
+		ErrSearchNoTerms:         "{field} needs a word or phrase that is not negated",
+		ErrSignatureRequired:     "Request must be signed",
+		ErrSignatureInvalid:      "Request signature is invalid",
+		ErrSignatureExpired:      "Request timestamp is more than {max_skew_s} s away from server time",
+		ErrSignatureReplayed:     "Request nonce was already used",
+		ErrIPForbidden:           "Access from this address is not allowed",
+		ErrAdminUnauthorized:     "Admin authorization required",
+		ErrBackendUnreachable:    "{backend} unreachable",
+		ErrRouteNotFound:         "Route not found",
+		ErrMethodNotAllowed:      "Method not allowed",
+		ErrRequestFailed:         "Request failed: {message}",
+		ErrInternal:              "Internal server error",
+	},
+	"es": {
+		ErrInvalidJSON:           "JSON no válido",
+		ErrInvalidID:             "ID de usuario no válido",
+		ErrInvalidQuery:          "Parámetro de consulta {param} no válido",
+		ErrValidationFailed:      "La validación ha fallado",
+		ErrUserNotFound:          "Usuario no encontrado",
+		ErrSourceNotFound:        "Usuario de origen no encontrado",
+		ErrTargetNotFound:        "Usuario de destino no encontrado",
+		ErrMergeIDsRequired:      "source_id y target_id son obligatorios",
+		ErrMergeSelf:             "No se puede fusionar un usuario consigo mismo",
+		ErrFetchFailed:           "No se pudieron obtener los usuarios",
+		ErrDecodeFailed:          "No se pudieron leer los usuarios",
+		ErrCreateFailed:          "No se pudo crear el usuario",
+		ErrUpdateFailed:          "No se pudo actualizar el usuario",
+		ErrDeleteFailed:          "No se pudo eliminar el usuario",
+		ErrMergeFailed:           "No se pudieron fusionar los usuarios",
+		ErrOperationNotFound:     "Operación no encontrada",
+		ErrOperationFinished:     "La operación ya no está en curso ({status})",
+		ErrOperationStartFailed:  "No se pudo iniciar la operación",
+		ErrResultUnavailable:     "El resultado de la operación no está disponible",
+		ErrImportEmpty:           "La importación requiere una lista de usuarios no vacía",
+		ErrInvalidTimestamp:      "{param} debe ser una marca de tiempo RFC 3339 o una fecha AAAA-MM-DD",
+		ErrHistoryTooOld:         "El historial solo llega hasta {earliest}",
+		ErrHistoryUnavailable:    "El historial de cambios no está disponible",
+		ErrUserNotFoundAt:        "El usuario no existía en {as_of}",
+		ErrFeatureUnavailable:    "Esta función requiere el almacenamiento MongoDB",
+		ErrSchemaViolation:       "{pointer} no cumple el esquema: {reason}",
+		ErrFieldRequired:         "{field} es obligatorio",
+		ErrFieldTooLong:          "{field} debe tener como máximo {max} caracteres",
+		ErrFieldOutOfRange:       "{field} debe estar entre {min} y {max}",
+		ErrFieldTooSmall:         "{field} debe ser como mínimo {min}",
+		ErrFieldInvalidChoice:    "{field} debe ser uno de: {choices}",
+		ErrFieldInvalidFormat:    "{field} debe cumplir {pattern}",
+		ErrFieldUnknown:          "{field} no es un campo definido",
+		ErrFieldWrongType:        "{field} debe ser de tipo {type}",
+		ErrCustomFieldNotFound:   "Campo personalizado no encontrado",
+		ErrCustomFieldTypeChange: "{name} es un campo {type}, su tipo no puede cambiar",
+		ErrCustomFieldSaveFailed: "No se pudo guardar el campo personalizado",
+		ErrAdminTokenUnset:       "Configure ADMIN_TOKEN para gestionar este recurso",
+		ErrInvalidFilter:         "{field} no es válido en la posición {pos}: {reason}",
+		ErrFieldCoordinates:      "{field} debe ser [longitud, latitud]",
+		ErrFieldTooMany:          "{field} puede tener como máximo {max} elementos",
+		ErrReportNotFound:        "Informe no encontrado",
+		ErrReportSaveFailed:      "No se pudo guardar el informe",
+		ErrReportFailed:          "El informe falló",
+		ErrReportTimeout:         "El informe no terminó en {timeout_ms} ms",
+		ErrReportNotAllowed:      "{field}: {name} no está permitido en informes",
+		ErrReportStages:          "{field} debe ser una lista de 1 a {max} etapas de una sola clave",
+		ErrReportParamUndeclared: "{field} usa el parámetro no declarado {name}",
+		ErrReportParamTaken:      "{field}: el parámetro {name} está declarado dos veces o reservado",
+		ErrSearchNoTerms:         "{field} necesita una palabra o frase que no esté negada",
+		ErrSignatureRequired:     "La solicitud debe estar firmada",
+		ErrSignatureInvalid:      "La firma de la solicitud no es válida",
+		ErrSignatureExpired:      "La marca de tiempo de la solicitud difiere más de {max_skew_s} s de la hora del servidor",
+		ErrSignatureReplayed:     "El nonce de la solicitud ya se usó",
+		ErrIPForbidden:           "No se permite el acceso desde esta dirección",
+		ErrAdminUnauthorized:     "Se requiere autorización de administrador",
+		ErrBackendUnreachable:    "{backend} no está accesible",
+		ErrRouteNotFound:         "Ruta no encontrada",
+		ErrMethodNotAllowed:      "Método no permitido",
+		ErrRequestFailed:         "La solicitud falló: {message}",
+		ErrInternal:              "Error interno del servidor",
+	},
+	"fr": {
+		ErrInvalidJSON:           "JSON invalide",
+		ErrInvalidID:             "Identifiant utilisateur invalide",
+		ErrInvalidQuery:          "Paramètre de requête {param} invalide",
+		ErrValidationFailed:      "La validation a échoué",
+		ErrUserNotFound:          "Utilisateur introuvable",
+		ErrSourceNotFound:        "Utilisateur source introuvable",
+		ErrTargetNotFound:        "Utilisateur cible introuvable",
+		ErrMergeIDsRequired:      "source_id et target_id sont obligatoires",
+		ErrMergeSelf:             "Impossible de fusionner un utilisateur avec lui-même",
+		ErrFetchFailed:           "Impossible de récupérer les utilisateurs",
+		ErrDecodeFailed:          "Impossible de lire les utilisateurs",
+		ErrCreateFailed:          "Impossible de créer l'utilisateur",
+		ErrUpdateFailed:          "Impossible de mettre à jour l'utilisateur",
+		ErrDeleteFailed:          "Impossible de supprimer l'utilisateur",
+		ErrMergeFailed:           "Impossible de fusionner les utilisateurs",
+		ErrOperationNotFound:     "Opération introuvable",
+		ErrOperationFinished:     "L'opération n'est plus en cours ({status})",
+		ErrOperationStartFailed:  "Impossible de démarrer l'opération",
+		ErrResultUnavailable:     "Le résultat de l'opération n'est pas disponible",
+		ErrImportEmpty:           "L'import nécessite une liste d'utilisateurs non vide",
+		ErrInvalidTimestamp:      "{param} doit être un horodatage RFC 3339 ou une date AAAA-MM-JJ",
+		ErrHistoryTooOld:         "L'historique ne remonte qu'au {earliest}",
+		ErrHistoryUnavailable:    "L'historique des modifications n'est pas disponible",
+		ErrUserNotFoundAt:        "L'utilisateur n'existait pas au {as_of}",
+		ErrFeatureUnavailable:    "Cette fonctionnalité nécessite le stockage MongoDB",
+		ErrSchemaViolation:       "{pointer} ne respecte pas le schéma : {reason}",
+		ErrFieldRequired:         "{field} est obligatoire",
+		ErrFieldTooLong:          "{field} doit contenir au plus {max} caractères",
+		ErrFieldOutOfRange:       "{field} doit être compris entre {min} et {max}",
+		ErrFieldTooSmall:         "{field} doit être au moins {min}",
+		ErrFieldInvalidChoice:    "{field} doit être l'une des valeurs : {choices}",
+		ErrFieldInvalidFormat:    "{field} doit respecter {pattern}",
+		ErrFieldUnknown:          "{field} n'est pas un champ défini",
+		ErrFieldWrongType:        "{field} doit être de type {type}",
+		ErrCustomFieldNotFound:   "Champ personnalisé introuvable",
+		ErrCustomFieldTypeChange: "{name} est un champ {type}, son type ne peut pas changer",
+		ErrCustomFieldSaveFailed: "Impossible d'enregistrer le champ personnalisé",
+		ErrAdminTokenUnset:       "Définissez ADMIN_TOKEN pour gérer cette ressource",
+		ErrInvalidFilter:         "{field} n'est pas valide à la position {pos} : {reason}",
+		ErrFieldCoordinates:      "{field} doit être [longitude, latitude]",
+		ErrFieldTooMany:          "{field} peut avoir au plus {max} éléments",
+		ErrReportNotFound:        "Rapport introuvable",
+		ErrReportSaveFailed:      "Échec de l'enregistrement du rapport",
+		ErrReportFailed:          "Le rapport a échoué",
+		ErrReportTimeout:         "Le rapport ne s'est pas terminé en {timeout_ms} ms",
+		ErrReportNotAllowed:      "{field} : {name} n'est pas autorisé dans les rapports",
+		ErrReportStages:          "{field} doit être une liste de 1 à {max} étapes à clé unique",
+		ErrReportParamUndeclared: "{field} utilise le paramètre non déclaré {name}",
+		ErrReportParamTaken:      "{field} : le paramètre {name} est déclaré deux fois ou réservé",
+		ErrSearchNoTerms:         "{field} doit contenir un mot ou une phrase non exclu",
+		ErrSignatureRequired:     "La requête doit être signée",
+		ErrSignatureInvalid:      "La signature de la requête est invalide",
+		ErrSignatureExpired:      "L'horodatage de la requête s'écarte de plus de {max_skew_s} s de l'heure du serveur",
+		ErrSignatureReplayed:     "Le nonce de la requête a déjà été utilisé",
+		ErrIPForbidden:           "L'accès depuis cette adresse n'est pas autorisé",
+		ErrAdminUnauthorized:     "Autorisation administrateur requise",
+		ErrBackendUnreachable:    "{backend} est injoignable",
+		ErrRouteNotFound:         "Route introuvable",
+		ErrMethodNotAllowed:      "Méthode non autorisée",
+		ErrRequestFailed:         "La requête a échoué : {message}",
+		ErrInternal:              "Erreur interne du serveur",
+	},
+}
+
+// Validation failure on a single field
+type FieldError struct {
+	Field  string
+	Code   string
+	Params fiber.Map
+}
+
+func (e *FieldError) Error() string {
+	return translate(defaultLanguage, e.Code, e.params())
+}
+
+func (e *FieldError) params() fiber.Map {
+	params := fiber.Map{"field": e.Field}
+	for k, v := range e.Params {
+		params[k] = v
+	}
+	return params
+}
+
+// Pick the best supported language from Accept-Language, falling back to English
+func negotiateLanguage(header string) string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, cand := range candidates {
+		if _, ok := catalogs[cand.tag]; ok {
+			return cand.tag
+		}
+		// "es-MX" falls back to "es"
+		base, _, _ := strings.Cut(cand.tag, "-")
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+	return defaultLanguage
+}
+
+// Message for code in lang, English when the catalog lacks it
+func translate(lang, code string, params fiber.Map) string {
+	msg, ok := catalogs[lang][code]
+	if !ok {
+		if msg, ok = catalogs[defaultLanguage][code]; !ok {
+			msg = code
+		}
+	}
+	for k, v := range params {
+		msg = strings.ReplaceAll(msg, "{"+k+"}", fmt.Sprint(v))
+	}
+	return msg
+}
+
+func requestLanguage(c *fiber.Ctx) string {
+	lang := negotiateLanguage(c.Get(fiber.HeaderAcceptLanguage))
+	c.Set(fiber.HeaderContentLanguage, lang)
+	return lang
+}
+
+// Localized error response: {"error": "...", "code": "..."}
+func apiError(c *fiber.Ctx, status int, code string, params ...fiber.Map) error {
+	var p fiber.Map
+	if len(params) > 0 {
+		p = params[0]
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": translate(requestLanguage(c), code, p),
+		"code":  code,
+	})
+}
+
+// App error handler: Fiber's own errors keep their status, anything else is
+// logged and answered 500 without its details
+func errorHandler(c *fiber.Ctx, err error) error {
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		switch ferr.Code {
+		case fiber.StatusNotFound:
+			return apiError(c, 404, ErrRouteNotFound)
+		case fiber.StatusMethodNotAllowed:
+			return apiError(c, 405, ErrMethodNotAllowed)
+		}
+		return apiError(c, ferr.Code, ErrRequestFailed, fiber.Map{"message": ferr.Message})
+	}
+	fmt.Printf("❌ Request %s %s failed: %v\n", c.Method(), c.Path(), err)
+	return apiError(c, 500, ErrInternal)
+}
+
+// Localized 400 listing every failed field
+func validationError(c *fiber.Ctx, errs []*FieldError) error {
+	lang := requestLanguage(c)
+	details := make([]fiber.Map, 0, len(errs))
+	for _, e := range errs {
+		details = append(details, fiber.Map{
+			"field":   e.Field,
+			"code":    e.Code,
+			"message": translate(lang, e.Code, e.params()),
+		})
+	}
+	return c.Status(400).JSON(fiber.Map{
+		"error":   translate(lang, ErrValidationFailed, nil),
+		"code":    ErrValidationFailed,
+		"details": details,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every language knows every English code
+func TestCatalogsComplete(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for code := range catalogs[defaultLanguage] {
+			if _, ok := catalog[code]; !ok {
+				t.Errorf("%s: missing %s", lang, code)
+			}
+		}
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct{ header, want string }{
+		{"", "en"},
+		{"fr", "fr"},
+		{"es-MX,es;q=0.9", "es"},
+		{"de, fr;q=0.5, es;q=0.8", "es"},
+		{"fr;q=0, es;q=0.1", "es"},
+		{"de", "en"},
+		{"fr;q=abc, es", "es"},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		lang, code string
+		params     fiber.Map
+		want       string
+	}{
+		{"en", ErrFieldTooLong, fiber.Map{"field": "name", "max": 100}, "name must be at most 100 characters"},
+		{"fr", ErrUserNotFound, nil, "Utilisateur introuvable"},
+		{"xx", ErrUserNotFound, nil, "User not found"},
+		{"en", "no_such_code", nil, "no_such_code"},
+	}
+	for _, tt := range tests {
+		if got := translate(tt.lang, tt.code, tt.params); got != tt.want {
+			t.Errorf("translate(%s, %s) = %q, want %q", tt.lang, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Get("/boom", func(c *fiber.Ctx) error { return errors.New("connection string with secrets") })
+	app.Get("/teapot", func(c *fiber.Ctx) error { return fiber.NewError(fiber.StatusTeapot, "short and stout") })
+	app.Post("/parse", func(c *fiber.Ctx) error {
+		var body map[string]any
+		return c.BodyParser(&body)
+	})
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"GET", "/missing", "", 404, ErrRouteNotFound},
+		{"DELETE", "/boom", "", 405, ErrMethodNotAllowed},
+		{"GET", "/boom", "", 500, ErrInternal},
+		{"GET", "/teapot", "", 418, ErrRequestFailed},
+		{"POST", "/parse", "name=Ann", 422, ErrRequestFailed}, // no content type
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		status, code := testResponse(t, app, req)
+		if status != tt.status || code != tt.code {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.path, status, code, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// c.Locals key holding the client IP resolved through trusted proxies
+const clientIPKey = "client_ip"
+
+// IP_RULES_FILE as written, addresses are CIDR ranges or single IPs
+type ipRulesConfig struct {
+	TrustedProxies []string        `json:"trusted_proxies"`
+	Groups         []ipGroupConfig `json:"groups"`
+}
+
+type ipGroupConfig struct {
+	Name   string   `json:"name"`
+	Routes []string `json:"routes"` // "/debug" or "PUT /custom-fields", prefixes on path segments
+	Allow  []string `json:"allow"`  // empty allows everyone not denied
+	Deny   []string `json:"deny"`
+}
+
+// Parsed rules, replaced as a whole on reload
+type ipRules struct {
+	trusted []netip.Prefix
+	groups  []ipGroup
+}
+
+type ipGroup struct {
+	name   string
+	routes []ipRoute
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+}
+
+type ipRoute struct {
+	method string // empty for any
+	prefix string
+}
+
+// CIDR range, a single IP as a /32 or /128
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return p, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseIPPrefixes(field string, list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		p, err := parseIPPrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not an IP or CIDR range", field, s)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+func parseIPRules(data []byte) (*ipRules, error) {
+	var cfg ipRulesConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	rules := &ipRules{}
+	var err error
+	if rules.trusted, err = parseIPPrefixes("trusted_proxies", cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	for i, gc := range cfg.Groups {
+		g := ipGroup{name: gc.Name}
+		if g.name == "" {
+			g.name = fmt.Sprintf("groups.%d", i)
+		}
+		if len(gc.Routes) == 0 {
+			return nil, fmt.Errorf("%s: routes is required", g.name)
+		}
+		for _, r := range gc.Routes {
+			method, prefix, ok := strings.Cut(strings.TrimSpace(r), " ")
+			if !ok {
+				method, prefix = "", method
+			}
+			prefix = strings.TrimSpace(prefix)
+			if !strings.HasPrefix(prefix, "/") {
+				return nil, fmt.Errorf("%s: route %q must be a path, optionally after a method", g.name, r)
+			}
+			if prefix != "/" {
+				prefix = strings.TrimSuffix(prefix, "/")
+			}
+			// Routing ignores case, so must matching
+			g.routes = append(g.routes, ipRoute{method: strings.ToUpper(method), prefix: strings.ToLower(prefix)})
+		}
+		if g.allow, err = parseIPPrefixes(g.name+".allow", gc.Allow); err != nil {
+			return nil, err
+		}
+		if g.deny, err = parseIPPrefixes(g.name+".deny", gc.Deny); err != nil {
+			return nil, err
+		}
+		rules.groups = append(rules.groups, g)
+	}
+	return rules, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g ipGroup) matches(method, path string) bool {
+	path = strings.ToLower(path)
+	for _, r := range g.routes {
+		if r.method != "" && r.method != method {
+			continue
+		}
+		if r.prefix == "/" || path == r.prefix || strings.HasPrefix(path, r.prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Deny wins over allow; an unknown address only passes groups without allow
+func (g ipGroup) permits(addr netip.Addr) bool {
+	if prefixesContain(g.deny, addr) {
+		return false
+	}
+	return len(g.allow) == 0 || prefixesContain(g.allow, addr)
+}
+
+// Client address: the peer, or when the peer is a trusted proxy the nearest
+// untrusted hop of Forwarded, or X-Forwarded-For without it. Hops further
+// away were written by the client and cannot be believed.
+func (r *ipRules) clientIP(c *fiber.Ctx) netip.Addr {
+	peer, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	client := peer.Unmap()
+	if !prefixesContain(r.trusted, client) {
+		return client
+	}
+
+	hops := forwardedHops(c)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !hops[i].IsValid() {
+			// unknown or obfuscated, the last address we know is as far as it goes
+			break
+		}
+		client = hops[i]
+		if !prefixesContain(r.trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+// Every value of a request header, in order
+func headerValues(c *fiber.Ctx, name string) []string {
+	var values []string
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		if strings.EqualFold(string(k), name) {
+			values = append(values, string(v))
+		}
+	})
+	return values
+}
+
+// Hops from the client to the nearest proxy; invalid where not an address
+func forwardedHops(c *fiber.Ctx) []netip.Addr {
+	var hops []netip.Addr
+	if forwarded := headerValues(c, "Forwarded"); len(forwarded) > 0 {
+		// Forwarded: for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			var hop netip.Addr
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hop = parseHop(strings.Trim(value, `"`))
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	for _, value := range headerValues(c, fiber.HeaderXForwardedFor) {
+		for _, item := range strings.Split(value, ",") {
+			hops = append(hops, parseHop(strings.TrimSpace(item)))
+		}
+	}
+	return hops
+}
+
+// Address with an optional port, IPv6 in brackets when it has one
+func parseHop(s string) netip.Addr {
+	if addr, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return addr.Unmap()
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// Allow and deny lists from IP_RULES_FILE, reloaded when the file changes
+type IPFilter struct {
+	mu    sync.RWMutex
+	path  string
+	stamp string
+	rules *ipRules
+}
+
+// Set up from env, nil when IP_RULES_FILE is not set
+var ipFilter *IPFilter
+
+// Size and mod time of the rules file, changes when it does
+func ipRulesStamp(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// Parse the rules file. On error the previously loaded rules stay active.
+func (f *IPFilter) load() error {
+	stamp, err := ipRulesStamp(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseIPRules(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	f.rules, f.stamp = rules, stamp
+	f.mu.Unlock()
+	return nil
+}
+
+// Reload when the file changes; a broken edit is logged and the old rules kept
+func (f *IPFilter) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		f.mu.RLock()
+		loaded := f.stamp
+		f.mu.RUnlock()
+
+		stamp, err := ipRulesStamp(f.path)
+		if err != nil || stamp == loaded {
+			continue
+		}
+		if err := f.load(); err != nil {
+			fmt.Printf("❌ IP rules reload failed, keeping previous rules: %v\n", err)
+			// Remember the broken state so it is not retried until the next edit
+			f.mu.Lock()
+			f.stamp = stamp
+			f.mu.Unlock()
+			continue
+		}
+		fmt.Printf("🔁 Reloaded IP rules from %s\n", f.path)
+	}
+}
+
+func (f *IPFilter) current() *ipRules {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
+
+// Resolve the client IP and refuse it with 403 when any matching group does
+func (f *IPFilter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rules := f.current()
+		addr := rules.clientIP(c)
+		if addr.IsValid() {
+			c.Locals(clientIPKey, addr.String())
+		}
+
+		for _, g := range rules.groups {
+			if g.matches(c.Method(), c.Path()) && !g.permits(addr) {
+				metrics.Inc("ip_denied_total")
+				return apiError(c, 403, ErrIPForbidden)
+			}
+		}
+		return c.Next()
+	}
+}
+
+// Client IP through trusted proxies when IP rules are loaded, the peer otherwise
+func clientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals(clientIPKey).(string); ok {
+		return ip
+	}
+	return c.IP()
+}
+
+// Load IP_RULES_FILE if set and watch it every IP_RULES_RELOAD_INTERVAL (default 2s, 0 disables)
+func setupIPFilter() error {
+	path := os.Getenv("IP_RULES_FILE")
+	if path == "" {
+		return nil
+	}
+	f := &IPFilter{path: path}
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	interval := 2 * time.Second
+	if v := os.Getenv("IP_RULES_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid IP_RULES_RELOAD_INTERVAL %q", v)
+		}
+		interval = d
+	}
+	fmt.Printf("🛡️  IP rules loaded from %s (%d groups, %d trusted proxies)\n", path, len(f.rules.groups), len(f.rules.trusted))
+	if interval > 0 {
+		go f.watch(interval)
+	}
+	ipFilter = f
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Context of a request from peer carrying the given headers
+func testIPCtx(t *testing.T, peer string, headers ...[2]string) *fiber.Ctx {
+	t.Helper()
+	app := fiber.New()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(peer), Port: 50000})
+	for _, h := range headers {
+		fctx.Request.Header.Add(h[0], h[1])
+	}
+	c := app.AcquireCtx(fctx)
+	t.Cleanup(func() { app.ReleaseCtx(c) })
+	return c
+}
+
+func TestParseIPRules(t *testing.T) {
+	rules, err := parseIPRules([]byte(`{
+		"trusted_proxies": ["10.0.0.0/8", "::ffff:192.0.2.1"],
+		"groups": [
+			{"name": "admin", "routes": ["/Debug/", "PUT /custom-fields"], "allow": ["203.0.113.0/24", "2001:db8::/32"], "deny": ["203.0.113.9"]},
+			{"routes": ["/"], "deny": ["::ffff:198.51.100.0/120"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTrusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	if !slices.Equal(rules.trusted, wantTrusted) {
+		t.Errorf("trusted: got %v, want %v", rules.trusted, wantTrusted)
+	}
+	if len(rules.groups) != 2 {
+		t.Fatalf("got %d groups", len(rules.groups))
+	}
+	admin, all := rules.groups[0], rules.groups[1]
+	if admin.name != "admin" || all.name != "groups.1" {
+		t.Errorf("names: got %q and %q", admin.name, all.name)
+	}
+	wantRoutes := []ipRoute{{prefix: "/debug"}, {method: "PUT", prefix: "/custom-fields"}}
+	if len(admin.routes) != 2 || admin.routes[0] != wantRoutes[0] || admin.routes[1] != wantRoutes[1] {
+		t.Errorf("routes: got %v, want %v", admin.routes, wantRoutes)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}; !slices.Equal(all.deny, want) {
+		t.Errorf("mapped deny: got %v, want %v", all.deny, want)
+	}
+}
+
+func TestParseIPRulesErrors(t *testing.T) {
+	tests := []struct {
+		name, json, want string
+	}{
+		{"not JSON", `{`, "unexpected EOF"},
+		{"unknown field", `{"groups": [{"routes": ["/"], "alow": []}]}`, `unknown field "alow"`},
+		{"bad trusted proxy", `{"trusted_proxies": ["10.0.0.0/33"]}`, `trusted_proxies: "10.0.0.0/33"`},
+		{"no routes", `{"groups": [{"name": "admin"}]}`, "admin: routes is required"},
+		{"route without slash", `{"groups": [{"routes": ["debug"]}]}`, `groups.0: route "debug" must be a path`},
+		{"route with a bad method", `{"groups": [{"routes": ["PUT debug"]}]}`, `route "PUT debug" must be a path`},
+		{"bad allow", `{"groups": [{"name": "a", "routes": ["/"], "allow": ["localhost"]}]}`, `a.allow: "localhost"`},
+		{"bad deny", `{"groups": [{"name": "a", "routes": ["/"], "deny": ["1.2.3"]}]}`, `a.deny: "1.2.3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseIPRules([]byte(tt.json))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPGroupMatches(t *testing.T) {
+	g := ipGroup{routes: []ipRoute{{prefix: "/debug"}, {method: "PUT", prefix: "/custom-fields"}}}
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/debug", true},
+		{"GET", "/debug/pprof/heap", true},
+		{"GET", "/DEBUG/vars", true},
+		{"GET", "/debugger", false},
+		{"PUT", "/custom-fields/dept", true},
+		{"DELETE", "/custom-fields/dept", false},
+		{"GET", "/users", false},
+	}
+	for _, tt := range tests {
+		if got := g.matches(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	root := ipGroup{routes: []ipRoute{{prefix: "/"}}}
+	if !root.matches("GET", "/anything") {
+		t.Error(`"/" does not match every path`)
+	}
+}
+
+func TestIPGroupPermits(t *testing.T) {
+	allowList := ipGroup{
+		allow: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+		deny:  []netip.Prefix{netip.MustParsePrefix("203.0.113.9/32")},
+	}
+	denyOnly := ipGroup{deny: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}}
+	tests := []struct {
+		name  string
+		group ipGroup
+		addr  string
+		want  bool
+	}{
+		{"allowed", allowList, "203.0.113.5", true},
+		{"deny wins over allow", allowList, "203.0.113.9", false},
+		{"not allowed", allowList, "192.0.2.1", false},
+		{"unknown address with an allow list", allowList, "", false},
+		{"no allow list", denyOnly, "192.0.2.1", true},
+		{"denied", denyOnly, "198.51.100.7", false},
+		{"unknown address without an allow list", denyOnly, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addr netip.Addr
+			if tt.addr != "" {
+				addr = netip.MustParseAddr(tt.addr)
+			}
+			if got := tt.group.permits(addr); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedHops(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers [][2]string
+		want    []string // "" for an invalid hop
+	}{
+		{"none", nil, nil},
+		{"X-Forwarded-For", [][2]string{{"X-Forwarded-For", "192.0.2.1, 10.0.0.1"}}, []string{"192.0.2.1", "10.0.0.1"}},
+		{"X-Forwarded-For repeated", [][2]string{{"X-Forwarded-For", "192.0.2.1"}, {"X-Forwarded-For", "10.0.0.1"}}, []string{"192.0.2.1", "10.0.0.1"}},
+		{"X-Forwarded-For with ports and garbage", [][2]string{{"X-Forwarded-For", "192.0.2.1:4711, [2001:db8::1]:80, nope"}}, []string{"192.0.2.1", "2001:db8::1", ""}},
+		{"Forwarded", [][2]string{{"Forwarded", `for=192.0.2.60;proto=https, For="[2001:db8:cafe::17]:4711"`}}, []string{"192.0.2.60", "2001:db8:cafe::17"}},
+		{"Forwarded unknown and obfuscated", [][2]string{{"Forwarded", "for=unknown, for=_hidden, by=10.0.0.1"}}, []string{"", "", ""}},
+		{"Forwarded preferred", [][2]string{{"X-Forwarded-For", "198.51.100.1"}, {"Forwarded", "for=192.0.2.60"}}, []string{"192.0.2.60"}},
+		{"mapped IPv4", [][2]string{{"X-Forwarded-For", "::ffff:192.0.2.1"}}, []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hops := forwardedHops(testIPCtx(t, "10.0.0.1", tt.headers...))
+			got := make([]string, len(hops))
+			for i, h := range hops {
+				if h.IsValid() {
+					got[i] = h.String()
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || len(got) != len(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPRulesClientIP(t *testing.T) {
+	rules := &ipRules{trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8:ffff::/48")}}
+	tests := []struct {
+		name    string
+		peer    string
+		headers [][2]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "192.0.2.1", [][2]string{{"X-Forwarded-For", "198.51.100.1"}}, "192.0.2.1"},
+		{"trusted peer without headers", "10.0.0.1", nil, "10.0.0.1"},
+		{"one proxy", "10.0.0.1", [][2]string{{"X-Forwarded-For", "198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed leftmost hop", "10.0.0.1", [][2]string{{"X-Forwarded-For", "203.0.113.66, 198.51.100.1"}}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.1", [][2]string{{"X-Forwarded-For", "203.0.113.66, 198.51.100.1, 10.1.1.1, 10.2.2.2"}}, "198.51.100.1"},
+		{"Forwarded with IPv6 and port", "2001:db8:ffff::1", [][2]string{{"Forwarded", `for="[2001:db8::42]:4711"`}}, "2001:db8::42"},
+		{"unknown hop stops the walk", "10.0.0.1", [][2]string{{"Forwarded", "for=198.51.100.1, for=unknown, for=10.1.1.1"}}, "10.1.1.1"},
+		{"mapped peer", "::ffff:10.0.0.1", [][2]string{{"X-Forwarded-For", "198.51.100.1"}}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules.clientIP(testIPCtx(t, tt.peer, tt.headers...))
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,31 +2,71 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	userCollection *mongo.Collection
-	client         *mongo.Client
+	userCollection      *mongo.Collection
+	redirectCollection  *mongo.Collection
+	operationCollection *mongo.Collection
+	client              *mongo.Client
 )
 
 // User struct
 type User struct {
-	ID   primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	Name string             `json:"name" bson:"name"`
-	Age  int                `json:"age" bson:"age"`
+	ID       primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Name     string             `json:"name" bson:"name"`
+	Age      int                `json:"age" bson:"age"`
+	Custom   map[string]any     `json:"custom,omitempty" bson:"custom,omitempty"` // admin-defined fields, see customfields.go
+	Location *GeoPoint          `json:"location,omitempty" bson:"location,omitempty"`
+	Tags     []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+const (
+	maxNameLength = 100
+	maxAge        = 150
+)
+
+// Validate user fields, converting custom fields and filling their defaults
+func validateUser(u *User) []*FieldError {
+	errs := validateNameAge(u)
+	tags, tagErrs := normalizeTags("tags", u.Tags)
+	u.Tags = tags
+	errs = append(errs, tagErrs...)
+	if u.Location != nil {
+		errs = append(errs, u.Location.check("location")...)
+	}
+	custom, customErrs := customFields.validate(u.Custom)
+	u.Custom = custom
+	return append(errs, customErrs...)
+}
+
+// Name and age checks, all that writes by name change
+func validateNameAge(u *User) []*FieldError {
+	var errs []*FieldError
+	if u.Name == "" {
+		errs = append(errs, &FieldError{Field: "name", Code: ErrFieldRequired})
+	} else if len([]rune(u.Name)) > maxNameLength {
+		errs = append(errs, &FieldError{Field: "name", Code: ErrFieldTooLong, Params: fiber.Map{"max": maxNameLength}})
+	}
+	if u.Age < 0 || u.Age > maxAge {
+		errs = append(errs, &FieldError{Field: "age", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 0, "max": maxAge}})
+	}
+	return errs
 }
 
 // Load .env
@@ -61,123 +101,266 @@ func connectMongoDB() {
 		log.Fatal("❌ MongoDB ping failed:", err)
 	}
 
-	userCollection = client.Database("fiberdb").Collection("users")
+	dbName := os.Getenv("MONGO_DB")
+	if dbName == "" {
+		dbName = "fiberdb"
+	}
+
+	db := client.Database(dbName)
+	userCollection = db.Collection("users")
+	redirectCollection = db.Collection("user_redirects")
+	operationCollection = db.Collection("operations")
+	historyCollection = db.Collection("user_history")
+	historyMetaCollection = db.Collection("user_history_meta")
+	customFieldCollection = db.Collection("custom_fields")
+	reportCollection = db.Collection("reports")
+	auditCollection = db.Collection("audit")
+	store := newMongoStore(userCollection)
+	if err := store.ensureIndexes(ctx); err != nil {
+		log.Fatal("❌ MongoDB index creation failed:", err)
+	}
+	users = store
 	fmt.Println("✅ MongoDB connected successfully!")
 }
 
-func main() {
-	// Load environment
-	loadEnv()
-
-	// Connect MongoDB
+// Mongo backed store with migration and history, returns the migration secondary if any
+func setupMongoStore() *mongo.Database {
 	connectMongoDB()
+	recoverOperations()
 
-	// Fiber app
-	app := fiber.New(fiber.Config{
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		},
-	})
+	// Custom field definitions, refreshed for changes made through other instances
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := customFields.load(ctx); err != nil {
+		log.Fatal("❌ Loading custom fields failed:", err)
+	}
+	go customFields.watch(30 * time.Second)
+	if err := newMongoStore(userCollection).ensureTextIndex(ctx); err != nil {
+		log.Fatal("❌ MongoDB text index creation failed:", err)
+	}
 
-	// ===== ROUTES =====
+	// Optional dual-write migration to a second database
+	secondary := connectSecondary()
+	users = migrationStore(users, secondary)
 
-	// Home
-	app.Get("/", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"message": "🚀 Fiber + MongoDB API running"})
-	})
+	// Change history for point-in-time reads
+	startedAt, err := ensureHistory(ctx)
+	if err != nil {
+		log.Fatal("❌ History setup failed:", err)
+	}
+	historyStartedAt = startedAt
+	users = newHistoryStore(users)
 
-	// GET all users
-	app.Get("/users", func(c *fiber.Ctx) error {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// Hash-chained log of admin requests, denials and writes
+	if audit, err = newAuditLog(ctx, auditCollection); err != nil {
+		log.Fatal("❌ Audit log setup failed:", err)
+	}
+	fmt.Println("🧾 Audit log enabled")
 
-		cursor, err := userCollection.Find(ctx, bson.M{})
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch users"})
-		}
-		defer cursor.Close(ctx)
+	return secondary
+}
 
-		var users []User
-		if err := cursor.All(ctx, &users); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to decode users"})
-		}
+// Features persisted in MongoDB collections are unavailable on other backends
+func requireMongo(c *fiber.Ctx) error {
+	if client == nil {
+		return apiError(c, 501, ErrFeatureUnavailable)
+	}
+	return c.Next()
+}
 
-		if users == nil {
-			users = []User{}
+func main() {
+	// Subcommands, anything starting with - is a server flag
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		var err error
+		switch os.Args[1] {
+		case "replay":
+			err = runReplay(os.Args[2:])
+		case "backfill":
+			err = runBackfill(os.Args[2:])
+		case "backup":
+			err = runBackup(os.Args[2:])
+		case "restore":
+			err = runRestore(os.Args[2:])
+		case "audit":
+			err = runAudit(os.Args[2:])
+		default:
+			log.Fatalf("❌ Unknown command: %s", os.Args[1])
 		}
+		if err != nil {
+			log.Fatal("❌ ", err)
+		}
+		return
+	}
 
-		return c.JSON(users)
-	})
+	dev := flag.Bool("dev", false, "in-memory store with sample users, verbose logging, permissive CORS and docs UI; not for production")
+	flag.Parse()
 
-	// POST create user
-	app.Post("/user", func(c *fiber.Ctx) error {
-		var user User
-		if err := c.BodyParser(&user); err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	// Load environment
+	loadEnv()
+	if err := loadCountConfig(); err != nil {
+		log.Fatal("❌ ", err)
+	}
+
+	// Storage backend: MongoDB (default), an embedded bbolt file or PostgreSQL
+	var secondary *mongo.Database
+	var bolt *boltStore
+	var pg *postgresStore
+	backend := os.Getenv("STORAGE")
+	if *dev {
+		printDevWarning()
+		backend = "memory"
+	}
+	switch backend {
+	case "memory":
+		users = newMemoryStore()
+		if *dev {
+			if err := seedDevStore(users); err != nil {
+				log.Fatal("❌ Seeding sample users failed:", err)
+			}
+			fmt.Printf("🌱 Seeded %d sample users\n", len(devSampleUsers))
 		}
-		if user.Name == "" {
-			return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+		fmt.Println("✅ In-memory storage (lost on exit; merge, operations and history need MongoDB)")
+	case "", "mongo":
+		secondary = setupMongoStore()
+	case "bolt":
+		path := os.Getenv("BOLT_PATH")
+		if path == "" {
+			path = "users.db"
 		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		result, err := userCollection.InsertOne(ctx, user)
+		var err error
+		if bolt, err = openBoltStore(path); err != nil {
+			log.Fatal("❌ Embedded storage failed:", err)
+		}
+		users = bolt
+		fmt.Printf("✅ Embedded storage at %s (merge, operations and history need MongoDB)\n", path)
+	case "postgres":
+		cfg, err := postgresConfig()
+		if err != nil {
+			log.Fatal("❌ PostgreSQL configuration invalid:", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		pg, err = openPostgresStore(ctx, cfg)
+		cancel()
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to create user"})
+			log.Fatal("❌ PostgreSQL connection failed:", err)
 		}
+		users = pg
+		fmt.Println("✅ Connected to PostgreSQL (merge, operations and history need MongoDB)")
+	default:
+		log.Fatalf("❌ Unknown STORAGE %q, expected mongo, bolt, postgres or memory", backend)
+	}
+
+	// Allow and deny lists per route group (opt-in via IP_RULES_FILE)
+	if err := setupIPFilter(); err != nil {
+		log.Fatal("❌ IP rules failed to load:", err)
+	}
 
-		return c.Status(201).JSON(fiber.Map{
-			"message": "User created successfully",
-			"id":      result.InsertedID,
-			"user":    user,
-		})
+	// Fiber app
+	app := fiber.New(fiber.Config{
+		ErrorHandler: errorHandler,
 	})
 
-	// PUT update user by name
-	app.Put("/user/:name", func(c *fiber.Ctx) error {
-		name := c.Params("name")
-		var updateData User
-		if err := c.BodyParser(&updateData); err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
-		}
+	app.Use(metrics.Middleware())
+	if audit != nil {
+		app.Use(audit.Middleware())
+	}
+	if *dev {
+		registerDevMiddleware(app)
+	}
+	if ipFilter != nil {
+		app.Use(ipFilter.Middleware())
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// HMAC signed requests from internal services (opt-in via SIGNING_CLIENTS)
+	verifier, err := newRequestVerifierFromEnv()
+	if err != nil {
+		log.Fatal("❌ Request signing setup failed:", err)
+	}
+	if verifier != nil {
+		app.Use(verifier.Middleware())
+		fmt.Printf("✍️  Verifying signed requests from %d client(s)\n", len(verifier.secrets))
+	}
 
-		filter := bson.M{"name": name}
-		update := bson.M{"$set": bson.M{"name": updateData.Name, "age": updateData.Age}}
+	// Operational endpoints go to a separate listener when ADMIN_ADDR is set,
+	// otherwise debug is served on the public app behind ADMIN_TOKEN
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	var adminApp *fiber.App
+	if adminAddr != "" {
+		adminApp = newAdminApp(os.Getenv("ADMIN_TOKEN"), verifier)
+	} else if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		app.Use("/debug", adminAuth(token))
+		registerDebugRoutes(app)
+		fmt.Println("🩺 Debug endpoints enabled under /debug")
+	}
 
-		result, err := userCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to update user"})
-		}
+	// Traffic recording (opt-in via RECORD_DIR)
+	recorder, err := newRecorderFromEnv()
+	if err != nil {
+		log.Fatal("❌ Traffic recorder setup failed:", err)
+	}
+	if recorder != nil {
+		app.Use(recorder.Middleware())
+		fmt.Printf("📼 Recording traffic to %s\n", os.Getenv("RECORD_DIR"))
+	}
 
-		if result.MatchedCount == 0 {
-			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
-		}
+	// Optional JSON Schema request validation
+	if err := setupSchemas(); err != nil {
+		log.Fatal("❌ Request schemas failed to load:", err)
+	}
 
-		return c.JSON(fiber.Map{"message": "User updated successfully", "user": updateData})
+	// ===== ROUTES =====
+
+	// OpenAPI document, docs UI opt-in
+	docsUI, _ := strconv.ParseBool(os.Getenv("DOCS_UI"))
+	registerDocsRoutes(app, docsUI || *dev)
+
+	// Home
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "🚀 Fiber + MongoDB API running"})
 	})
 
-	// DELETE user by name
-	app.Delete("/user/:name", func(c *fiber.Ctx) error {
-		name := c.Params("name")
+	// Proximity search
+	app.Get("/users/near", usersNear)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// Full-text search
+	app.Get("/users/search", searchUsers)
 
-		result, err := userCollection.DeleteOne(ctx, bson.M{"name": name})
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete user"})
-		}
+	// Tags and tag counts
+	app.Get("/users/facets", userFacets)
+	app.Post("/users/:id/tags", addUserTags)
+	app.Delete("/users/:id/tags/:tag", removeUserTag)
 
-		if result.DeletedCount == 0 {
-			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
-		}
+	// Duplicate candidates and merge
+	app.Get("/users/duplicates", findDuplicates)
+	app.Post("/users/merge", requireMongo, validateBody("merge", false), mergeUsersHandler)
 
-		return c.JSON(fiber.Map{"message": "User deleted successfully", "name": name})
-	})
+	// Long-running operations answer 202 with a Location to poll
+	app.Post("/users/export", requireMongo, exportUsers)
+	app.Post("/users/import", requireMongo, validateBody("user", true), importUsers)
+	app.Get("/operations/:id", requireMongo, getOperation)
+	app.Delete("/operations/:id", requireMongo, cancelOperation)
+	app.Get("/operations/:id/result", requireMongo, getOperationResult)
+
+	// Custom field registry, writes are admin routes
+	app.Get("/custom-fields", listCustomFields)
+
+	// Saved reports, definitions are admin routes
+	app.Get("/reports", requireMongo, listReports)
+	app.Get("/reports/:name", requireMongo, runReport)
+
+	// Admin-only routes go to the private listener when there is one
+	if adminApp != nil {
+		registerAdminRoutes(adminApp)
+	} else {
+		registerPublicAdminRoutes(app)
+	}
+
+	// Users by ID: list, get, create, replace, patch and delete. Registered after the
+	// fixed /users/* routes so those are not taken for IDs.
+	userResource.Register(app)
+
+	// The original name-keyed routes, acting on the oldest user with that name
+	legacyUserResource.Register(app)
 
 	// ===== SERVER START =====
 	port := os.Getenv("PORT")
@@ -192,6 +375,19 @@ func main() {
 		}
 	}()
 	fmt.Printf("🚀 Server running on http://localhost:%s\n", port)
+	if *dev {
+		fmt.Printf("📖 API docs on http://localhost:%s/docs\n", port)
+		fmt.Println("⚠️  Development mode, do not expose this server")
+	}
+
+	if adminApp != nil {
+		go func() {
+			if err := adminApp.Listen(adminAddr); err != nil {
+				log.Fatal("❌ Admin server failed:", err)
+			}
+		}()
+		fmt.Printf("🛠️  Admin listener on %s (/healthz, /readyz, /metrics, /debug and admin routes)\n", adminAddr)
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -204,13 +400,53 @@ func main() {
 		log.Printf("❌ Fiber shutdown error: %v", err)
 	}
 
+	// Admin goes down after the public app so health and metrics stay up while draining
+	if adminApp != nil {
+		if err := adminApp.Shutdown(); err != nil {
+			log.Printf("❌ Admin shutdown error: %v", err)
+		}
+	}
+
+	// Both apps are down, nothing queues entries anymore
+	if audit != nil {
+		audit.Close()
+		fmt.Println("✅ Audit log flushed")
+	}
+
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			log.Printf("❌ Traffic recorder close error: %v", err)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Disconnect(ctx); err != nil {
-		log.Printf("❌ MongoDB disconnect error: %v", err)
-	} else {
-		fmt.Println("✅ MongoDB disconnected")
+	if secondary != nil {
+		if err := secondary.Client().Disconnect(ctx); err != nil {
+			log.Printf("❌ Secondary MongoDB disconnect error: %v", err)
+		}
+	}
+
+	if bolt != nil {
+		if err := bolt.Close(); err != nil {
+			log.Printf("❌ Embedded storage close error: %v", err)
+		} else {
+			fmt.Println("✅ Embedded storage closed")
+		}
+	}
+
+	if pg != nil {
+		pg.Close()
+		fmt.Println("✅ PostgreSQL pool closed")
+	}
+
+	if client != nil {
+		if err := client.Disconnect(ctx); err != nil {
+			log.Printf("❌ MongoDB disconnect error: %v", err)
+		} else {
+			fmt.Println("✅ MongoDB disconnected")
+		}
 	}
 
 	fmt.Println("✅ Server stopped gracefully")
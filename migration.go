@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Writes go to primary then secondary, reads come from primary and are
+// optionally compared against secondary in the background
+type dualStore struct {
+	primary     UserStore
+	secondary   UserStore
+	shadowReads bool
+}
+
+// Secondary failures never fail the request, they are logged and counted
+func (s *dualStore) mirror(op string, err error) {
+	if err != nil {
+		metrics.Inc("migration_secondary_write_errors_total")
+		fmt.Printf("⚠️  Secondary %s failed: %v\n", op, err)
+	}
+}
+
+// Compare a primary read with the secondary without delaying the response
+func (s *dualStore) shadow(what string, want any, read func(ctx context.Context) (any, error)) {
+	if !s.shadowReads {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		metrics.Inc("migration_shadow_reads_total")
+		got, err := read(ctx)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			metrics.Inc("migration_shadow_read_errors_total")
+			fmt.Printf("⚠️  Shadow read %s failed: %v\n", what, err)
+			return
+		}
+		if !reflect.DeepEqual(want, got) {
+			metrics.Inc("migration_shadow_mismatches_total")
+			fmt.Printf("⚠️  Shadow read mismatch on %s: primary=%+v secondary=%+v\n", what, want, got)
+		}
+	}()
+}
+
+func (s *dualStore) List(ctx context.Context, q ListQuery) ([]User, error) {
+	list, err := s.primary.List(ctx, q)
+	if err == nil {
+		s.shadow("list", usersByID(list), func(ctx context.Context) (any, error) {
+			other, err := s.secondary.List(ctx, q)
+			return usersByID(other), err
+		})
+	}
+	return list, err
+}
+
+// Order independent view of a listing
+func usersByID(list []User) map[primitive.ObjectID]User {
+	byID := make(map[primitive.ObjectID]User, len(list))
+	for _, u := range list {
+		byID[u.ID] = u
+	}
+	return byID
+}
+
+func (s *dualStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	return s.primary.CountList(ctx, q)
+}
+
+func (s *dualStore) Count(ctx context.Context) (int64, error) {
+	return s.primary.Count(ctx)
+}
+
+func (s *dualStore) EstimatedCount(ctx context.Context) (int64, error) {
+	return s.primary.EstimatedCount(ctx)
+}
+
+func (s *dualStore) Each(ctx context.Context, fn func(User) error) error {
+	return s.primary.Each(ctx, fn)
+}
+
+func (s *dualStore) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	user, err := s.primary.Get(ctx, id)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		s.shadow("get "+id.Hex(), user, func(ctx context.Context) (any, error) {
+			return s.secondary.Get(ctx, id)
+		})
+	}
+	return user, err
+}
+
+func (s *dualStore) FindByName(ctx context.Context, name string) (User, error) {
+	return s.primary.FindByName(ctx, name)
+}
+
+func (s *dualStore) CountByName(ctx context.Context, name string) (int64, error) {
+	return s.primary.CountByName(ctx, name)
+}
+
+func (s *dualStore) Insert(ctx context.Context, user *User) error {
+	if err := s.primary.Insert(ctx, user); err != nil {
+		return err
+	}
+	s.mirror("insert", s.secondary.Upsert(ctx, *user))
+	return nil
+}
+
+func (s *dualStore) InsertMany(ctx context.Context, list []User) error {
+	if err := s.primary.InsertMany(ctx, list); err != nil {
+		return err
+	}
+	for _, user := range list {
+		s.mirror("insert", s.secondary.Upsert(ctx, user))
+	}
+	return nil
+}
+
+// Updates are mirrored by ID with the primary's result so both sides converge
+func (s *dualStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	user, err := s.primary.UpdateByName(ctx, name, update)
+	if err != nil {
+		return user, err
+	}
+	s.mirror("update", s.secondary.Upsert(ctx, user))
+	return user, nil
+}
+
+func (s *dualStore) Upsert(ctx context.Context, user User) error {
+	if err := s.primary.Upsert(ctx, user); err != nil {
+		return err
+	}
+	s.mirror("upsert", s.secondary.Upsert(ctx, user))
+	return nil
+}
+
+func (s *dualStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	user, err := s.primary.DeleteByName(ctx, name)
+	if err != nil {
+		return user, err
+	}
+	s.mirror("delete", s.ignoreMissing(s.secondary.Delete(ctx, user.ID)))
+	return user, nil
+}
+
+func (s *dualStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := s.primary.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.mirror("delete", s.ignoreMissing(s.secondary.Delete(ctx, id)))
+	return nil
+}
+
+func (s *dualStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	user, err := s.primary.AddTags(ctx, id, tags)
+	if err != nil {
+		return user, err
+	}
+	s.mirror("tags", s.secondary.Upsert(ctx, user))
+	return user, nil
+}
+
+func (s *dualStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	user, err := s.primary.RemoveTags(ctx, id, tags)
+	if err != nil {
+		return user, err
+	}
+	s.mirror("tags", s.secondary.Upsert(ctx, user))
+	return user, nil
+}
+
+func (s *dualStore) Facets(ctx context.Context, q ListQuery) (UserFacets, error) {
+	return s.primary.Facets(ctx, q)
+}
+
+func (s *dualStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	return s.primary.Search(ctx, q)
+}
+
+// Not yet backfilled users are missing on the secondary, that is fine
+func (s *dualStore) ignoreMissing(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Secondary database for the migration, nil when MIGRATION_SECONDARY_DB is unset
+func connectSecondary() *mongo.Database {
+	dbName := os.Getenv("MIGRATION_SECONDARY_DB")
+	if dbName == "" {
+		return nil
+	}
+
+	uri := os.Getenv("MIGRATION_SECONDARY_URI")
+	if uri == "" {
+		uri = os.Getenv("MONGO_URI")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	secondaryClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatal("❌ Secondary MongoDB connection failed:", err)
+	}
+	if err := secondaryClient.Ping(ctx, nil); err != nil {
+		log.Fatal("❌ Secondary MongoDB ping failed:", err)
+	}
+	return secondaryClient.Database(dbName)
+}
+
+// Set while writes are mirrored to a migration secondary
+var dualWriting bool
+
+// Wrap the primary store when a migration is configured
+func migrationStore(primary UserStore, secondary *mongo.Database) UserStore {
+	if secondary == nil {
+		return primary
+	}
+
+	dualWriting = true
+	shadowReads, _ := strconv.ParseBool(os.Getenv("MIGRATION_SHADOW_READS"))
+	fmt.Printf("🔀 Migration mode: dual-writing to %s (shadow reads: %v)\n", secondary.Name(), shadowReads)
+	return &dualStore{
+		primary:     primary,
+		secondary:   newMongoStore(secondary.Collection("users")),
+		shadowReads: shadowReads,
+	}
+}
+
+// Backfill progress, stored in the secondary database
+type Checkpoint struct {
+	ID        string             `bson:"_id"`
+	LastID    primitive.ObjectID `bson:"last_id"`
+	Copied    int64              `bson:"copied"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// backfill command: copy existing users to the secondary, resuming from the last checkpoint
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	batch := fs.Int("batch", 500, "users copied per batch")
+	reset := fs.Bool("reset", false, "ignore the stored checkpoint and start over")
+	fs.Parse(args)
+
+	loadEnv()
+	connectMongoDB()
+	defer client.Disconnect(context.Background())
+
+	secondary := connectSecondary()
+	if secondary == nil {
+		return errors.New("MIGRATION_SECONDARY_DB is not set")
+	}
+	defer secondary.Client().Disconnect(context.Background())
+
+	checkpoints := secondary.Collection("migration_checkpoints")
+	target := secondary.Collection("users")
+	ctx := context.Background()
+
+	var cp Checkpoint
+	err := checkpoints.FindOne(ctx, bson.M{"_id": "users"}).Decode(&cp)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if *reset || errors.Is(err, mongo.ErrNoDocuments) {
+		cp = Checkpoint{ID: "users"}
+	} else {
+		fmt.Printf("↩️  Resuming after %s (%d copied)\n", cp.LastID.Hex(), cp.Copied)
+	}
+
+	for {
+		filter := bson.M{}
+		if !cp.LastID.IsZero() {
+			filter["_id"] = bson.M{"$gt": cp.LastID}
+		}
+		opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(*batch))
+
+		cursor, err := userCollection.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var page []User
+		if err := cursor.All(ctx, &page); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		copied, err := backfillPage(ctx, target, page)
+		if err != nil {
+			return err
+		}
+
+		cp.LastID = page[len(page)-1].ID
+		cp.Copied += copied
+		cp.UpdatedAt = time.Now().UTC()
+		if _, err := checkpoints.ReplaceOne(ctx, bson.M{"_id": cp.ID}, cp, options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+		fmt.Printf("📦 Copied %d users, %d already there (last %s)\n", cp.Copied, int64(len(page))-copied, cp.LastID.Hex())
+	}
+
+	fmt.Printf("✅ Backfill complete: %d users copied\n", cp.Copied)
+	return nil
+}
+
+// Insert the users of page the secondary lacks, returns how many. Users
+// already there were dual-written since and are kept as they are; only a user
+// deleted between reading the page and this write can come back.
+func backfillPage(ctx context.Context, target *mongo.Collection, page []User) (int64, error) {
+	docs := make([]any, len(page))
+	for i, user := range page {
+		docs[i] = user
+	}
+	_, err := target.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return int64(len(page)), err
+	}
+	for _, e := range bulk.WriteErrors {
+		if !mongo.IsDuplicateKeyError(e) {
+			return 0, err
+		}
+	}
+	return int64(len(page) - len(bulk.WriteErrors)), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Memory store whose writes fail, standing in for an unreachable secondary
+type failingStore struct{ *memoryStore }
+
+var errStoreDown = errors.New("store down")
+
+func (failingStore) Upsert(context.Context, User) error               { return errStoreDown }
+func (failingStore) Delete(context.Context, primitive.ObjectID) error { return errStoreDown }
+
+func secondaryWriteErrors() uint64 {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	return metrics.counters["migration_secondary_write_errors_total"]
+}
+
+func TestDualStoreMirrorsWrites(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newMemoryStore(), newMemoryStore()
+	s := &dualStore{primary: primary, secondary: secondary}
+
+	ann, bob := User{Name: "Ann", Age: 30}, User{Name: "Bob", Age: 40}
+	if err := s.Insert(ctx, &ann); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertMany(ctx, []User{bob}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateByName(ctx, "Ann", User{Name: "Anna", Age: 31}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddTags(ctx, ann.ID, []string{"vip"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteByName(ctx, "Bob"); err != nil {
+		t.Fatal(err)
+	}
+	// Only on the primary, as before the backfill: deleting it is no secondary error
+	carl := User{Name: "Carl"}
+	primary.Insert(ctx, &carl)
+	before := secondaryWriteErrors()
+	if err := s.Delete(ctx, carl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if secondaryWriteErrors() != before {
+		t.Error("deleting a user missing on the secondary counted as an error")
+	}
+
+	want, _ := primary.List(ctx, ListQuery{})
+	got, _ := secondary.List(ctx, ListQuery{})
+	if len(want) != 1 || !reflect.DeepEqual(usersByID(got), usersByID(want)) {
+		t.Errorf("secondary %+v, primary %+v", got, want)
+	}
+}
+
+// A failing secondary is counted, the request still succeeds
+func TestDualStoreSecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+	primary := newMemoryStore()
+	s := &dualStore{primary: primary, secondary: failingStore{newMemoryStore()}}
+
+	before := secondaryWriteErrors()
+	ann := User{Name: "Ann"}
+	if err := s.Insert(ctx, &ann); err != nil {
+		t.Fatalf("insert failed with the secondary down: %v", err)
+	}
+	if err := s.Delete(ctx, ann.ID); err != nil {
+		t.Fatalf("delete failed with the secondary down: %v", err)
+	}
+	if got := secondaryWriteErrors() - before; got != 2 {
+		t.Errorf("counted %d secondary errors, want 2", got)
+	}
+
+	// Primary failures are returned and not mirrored
+	if err := s.Delete(ctx, ann.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if got := secondaryWriteErrors() - before; got != 2 {
+		t.Errorf("failed primary write was mirrored")
+	}
+}
+
+// The backfill fills gaps but never overwrites newer dual-written users
+func TestBackfillPage(t *testing.T) {
+	db := testMongoDB(t)
+	ctx := context.Background()
+	target := db.Collection("secondary_users")
+
+	newer := User{ID: primitive.NewObjectID(), Name: "Ann", Age: 31}
+	if _, err := target.InsertOne(ctx, newer); err != nil {
+		t.Fatal(err)
+	}
+	stale := User{ID: newer.ID, Name: "Ann", Age: 30}
+	missing := User{ID: primitive.NewObjectID(), Name: "Bob", Age: 40}
+
+	for run := 1; run <= 2; run++ {
+		copied, err := backfillPage(ctx, target, []User{stale, missing})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(2 - run); copied != want {
+			t.Errorf("run %d: copied %d, want %d", run, copied, want)
+		}
+	}
+
+	var got []User
+	cursor, err := target.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := usersByID([]User{newer, missing}); !reflect.DeepEqual(usersByID(got), want) {
+		t.Errorf("secondary %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Limits keeping $filter cheap to parse and to run
+const (
+	maxFilterLength = 1000
+	maxFilterTerms  = 32
+	maxFilterDepth  = 8
+)
+
+// Parsed $filter. Fields are property paths as stored ("custom.department");
+// the same expression runs in Mongo and, for other backends, in Go.
+type ODataExpr interface {
+	mongo() bson.M
+	// get returns the value of a field, nil when missing
+	eval(get func(field string) any) bool
+}
+
+// and / or
+type odataLogical struct {
+	op   string
+	args []ODataExpr
+}
+
+type odataNot struct {
+	arg ODataExpr
+}
+
+// field eq|ne|gt|ge|lt|le literal
+type odataCompare struct {
+	field string
+	op    string
+	value any // string, int64, float64, bool or nil
+}
+
+// startswith(field, 'x') / contains(field, 'x'), case sensitive
+type odataFunc struct {
+	name  string
+	field string
+	arg   string
+}
+
+func (e odataLogical) mongo() bson.M {
+	args := make(bson.A, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.mongo()
+	}
+	return bson.M{"$" + e.op: args}
+}
+
+func (e odataLogical) eval(get func(string) any) bool {
+	for _, a := range e.args {
+		if a.eval(get) != (e.op == "and") {
+			return e.op == "or"
+		}
+	}
+	return e.op == "and"
+}
+
+// $nor rather than $not, which only applies to a single field
+func (e odataNot) mongo() bson.M {
+	return bson.M{"$nor": bson.A{e.arg.mongo()}}
+}
+
+func (e odataNot) eval(get func(string) any) bool {
+	return !e.arg.eval(get)
+}
+
+// OData comparison operators as Mongo ones
+var odataMongoOps = map[string]string{"ne": "$ne", "gt": "$gt", "ge": "$gte", "lt": "$lt", "le": "$lte"}
+
+func (e odataCompare) mongo() bson.M {
+	if e.op == "eq" {
+		return bson.M{e.field: e.value}
+	}
+	return bson.M{e.field: bson.M{odataMongoOps[e.op]: e.value}}
+}
+
+// Mongo semantics: null matches missing, ordering only within a type
+func (e odataCompare) eval(get func(string) any) bool {
+	v := get(e.field)
+	switch e.op {
+	case "eq":
+		return odataEqual(v, e.value)
+	case "ne":
+		return !odataEqual(v, e.value)
+	}
+	cmp, ok := odataOrder(v, e.value)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	}
+	return cmp <= 0
+}
+
+func (e odataFunc) mongo() bson.M {
+	pattern := regexp.QuoteMeta(e.arg)
+	if e.name == "startswith" {
+		pattern = "^" + pattern
+	}
+	return bson.M{e.field: bson.M{"$regex": pattern}}
+}
+
+func (e odataFunc) eval(get func(string) any) bool {
+	s, ok := get(e.field).(string)
+	if !ok {
+		return false
+	}
+	if e.name == "startswith" {
+		return strings.HasPrefix(s, e.arg)
+	}
+	return strings.Contains(s, e.arg)
+}
+
+func odataEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return customEqual(a, b)
+}
+
+// Order of two values of the same kind, false for mixed or missing values
+func odataOrder(a, b any) (int, bool) {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		return compareCustom(CustomBoolean, x, y), true
+	}
+	if _, ok := customNumber(a); !ok {
+		return 0, false
+	}
+	if _, ok := customNumber(b); !ok {
+		return 0, false
+	}
+	return compareCustom(CustomNumber, a, b), true
+}
+
+// $filter syntax error or limit, reported as a 400 on the parameter
+type odataError struct {
+	pos    int
+	reason string
+}
+
+func (e *odataError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.pos, e.reason)
+}
+
+type odataToken struct {
+	kind string // "ident", "string", "number", "(", ")", ","
+	text string
+	pos  int
+}
+
+func lexOData(src string) ([]odataToken, error) {
+	var tokens []odataToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, odataToken{kind: string(r), text: string(r), pos: i})
+			i++
+		case r == '\'':
+			// '' escapes a quote
+			var sb strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &odataError{start, "unterminated string"}
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, odataToken{kind: "string", text: sb.String(), pos: start})
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || strings.ContainsRune(".eE+-", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, odataToken{kind: "number", text: string(runes[start:i]), pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '/' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, odataToken{kind: "ident", text: string(runes[start:i]), pos: start})
+		default:
+			return nil, &odataError{i, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return tokens, nil
+}
+
+// Recursive descent over the token list, checking fields and literal types
+type odataParser struct {
+	tokens []odataToken
+	pos    int
+	fields map[string]string // property -> type, see CustomString etc.
+	terms  int
+	end    int
+}
+
+// Property path as stored: "custom/department" -> "custom.department"
+func odataField(path string) string {
+	return strings.ReplaceAll(path, "/", ".")
+}
+
+// Parse a $filter over the given properties
+func parseODataFilter(src string, fields map[string]string) (ODataExpr, error) {
+	if len(src) > maxFilterLength {
+		return nil, &odataError{maxFilterLength, fmt.Sprintf("longer than %d characters", maxFilterLength)}
+	}
+	tokens, err := lexOData(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &odataParser{tokens: tokens, fields: fields, end: len([]rune(src))}
+	expr, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, &odataError{tok.pos, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return expr, nil
+}
+
+func (p *odataParser) peek() (odataToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return odataToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *odataParser) next(what string) (odataToken, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return tok, &odataError{p.end, "expected " + what}
+	}
+	p.pos++
+	return tok, nil
+}
+
+func (p *odataParser) expect(kind string) error {
+	tok, err := p.next(kind)
+	if err == nil && tok.kind != kind {
+		err = &odataError{tok.pos, fmt.Sprintf("expected %s, got %q", kind, tok.text)}
+	}
+	return err
+}
+
+func (p *odataParser) keyword(word string) bool {
+	tok, ok := p.peek()
+	if ok && tok.kind == "ident" && tok.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Count a node against the limits
+func (p *odataParser) term(depth int) error {
+	p.terms++
+	if p.terms > maxFilterTerms {
+		return &odataError{p.end, fmt.Sprintf("more than %d terms", maxFilterTerms)}
+	}
+	if depth > maxFilterDepth {
+		return &odataError{p.end, fmt.Sprintf("nested deeper than %d levels", maxFilterDepth)}
+	}
+	return nil
+}
+
+func (p *odataParser) or(depth int) (ODataExpr, error) {
+	return p.logical("or", depth, func() (ODataExpr, error) {
+		return p.logical("and", depth, func() (ODataExpr, error) { return p.unary(depth) })
+	})
+}
+
+func (p *odataParser) logical(op string, depth int, operand func() (ODataExpr, error)) (ODataExpr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	args := []ODataExpr{first}
+	for p.keyword(op) {
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, next)
+	}
+	if len(args) == 1 {
+		return first, nil
+	}
+	if err := p.term(depth); err != nil {
+		return nil, err
+	}
+	return odataLogical{op: op, args: args}, nil
+}
+
+func (p *odataParser) unary(depth int) (ODataExpr, error) {
+	if p.keyword("not") {
+		if err := p.term(depth); err != nil {
+			return nil, err
+		}
+		arg, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return odataNot{arg: arg}, nil
+	}
+
+	tok, err := p.next("an expression")
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == "(" {
+		expr, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	if tok.kind != "ident" {
+		return nil, &odataError{tok.pos, fmt.Sprintf("expected a property or function, got %q", tok.text)}
+	}
+	if err := p.term(depth); err != nil {
+		return nil, err
+	}
+	if next, ok := p.peek(); ok && next.kind == "(" {
+		return p.function(tok)
+	}
+	return p.comparison(tok)
+}
+
+func (p *odataParser) property(tok odataToken) (string, string, error) {
+	field := odataField(tok.text)
+	typ, ok := p.fields[field]
+	if !ok {
+		return "", "", &odataError{tok.pos, fmt.Sprintf("unknown property %q", tok.text)}
+	}
+	return field, typ, nil
+}
+
+func (p *odataParser) comparison(tok odataToken) (ODataExpr, error) {
+	field, typ, err := p.property(tok)
+	if err != nil {
+		return nil, err
+	}
+	opTok, err := p.next("a comparison operator")
+	if err != nil {
+		return nil, err
+	}
+	switch opTok.text {
+	case "eq", "ne", "gt", "ge", "lt", "le":
+	default:
+		return nil, &odataError{opTok.pos, fmt.Sprintf("expected eq, ne, gt, ge, lt or le, got %q", opTok.text)}
+	}
+	litTok, err := p.next("a literal")
+	if err != nil {
+		return nil, err
+	}
+	value, err := odataLiteral(litTok, typ)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil && opTok.text != "eq" && opTok.text != "ne" {
+		return nil, &odataError{litTok.pos, "null only works with eq and ne"}
+	}
+	return odataCompare{field: field, op: opTok.text, value: value}, nil
+}
+
+func (p *odataParser) function(tok odataToken) (ODataExpr, error) {
+	if tok.text != "startswith" && tok.text != "contains" {
+		return nil, &odataError{tok.pos, fmt.Sprintf("unknown function %q, expected startswith or contains", tok.text)}
+	}
+	p.pos++ // (
+	fieldTok, err := p.next("a property")
+	if err != nil {
+		return nil, err
+	}
+	field, typ, err := p.property(fieldTok)
+	if err != nil {
+		return nil, err
+	}
+	if typ != CustomString {
+		return nil, &odataError{fieldTok.pos, fmt.Sprintf("%s needs a string property", tok.text)}
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	argTok, err := p.next("a string")
+	if err != nil {
+		return nil, err
+	}
+	if argTok.kind != "string" {
+		return nil, &odataError{argTok.pos, "expected a quoted string"}
+	}
+	return odataFunc{name: tok.text, field: field, arg: argTok.text}, p.expect(")")
+}
+
+// Literal checked against the property type
+func odataLiteral(tok odataToken, typ string) (any, error) {
+	mismatch := &odataError{tok.pos, fmt.Sprintf("expected a literal of type %s, got %q", typ, tok.text)}
+	if tok.kind == "ident" && tok.text == "null" {
+		return nil, nil
+	}
+	switch typ {
+	case CustomString:
+		if tok.kind != "string" {
+			return nil, mismatch
+		}
+		return tok.text, nil
+	case CustomBoolean:
+		if tok.kind != "ident" || (tok.text != "true" && tok.text != "false") {
+			return nil, mismatch
+		}
+		return tok.text == "true", nil
+	}
+	if tok.kind != "number" {
+		return nil, mismatch
+	}
+	if n, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(tok.text, 64)
+	if err != nil {
+		return nil, mismatch
+	}
+	return f, nil
+}
+
+// $orderby: "name desc, custom/department" over the allowed sort keys
+func parseODataOrderBy(src string, allowed []string) ([]SortKey, *FieldError) {
+	var keys []SortKey
+	for _, part := range strings.Split(src, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, &FieldError{Field: "$orderby", Code: ErrInvalidFilter, Params: fiber.Map{"pos": 0, "reason": fmt.Sprintf("expected \"property [asc|desc]\", got %q", strings.TrimSpace(part))}}
+		}
+		key := SortKey{Field: odataField(words[0])}
+		if !slices.Contains(allowed, key.Field) {
+			return nil, &FieldError{Field: "$orderby", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": strings.Join(allowed, ", ")}}
+		}
+		if len(words) == 2 {
+			switch words[1] {
+			case "asc":
+			case "desc":
+				key.Desc = true
+			default:
+				return nil, &FieldError{Field: "$orderby", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "asc, desc"}}
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testODataFields = map[string]string{
+	"name":              CustomString,
+	"age":               CustomInteger,
+	"custom.score":      CustomNumber,
+	"custom.active":     CustomBoolean,
+	"custom.department": CustomString,
+}
+
+func TestParseODataFilter(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bson.M
+	}{
+		{"name eq 'Ann'", bson.M{"name": "Ann"}},
+		{"name eq 'O''Brien'", bson.M{"name": "O'Brien"}},
+		{"age ge 18", bson.M{"age": bson.M{"$gte": int64(18)}}},
+		{"custom/score lt 1.5", bson.M{"custom.score": bson.M{"$lt": 1.5}}},
+		{"custom/active ne true", bson.M{"custom.active": bson.M{"$ne": true}}},
+		{"custom/department eq null", bson.M{"custom.department": nil}},
+		{"startswith(name, 'A.')", bson.M{"name": bson.M{"$regex": `^A\.`}}},
+		{"contains(name, 'n')", bson.M{"name": bson.M{"$regex": "n"}}},
+		{"not age gt 30", bson.M{"$nor": bson.A{bson.M{"age": bson.M{"$gt": int64(30)}}}}},
+		// and binds tighter than or
+		{"age lt 10 or age gt 20 and name eq 'Bob'", bson.M{"$or": bson.A{
+			bson.M{"age": bson.M{"$lt": int64(10)}},
+			bson.M{"$and": bson.A{bson.M{"age": bson.M{"$gt": int64(20)}}, bson.M{"name": "Bob"}}},
+		}}},
+		{"(age lt 10 or age gt 20) and name eq 'Bob'", bson.M{"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"age": bson.M{"$lt": int64(10)}}, bson.M{"age": bson.M{"$gt": int64(20)}}}},
+			bson.M{"name": "Bob"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := parseODataFilter(tt.src, testODataFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.mongo(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseODataFilterErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+	}{
+		{"", 0},
+		{"name eq", 7},
+		{"name eq 'Ann", 8},
+		{"name eq 'Ann')", 13},
+		{"shoe eq 42", 0},
+		{"name eq 42", 8},
+		{"age eq 'x'", 7},
+		{"age eq 1x", 8},
+		{"custom/active eq yes", 17},
+		{"age gt null", 7},
+		{"name like 'A'", 5},
+		{"endswith(name, 'A')", 0},
+		{"startswith(age, '1')", 11},
+		{"startswith(name, A)", 17},
+		{"(name eq 'Ann'", 14},
+		{"name eq 'Ann' && age eq 1", 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parseODataFilter(tt.src, testODataFields)
+			var oerr *odataError
+			if !errors.As(err, &oerr) {
+				t.Fatalf("got %v, want an odataError", err)
+			}
+			if oerr.pos != tt.pos {
+				t.Errorf("position: got %d (%s), want %d", oerr.pos, oerr.reason, tt.pos)
+			}
+		})
+	}
+}
+
+func TestParseODataFilterLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"too long", "name eq '" + strings.Repeat("x", maxFilterLength) + "'"},
+		{"too many terms", "age eq 1" + strings.Repeat(" or age eq 1", maxFilterTerms)},
+		{"too deep", strings.Repeat("(", maxFilterDepth+1) + "age eq 1" + strings.Repeat(")", maxFilterDepth+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseODataFilter(tt.src, testODataFields); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestODataEval(t *testing.T) {
+	doc := map[string]any{
+		"name":              "Ann",
+		"age":               int64(30),
+		"custom.score":      2.5,
+		"custom.active":     true,
+		"custom.department": nil,
+	}
+	get := func(field string) any { return doc[field] }
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"name eq 'Ann'", true},
+		{"name ne 'Ann'", false},
+		{"name gt 'Al'", true},
+		{"age eq 30", true},
+		{"age eq 30.0", true},
+		{"age lt 30.5", true},
+		{"custom/score ge 2", true},
+		{"custom/score gt 2.5", false},
+		{"custom/active eq true", true},
+		{"custom/active gt false", true},
+		// Missing values equal null and never order
+		{"custom/department eq null", true},
+		{"custom/department ne null", false},
+		{"custom/department lt 'Z'", false},
+		{"custom/department ne 'Sales'", true},
+		{"startswith(name, 'An')", true},
+		{"startswith(name, 'an')", false},
+		{"contains(name, 'nn')", true},
+		{"contains(custom/department, 'x')", false},
+		{"not name eq 'Ann'", false},
+		{"age lt 10 or name eq 'Ann'", true},
+		{"age lt 10 or name eq 'Bob'", false},
+		{"age gt 10 and name eq 'Ann'", true},
+		{"age gt 10 and not (name eq 'Ann' or name eq 'Bob')", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := parseODataFilter(tt.src, testODataFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.eval(get); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseODataOrderBy(t *testing.T) {
+	allowed := []string{"name", "age", "custom.department"}
+	keys, ferr := parseODataOrderBy("name desc, custom/department", allowed)
+	want := []SortKey{{Field: "name", Desc: true}, {Field: "custom.department"}}
+	if ferr != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, %v; want %v", keys, ferr, want)
+	}
+
+	for _, src := range []string{"shoe", "name down", "name desc extra", "name,"} {
+		if _, ferr := parseODataOrderBy(src, allowed); ferr == nil {
+			t.Errorf("%q: got no error", src)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Reference to a schema under components
+func schemaRef(name string) fiber.Map {
+	return fiber.Map{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema fiber.Map) fiber.Map {
+	return fiber.Map{"application/json": fiber.Map{"schema": schema}}
+}
+
+func jsonResponse(description string, schema fiber.Map) fiber.Map {
+	return fiber.Map{"description": description, "content": jsonContent(schema)}
+}
+
+func errorResponse(description string) fiber.Map {
+	return jsonResponse(description, schemaRef("Error"))
+}
+
+func pathParam(name, description string) fiber.Map {
+	return fiber.Map{"name": name, "in": "path", "required": true, "description": description, "schema": fiber.Map{"type": "string"}}
+}
+
+func queryParam(name, description string, schema fiber.Map) fiber.Map {
+	return fiber.Map{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+var dryRunParam = queryParam("dry_run", "Validate and report without writing (or send Prefer: dry-run)", fiber.Map{"type": "boolean"})
+
+var asOfParamSpec = queryParam("as_of", "Answer from history as of this RFC 3339 time or date", fiber.Map{"type": "string"})
+
+// Request body schema for user writes
+func userInputSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"name"},
+		"properties": fiber.Map{
+			"name":     fiber.Map{"type": "string", "minLength": 1, "maxLength": maxNameLength},
+			"age":      fiber.Map{"type": "integer", "minimum": 0, "maximum": maxAge},
+			"custom":   customFields.schema(true),
+			"location": schemaRef("GeoPoint"),
+			"tags":     tagsSchema,
+		},
+	}
+}
+
+var tagsSchema = fiber.Map{
+	"type":        "array",
+	"maxItems":    maxTags,
+	"uniqueItems": true,
+	"items":       fiber.Map{"type": "string", "pattern": tagPattern.String()},
+}
+
+// OpenAPI 3.1 description of the public API, served at /openapi.json
+func openAPISpec() fiber.Map {
+	operation := jsonResponse("Operation accepted, poll the Location header", schemaRef("Operation"))
+
+	spec := fiber.Map{
+		"openapi": "3.1.0",
+		"info": fiber.Map{
+			"title":   "Fiber + MongoDB users API",
+			"version": "1.0.0",
+		},
+		"paths": fiber.Map{
+			"/users/near": fiber.Map{
+				"get": fiber.Map{
+					"summary": "Users within radius meters of a point, nearest first",
+					"parameters": []fiber.Map{
+						queryParam("lat", "Latitude", fiber.Map{"type": "number", "minimum": -90, "maximum": 90}),
+						queryParam("lng", "Longitude", fiber.Map{"type": "number", "minimum": -180, "maximum": 180}),
+						queryParam("radius", "Meters", fiber.Map{"type": "number", "minimum": 0, "maximum": maxNearRadius}),
+						queryParam("limit", "Page size", fiber.Map{"type": "integer", "minimum": 1, "maximum": maxListLimit, "default": 100}),
+						queryParam("offset", "Users to skip", fiber.Map{"type": "integer", "minimum": 0}),
+					},
+					"responses": fiber.Map{
+						"200": jsonResponse("Users with their distance in meters", fiber.Map{"type": "array", "items": fiber.Map{
+							"allOf": []fiber.Map{schemaRef("User"), {"type": "object", "properties": fiber.Map{"distance": fiber.Map{"type": "number"}}}},
+						}}),
+						"400": jsonResponse("Invalid query", schemaRef("ValidationError")),
+					},
+				},
+			},
+			"/users/search": fiber.Map{
+				"get": fiber.Map{
+					"summary": "Full-text search over name, tags and string custom fields, most relevant first",
+					"parameters": []fiber.Map{
+						queryParam("q", `Words (any matches), "quoted phrases" (all must match), -word or -"phrase" to exclude; case and diacritic insensitive`, fiber.Map{"type": "string", "maxLength": maxSearchLength}),
+						queryParam("limit", "Page size", fiber.Map{"type": "integer", "minimum": 1, "maximum": maxListLimit, "default": 20}),
+						queryParam("offset", "Results to skip", fiber.Map{"type": "integer", "minimum": 0}),
+					},
+					"responses": fiber.Map{
+						"200": jsonResponse("Total matches and a page of results", fiber.Map{
+							"type": "object",
+							"properties": fiber.Map{
+								"total": fiber.Map{"type": "integer"},
+								"results": fiber.Map{"type": "array", "items": fiber.Map{
+									"type": "object",
+									"properties": fiber.Map{
+										"user":  schemaRef("User"),
+										"score": fiber.Map{"type": "number"},
+										"highlights": fiber.Map{"type": "array", "items": fiber.Map{
+											"type": "object",
+											"properties": fiber.Map{
+												"field": fiber.Map{"type": "string"},
+												"text":  fiber.Map{"type": "string", "description": "HTML escaped, matched words in <em>"},
+											},
+										}},
+									},
+								}},
+							},
+						}),
+						"400": jsonResponse("Missing or invalid query", schemaRef("ValidationError")),
+					},
+				},
+			},
+			"/users/facets": fiber.Map{
+				"get": fiber.Map{
+					"summary":    "Tag counts over the users matching the GET /users filters, most used first",
+					"parameters": userResource.filterParams(),
+					"responses": fiber.Map{
+						"200": jsonResponse("Total matches and tag counts", schemaRef("UserFacets")),
+						"400": jsonResponse("Invalid query", schemaRef("ValidationError")),
+					},
+				},
+			},
+			"/users/{id}/tags": fiber.Map{
+				"post": fiber.Map{
+					"summary":    "Add tags, keeping those already set",
+					"parameters": []fiber.Map{pathParam("id", "User ID"), dryRunParam},
+					"requestBody": fiber.Map{"required": true, "content": jsonContent(fiber.Map{
+						"type":       "object",
+						"required":   []string{"tags"},
+						"properties": fiber.Map{"tags": tagsSchema},
+					})},
+					"responses": fiber.Map{
+						"200": jsonResponse("Tagged user", fiber.Map{"type": "object"}),
+						"400": jsonResponse("Invalid tags or more than the maximum", schemaRef("ValidationError")),
+						"404": errorResponse("User not found"),
+					},
+				},
+			},
+			"/users/{id}/tags/{tag}": fiber.Map{
+				"delete": fiber.Map{
+					"summary":    "Remove a tag",
+					"parameters": []fiber.Map{pathParam("id", "User ID"), pathParam("tag", "Tag"), dryRunParam},
+					"responses": fiber.Map{
+						"200": jsonResponse("Untagged user", fiber.Map{"type": "object"}),
+						"400": jsonResponse("Invalid ID or tag", schemaRef("ValidationError")),
+						"404": errorResponse("User not found"),
+					},
+				},
+			},
+			"/users/duplicates": fiber.Map{
+				"get": fiber.Map{
+					"summary": "Likely duplicate users",
+					"parameters": []fiber.Map{
+						queryParam("threshold", "Minimum similarity", fiber.Map{"type": "number", "minimum": 0, "maximum": 1}),
+						queryParam("limit", "Maximum pairs", fiber.Map{"type": "integer", "minimum": 1}),
+					},
+					"responses": fiber.Map{
+						"200": jsonResponse("Candidate pairs, most similar first", fiber.Map{"type": "array", "items": schemaRef("DuplicatePair")}),
+						"400": errorResponse("Invalid threshold or limit"),
+					},
+				},
+			},
+			"/users/merge": fiber.Map{
+				"post": fiber.Map{
+					"summary":     "Merge a source user into a target (MongoDB only)",
+					"parameters":  []fiber.Map{dryRunParam},
+					"requestBody": fiber.Map{"required": true, "content": jsonContent(schemaRef("MergeRequest"))},
+					"responses": fiber.Map{
+						"200": jsonResponse("Merged user and resolved conflicts", fiber.Map{"type": "object"}),
+						"400": errorResponse("Missing, identical or invalid IDs"),
+						"404": errorResponse("Source or target not found"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+			},
+			"/users/export": fiber.Map{
+				"post": fiber.Map{
+					"summary":   "Start an export (MongoDB only)",
+					"responses": fiber.Map{"202": operation, "501": errorResponse("Needs MongoDB")},
+				},
+			},
+			"/users/import": fiber.Map{
+				"post": fiber.Map{
+					"summary":     "Start an import (MongoDB only)",
+					"parameters":  []fiber.Map{dryRunParam},
+					"requestBody": fiber.Map{"required": true, "content": jsonContent(fiber.Map{"type": "array", "items": schemaRef("UserInput")})},
+					"responses": fiber.Map{
+						"202": operation,
+						"400": jsonResponse("Invalid users", schemaRef("ValidationError")),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+			},
+			"/operations/{id}": fiber.Map{
+				"get": fiber.Map{
+					"summary":    "Operation status",
+					"parameters": []fiber.Map{pathParam("id", "Operation ID")},
+					"responses":  fiber.Map{"200": jsonResponse("Operation", schemaRef("Operation")), "404": errorResponse("Operation not found")},
+				},
+				"delete": fiber.Map{
+					"summary":    "Cancel a running operation",
+					"parameters": []fiber.Map{pathParam("id", "Operation ID")},
+					"responses": fiber.Map{
+						"200": jsonResponse("Operation", schemaRef("Operation")),
+						"404": errorResponse("Operation not found"),
+						"409": errorResponse("Operation already finished"),
+					},
+				},
+			},
+			"/operations/{id}/result": fiber.Map{
+				"get": fiber.Map{
+					"summary":    "Download an operation result",
+					"parameters": []fiber.Map{pathParam("id", "Operation ID")},
+					"responses":  fiber.Map{"200": fiber.Map{"description": "Result file"}, "404": errorResponse("No result")},
+				},
+			},
+			"/custom-fields": fiber.Map{
+				"get": fiber.Map{
+					"summary":   "Custom field definitions",
+					"responses": fiber.Map{"200": jsonResponse("Definitions by name", fiber.Map{"type": "array", "items": schemaRef("CustomField")})},
+				},
+			},
+			"/custom-fields/{name}": fiber.Map{
+				"put": fiber.Map{
+					"summary":     "Define or replace a custom field (ADMIN_TOKEN, MongoDB only)",
+					"parameters":  []fiber.Map{pathParam("name", "Field name")},
+					"requestBody": fiber.Map{"required": true, "content": jsonContent(schemaRef("CustomField"))},
+					"responses": fiber.Map{
+						"200": jsonResponse("Replaced", schemaRef("CustomField")),
+						"201": jsonResponse("Created", schemaRef("CustomField")),
+						"400": jsonResponse("Invalid definition", schemaRef("ValidationError")),
+						"401": errorResponse("Admin authorization required"),
+						"409": errorResponse("Type change"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+				"delete": fiber.Map{
+					"summary":    "Delete a custom field, stored values are kept (ADMIN_TOKEN, MongoDB only)",
+					"parameters": []fiber.Map{pathParam("name", "Field name")},
+					"responses": fiber.Map{
+						"200": jsonResponse("Deleted", fiber.Map{"type": "object"}),
+						"401": errorResponse("Admin authorization required"),
+						"404": errorResponse("Custom field not found"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+			},
+			"/reports": fiber.Map{
+				"get": fiber.Map{
+					"summary":   "Saved report definitions (MongoDB only)",
+					"responses": fiber.Map{"200": jsonResponse("Definitions by name", fiber.Map{"type": "array", "items": schemaRef("Report")})},
+				},
+			},
+			"/reports/{name}": fiber.Map{
+				"get": fiber.Map{
+					"summary": "Run a report; its parameters are passed as query parameters",
+					"parameters": []fiber.Map{
+						pathParam("name", "Report name"),
+						queryParam("format", "Output format", fiber.Map{"type": "string", "enum": []string{"json", "csv"}, "default": "json"}),
+					},
+					"responses": fiber.Map{
+						"200": fiber.Map{
+							"description": "Rows, at most max_rows; X-Report-Truncated is set when there were more",
+							"content": fiber.Map{
+								"application/json": fiber.Map{"schema": fiber.Map{"type": "object", "properties": fiber.Map{
+									"report":    fiber.Map{"type": "string"},
+									"rows":      fiber.Map{"type": "array", "items": fiber.Map{"type": "object"}},
+									"count":     fiber.Map{"type": "integer"},
+									"truncated": fiber.Map{"type": "boolean"},
+								}}},
+								"text/csv": fiber.Map{"schema": fiber.Map{"type": "string"}},
+							},
+						},
+						"400": jsonResponse("Invalid parameters", schemaRef("ValidationError")),
+						"404": errorResponse("Report not found"),
+						"501": errorResponse("Needs MongoDB"),
+						"504": errorResponse("Report timed out"),
+					},
+				},
+				"put": fiber.Map{
+					"summary":     "Define or replace a report (ADMIN_TOKEN, MongoDB only)",
+					"parameters":  []fiber.Map{pathParam("name", "Report name")},
+					"requestBody": fiber.Map{"required": true, "content": jsonContent(schemaRef("Report"))},
+					"responses": fiber.Map{
+						"200": jsonResponse("Replaced", schemaRef("Report")),
+						"201": jsonResponse("Created", schemaRef("Report")),
+						"400": jsonResponse("Invalid definition", schemaRef("ValidationError")),
+						"401": errorResponse("Admin authorization required"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+				"delete": fiber.Map{
+					"summary":    "Delete a report (ADMIN_TOKEN, MongoDB only)",
+					"parameters": []fiber.Map{pathParam("name", "Report name")},
+					"responses": fiber.Map{
+						"200": jsonResponse("Deleted", fiber.Map{"type": "object"}),
+						"401": errorResponse("Admin authorization required"),
+						"404": errorResponse("Report not found"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+			},
+			"/audit": fiber.Map{
+				"get": fiber.Map{
+					"summary": "Export audit entries in sequence order (ADMIN_TOKEN, MongoDB only)",
+					"parameters": []fiber.Map{
+						queryParam("from", "First sequence number", fiber.Map{"type": "integer", "minimum": 1}),
+						queryParam("to", "Last sequence number", fiber.Map{"type": "integer", "minimum": 1}),
+						queryParam("since", "First time, RFC 3339 or YYYY-MM-DD", fiber.Map{"type": "string"}),
+						queryParam("until", "Last time, RFC 3339 or YYYY-MM-DD", fiber.Map{"type": "string"}),
+					},
+					"responses": fiber.Map{
+						"200": fiber.Map{
+							"description": "One entry per line, checked by ./api audit verify --file",
+							"content":     fiber.Map{"application/x-ndjson": fiber.Map{"schema": schemaRef("AuditEntry")}},
+						},
+						"400": jsonResponse("Invalid range", schemaRef("ValidationError")),
+						"401": errorResponse("Admin authorization required"),
+						"501": errorResponse("Needs MongoDB"),
+					},
+				},
+			},
+		},
+		"components": fiber.Map{
+			"schemas": fiber.Map{
+				"User": fiber.Map{
+					"type": "object",
+					"properties": fiber.Map{
+						"_id":      fiber.Map{"type": "string", "pattern": "^[0-9a-f]{24}$"},
+						"name":     fiber.Map{"type": "string"},
+						"age":      fiber.Map{"type": "integer"},
+						"custom":   customFields.schema(true),
+						"location": schemaRef("GeoPoint"),
+						"tags":     tagsSchema,
+					},
+				},
+				"AuditEntry": fiber.Map{
+					"type": "object",
+					"properties": fiber.Map{
+						"seq":       fiber.Map{"type": "integer"},
+						"at":        fiber.Map{"type": "string", "format": "date-time"},
+						"kind":      fiber.Map{"type": "string", "enum": []string{AuditDenied, AuditAdmin, AuditMutation}},
+						"action":    fiber.Map{"type": "string"},
+						"actor":     fiber.Map{"type": "string"},
+						"ip":        fiber.Map{"type": "string"},
+						"status":    fiber.Map{"type": "integer"},
+						"details":   fiber.Map{"type": "object", "additionalProperties": fiber.Map{"type": "string"}},
+						"prev_hash": fiber.Map{"type": "string"},
+						"hash":      fiber.Map{"type": "string"},
+					},
+				},
+				"UserFacets": fiber.Map{
+					"type": "object",
+					"properties": fiber.Map{
+						"total": fiber.Map{"type": "integer"},
+						"tags": fiber.Map{"type": "array", "items": fiber.Map{
+							"type":       "object",
+							"properties": fiber.Map{"tag": fiber.Map{"type": "string"}, "count": fiber.Map{"type": "integer"}},
+						}},
+					},
+				},
+				"GeoPoint": fiber.Map{
+					"type":     "object",
+					"required": []string{"type", "coordinates"},
+					"properties": fiber.Map{
+						"type": fiber.Map{"const": "Point"},
+						"coordinates": fiber.Map{
+							"type":        "array",
+							"description": "[longitude, latitude]",
+							"prefixItems": []fiber.Map{
+								{"type": "number", "minimum": -180, "maximum": 180},
+								{"type": "number", "minimum": -90, "maximum": 90},
+							},
+							"minItems": 2,
+							"maxItems": 2,
+						},
+					},
+				},
+				"CustomField": fiber.Map{
+					"type":     "object",
+					"required": []string{"type"},
+					"properties": fiber.Map{
+						"name":        fiber.Map{"type": "string", "pattern": customFieldName.String(), "readOnly": true},
+						"type":        fiber.Map{"type": "string", "enum": []string{CustomString, CustomInteger, CustomNumber, CustomBoolean}},
+						"required":    fiber.Map{"type": "boolean"},
+						"enum":        fiber.Map{"type": "array"},
+						"default":     fiber.Map{},
+						"description": fiber.Map{"type": "string"},
+					},
+				},
+				"Report": fiber.Map{
+					"type":     "object",
+					"required": []string{"pipeline"},
+					"properties": fiber.Map{
+						"name":        fiber.Map{"type": "string", "pattern": customFieldName.String(), "readOnly": true},
+						"description": fiber.Map{"type": "string"},
+						"params":      fiber.Map{"type": "array", "items": schemaRef("CustomField"), "description": "Query parameters, each with a name; referenced in the pipeline as \"{{name}}\""},
+						"pipeline":    fiber.Map{"type": "array", "items": fiber.Map{"type": "object"}, "maxItems": maxReportStages, "description": "Aggregation stages over users, safelisted"},
+						"max_rows":    fiber.Map{"type": "integer", "minimum": 1, "maximum": maxReportRows, "default": defaultReportRows},
+						"timeout_ms":  fiber.Map{"type": "integer", "minimum": minReportTimeoutMS, "maximum": maxReportTimeoutMS, "default": defaultReportTimeoutMS},
+						"updated_at":  fiber.Map{"type": "string", "format": "date-time", "readOnly": true},
+					},
+				},
+				"UserInput": userInputSchema(),
+				"MergeRequest": fiber.Map{
+					"type":     "object",
+					"required": []string{"source_id", "target_id"},
+					"properties": fiber.Map{
+						"source_id": fiber.Map{"type": "string"},
+						"target_id": fiber.Map{"type": "string"},
+						"resolve":   fiber.Map{"type": "object", "additionalProperties": fiber.Map{"type": "string", "enum": []string{"source", "target", "min", "max"}}},
+					},
+				},
+				"DuplicatePair": fiber.Map{
+					"type": "object",
+					"properties": fiber.Map{
+						"a":          schemaRef("User"),
+						"b":          schemaRef("User"),
+						"score":      fiber.Map{"type": "number"},
+						"name_score": fiber.Map{"type": "number"},
+						"age_score":  fiber.Map{"type": "number"},
+					},
+				},
+				"Operation": fiber.Map{"type": "object"},
+				"Error": fiber.Map{
+					"type": "object",
+					"properties": fiber.Map{
+						"error": fiber.Map{"type": "string", "description": "Localized message"},
+						"code":  fiber.Map{"type": "string", "description": "Stable error code"},
+					},
+				},
+				"ValidationError": fiber.Map{
+					"allOf": []fiber.Map{
+						schemaRef("Error"),
+						{"type": "object", "properties": fiber.Map{"details": fiber.Map{"type": "array", "items": fiber.Map{"type": "object"}}}},
+					},
+				},
+			},
+		},
+	}
+
+	// CRUD paths generated by the user resources
+	paths := spec["paths"].(fiber.Map)
+	for _, r := range []*Resource[User]{userResource, legacyUserResource} {
+		for path, ops := range r.OpenAPIPaths() {
+			paths[path] = ops
+		}
+	}
+
+	// Loaded request schemas are what the server enforces, publish those
+	components := spec["components"].(fiber.Map)["schemas"].(fiber.Map)
+	for name, component := range schemaComponents {
+		if doc := schemas.doc(name); doc != nil {
+			components[component] = doc
+		}
+	}
+	return spec
+}
+
+// Swagger UI page for /openapi.json, loaded from a CDN
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Users API docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="docs"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#docs"});</script>
+</body>
+</html>`
+
+// Spec is always public, the UI only when enabled (DOCS_UI=true or --dev)
+func registerDocsRoutes(app *fiber.App, ui bool) {
+	app.Get("/openapi.json", func(c *fiber.Ctx) error {
+		return c.JSON(openAPISpec())
+	})
+	if ui {
+		app.Get("/docs", func(c *fiber.Ctx) error {
+			c.Type("html")
+			return c.SendString(docsPage)
+		})
+	}
+}
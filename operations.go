@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operation states
+const (
+	OpPending   = "pending"
+	OpRunning   = "running"
+	OpSucceeded = "succeeded"
+	OpFailed    = "failed"
+	OpCanceled  = "canceled"
+)
+
+const (
+	importBatchSize    = 500
+	operationTimeout   = 30 * time.Minute
+	progressFlushEvery = time.Second
+	cancelPollEvery    = time.Second
+)
+
+// Long-running operation, persisted in the operations collection
+type Operation struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	Type            string             `json:"type" bson:"type"`
+	Status          string             `json:"status" bson:"status"`
+	Done            int64              `json:"done" bson:"done"`
+	Total           int64              `json:"total" bson:"total"`
+	Result          bson.M             `json:"result,omitempty" bson:"result,omitempty"`
+	ResultURL       string             `json:"result_url,omitempty" bson:"result_url,omitempty"`
+	Error           *OperationError    `json:"error,omitempty" bson:"error,omitempty"`
+	CancelRequested bool               `json:"cancel_requested,omitempty" bson:"cancel_requested,omitempty"` // set by DELETE on any instance, polled by the runner
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+	FinishedAt      *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+type OperationError struct {
+	Code    string `json:"code" bson:"code"`
+	Message string `json:"message" bson:"message"`
+}
+
+// Handed to operation bodies to report progress
+type OperationHandle struct {
+	op        *Operation
+	lastFlush time.Time
+}
+
+// Record progress, persisted at most once per second
+func (h *OperationHandle) Progress(done, total int64) {
+	h.op.Done, h.op.Total = done, total
+	if time.Since(h.lastFlush) < progressFlushEvery {
+		return
+	}
+	h.lastFlush = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	operationCollection.UpdateOne(ctx, bson.M{"_id": h.op.ID}, bson.M{"$set": bson.M{
+		"done": done, "total": total, "updated_at": time.Now().UTC(),
+	}})
+}
+
+// Directory holding operation result files
+func exportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return "exports"
+}
+
+// Mark operations left behind by a previous process as failed
+func recoverOperations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	result, err := operationCollection.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$in": bson.A{OpPending, OpRunning}}},
+		bson.M{"$set": bson.M{
+			"status":      OpFailed,
+			"error":       OperationError{Code: "interrupted", Message: "Server restarted while the operation was running"},
+			"updated_at":  now,
+			"finished_at": now,
+		}},
+	)
+	if err != nil {
+		fmt.Printf("❌ Failed to recover operations: %v\n", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		fmt.Printf("⚠️  Marked %d interrupted operations as failed\n", result.ModifiedCount)
+	}
+}
+
+// Persist a new operation and run body in the background, returns it as pending
+func startOperation(opType string, body func(ctx context.Context, h *OperationHandle) error) (*Operation, error) {
+	now := time.Now().UTC()
+	op := &Operation{
+		ID:        primitive.NewObjectID(),
+		Type:      opType,
+		Status:    OpPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := operationCollection.InsertOne(ctx, op); err != nil {
+		return nil, err
+	}
+
+	runCtx, runCancel := context.WithTimeout(context.Background(), operationTimeout)
+	go watchCancellation(runCtx, op.ID, runCancel)
+
+	// The body goroutine owns op from here, the caller gets a copy
+	accepted := *op
+
+	go func() {
+		defer runCancel()
+
+		op.Status = OpRunning
+		saveOperation(op)
+
+		handle := &OperationHandle{op: op, lastFlush: time.Now()}
+		err := body(runCtx, handle)
+
+		finished := time.Now().UTC()
+		op.FinishedAt = &finished
+		switch {
+		case errors.Is(runCtx.Err(), context.Canceled):
+			op.Status = OpCanceled
+		case err != nil:
+			op.Status = OpFailed
+			op.Error = &OperationError{Code: "operation_failed", Message: err.Error()}
+		default:
+			op.Status = OpSucceeded
+		}
+		saveOperation(op)
+	}()
+
+	return &accepted, nil
+}
+
+// Cancel the operation once cancel_requested is set, until ctx ends
+func watchCancellation(ctx context.Context, id primitive.ObjectID, cancel context.CancelFunc) {
+	ticker := time.NewTicker(cancelPollEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var flag struct {
+			CancelRequested bool `bson:"cancel_requested"`
+		}
+		err := operationCollection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"cancel_requested": 1})).Decode(&flag)
+		if err == nil && flag.CancelRequested {
+			cancel()
+			return
+		}
+	}
+}
+
+// Fields are set rather than the document replaced, so a cancel_requested
+// written meanwhile by another instance survives
+func saveOperation(op *Operation) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	op.UpdatedAt = time.Now().UTC()
+	if _, err := operationCollection.UpdateOne(ctx, bson.M{"_id": op.ID}, bson.M{"$set": operationFields(op)}); err != nil {
+		fmt.Printf("❌ Failed to save operation %s: %v\n", op.ID.Hex(), err)
+	}
+}
+
+// op as a $set document, without _id and the cancellation flag
+func operationFields(op *Operation) bson.M {
+	fields := bson.M{}
+	if raw, err := bson.Marshal(op); err == nil {
+		bson.Unmarshal(raw, &fields)
+	}
+	delete(fields, "_id")
+	delete(fields, "cancel_requested")
+	return fields
+}
+
+// 202 Accepted pointing at the status resource
+func acceptedOperation(c *fiber.Ctx, op *Operation) error {
+	location := "/operations/" + op.ID.Hex()
+	c.Location(location)
+	return c.Status(202).JSON(fiber.Map{
+		"message":    "Operation accepted",
+		"operation":  op,
+		"status_url": location,
+	})
+}
+
+func findOperation(c *fiber.Ctx) (*Operation, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, apiError(c, 400, ErrInvalidID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var op Operation
+	if err := operationCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&op); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apiError(c, 404, ErrOperationNotFound)
+		}
+		return nil, apiError(c, 500, ErrFetchFailed)
+	}
+	return &op, nil
+}
+
+// GET /operations/:id
+func getOperation(c *fiber.Ctx) error {
+	op, err := findOperation(c)
+	if op == nil {
+		return err
+	}
+	if op.Status == OpPending || op.Status == OpRunning {
+		c.Set(fiber.HeaderRetryAfter, "2")
+	}
+	return c.JSON(op)
+}
+
+// DELETE /operations/:id flags a pending or running operation, the instance
+// running it cancels it within cancelPollEvery
+func cancelOperation(c *fiber.Ctx) error {
+	op, err := findOperation(c)
+	if op == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := operationCollection.UpdateOne(ctx,
+		bson.M{"_id": op.ID, "status": bson.M{"$in": bson.A{OpPending, OpRunning}}},
+		bson.M{"$set": bson.M{"cancel_requested": true, "updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return apiError(c, 500, ErrUpdateFailed)
+	}
+	if result.MatchedCount == 0 {
+		// Finished in the meantime, report how
+		if op, err = findOperation(c); op == nil {
+			return err
+		}
+		return apiError(c, 409, ErrOperationFinished, fiber.Map{"status": op.Status})
+	}
+	return c.Status(202).JSON(fiber.Map{"message": "Cancellation requested", "id": op.ID})
+}
+
+// GET /operations/:id/result downloads export files
+func getOperationResult(c *fiber.Ctx) error {
+	op, err := findOperation(c)
+	if op == nil {
+		return err
+	}
+	if op.Status != OpSucceeded || op.ResultURL == "" {
+		return apiError(c, 404, ErrResultUnavailable)
+	}
+
+	path := filepath.Join(exportDir(), op.ID.Hex()+".ndjson")
+	c.Attachment("users-" + op.ID.Hex() + ".ndjson")
+	return c.SendFile(path)
+}
+
+// POST /users/export writes every user to an NDJSON file
+func exportUsers(c *fiber.Ctx) error {
+	op, err := startOperation("export", func(ctx context.Context, h *OperationHandle) error {
+		total, err := users.Count(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(exportDir(), 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(exportDir(), h.op.ID.Hex()+".ndjson")
+		done, err := writeExport(ctx, path, func(done int64) { h.Progress(done, total) })
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+
+		h.Progress(done, done)
+		h.op.Result = bson.M{"exported": done}
+		h.op.ResultURL = "/operations/" + h.op.ID.Hex() + "/result"
+		return nil
+	})
+	if err != nil {
+		return apiError(c, 500, ErrOperationStartFailed)
+	}
+	return acceptedOperation(c, op)
+}
+
+// Stream all users to path as NDJSON
+func writeExport(ctx context.Context, path string, progress func(done int64)) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var done int64
+	err = users.Each(ctx, func(user User) error {
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+		done++
+		progress(done)
+		return nil
+	})
+	if err != nil {
+		return done, err
+	}
+	return done, w.Flush()
+}
+
+// POST /users/import inserts a JSON array of users in batches
+func importUsers(c *fiber.Ctx) error {
+	var batch []User
+	if err := c.BodyParser(&batch); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	if len(batch) == 0 {
+		return apiError(c, 400, ErrImportEmpty)
+	}
+
+	// Reject the whole import up front rather than half-applying it
+	lang := requestLanguage(c)
+	var invalid []fiber.Map
+	for i := range batch {
+		for _, e := range validateUser(&batch[i]) {
+			invalid = append(invalid, fiber.Map{
+				"index":   i,
+				"field":   e.Field,
+				"code":    e.Code,
+				"message": translate(lang, e.Code, e.params()),
+			})
+		}
+	}
+	if len(invalid) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   translate(lang, ErrValidationFailed, nil),
+			"code":    ErrValidationFailed,
+			"details": invalid,
+		})
+	}
+
+	if isDryRun(c) {
+		return dryRunResult(c, 200, fiber.Map{"message": "Users would be imported", "would_insert": len(batch)})
+	}
+
+	op, err := startOperation("import", func(ctx context.Context, h *OperationHandle) error {
+		total := int64(len(batch))
+		var inserted int64
+		for start := 0; start < len(batch); start += importBatchSize {
+			if err := ctx.Err(); err != nil {
+				h.op.Result = bson.M{"inserted": inserted}
+				return err
+			}
+
+			end := min(start+importBatchSize, len(batch))
+			chunk := make([]User, 0, end-start)
+			for _, user := range batch[start:end] {
+				user.ID = primitive.NilObjectID
+				chunk = append(chunk, user)
+			}
+			if err := users.InsertMany(ctx, chunk); err != nil {
+				h.op.Result = bson.M{"inserted": inserted}
+				return err
+			}
+			inserted += int64(len(chunk))
+			h.Progress(inserted, total)
+		}
+
+		h.op.Result = bson.M{"inserted": inserted}
+		return nil
+	})
+	if err != nil {
+		return apiError(c, 500, ErrOperationStartFailed)
+	}
+	return acceptedOperation(c, op)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Saving never clears a cancellation flag set by another instance
+func TestOperationFields(t *testing.T) {
+	op := &Operation{ID: primitive.NewObjectID(), Type: "export", Status: OpRunning, Done: 3, CancelRequested: true}
+	fields := operationFields(op)
+	if _, ok := fields["_id"]; ok {
+		t.Error("_id in $set fields")
+	}
+	if _, ok := fields["cancel_requested"]; ok {
+		t.Error("cancel_requested in $set fields")
+	}
+	if fields["status"] != OpRunning || fields["done"] != int64(3) || fields["type"] != "export" {
+		t.Errorf("got %v", fields)
+	}
+}
+
+// The flag is written to the document, so any instance can cancel
+func TestCancelOperation(t *testing.T) {
+	testMongoDB(t)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	op, err := startOperation("test", func(ctx context.Context, h *OperationHandle) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	app := fiber.New()
+	app.Delete("/operations/:id", cancelOperation)
+	cancel := func() (int, string) {
+		return testResponse(t, app, httptest.NewRequest("DELETE", "/operations/"+op.ID.Hex(), nil))
+	}
+
+	if status, body := cancel(); status != 202 {
+		t.Fatalf("cancel: got %d %s, want 202", status, body)
+	}
+	var stored Operation
+	deadline := time.Now().Add(5 * cancelPollEvery)
+	for time.Now().Before(deadline) {
+		if err := operationCollection.FindOne(ctx, bson.M{"_id": op.ID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status == OpCanceled {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if stored.Status != OpCanceled || !stored.CancelRequested {
+		t.Fatalf("got status %s, cancel_requested %v; want canceled and flagged", stored.Status, stored.CancelRequested)
+	}
+
+	if status, code := cancel(); status != 409 || code != ErrOperationFinished {
+		t.Errorf("cancel after finishing: got %d %q, want 409 %q", status, code, ErrOperationFinished)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const redacted = "[REDACTED]"
+
+// Recorded request/response pair, one per NDJSON line
+type RecordedExchange struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method"`
+	URI             string            `json:"uri"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	Status          int               `json:"status"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	// Streamed or larger than RECORD_MAX_BODY, replay then only compares status
+	ResponseBodyOmitted bool  `json:"response_body_omitted,omitempty"`
+	DurationMs          int64 `json:"duration_ms"`
+}
+
+// Recorder writes sampled exchanges to daily NDJSON files
+type Recorder struct {
+	dir           string
+	sampleRate    float64
+	maxBody       int
+	redactHeaders map[string]bool
+	redactFields  map[string]bool
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+// Build recorder from env, nil when RECORD_DIR is not set
+func newRecorderFromEnv() (*Recorder, error) {
+	dir := os.Getenv("RECORD_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	rate := 1.0
+	if v := os.Getenv("RECORD_SAMPLE_RATE"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return nil, fmt.Errorf("RECORD_SAMPLE_RATE must be between 0 and 1, got %q", v)
+		}
+		rate = parsed
+	}
+
+	maxBody := 64 * 1024
+	if v := os.Getenv("RECORD_MAX_BODY"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("RECORD_MAX_BODY must be a byte count, got %q", v)
+		}
+		maxBody = parsed
+	}
+
+	headers := os.Getenv("RECORD_REDACT_HEADERS")
+	if headers == "" {
+		headers = "Authorization,Cookie,Set-Cookie"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		dir:           dir,
+		sampleRate:    rate,
+		maxBody:       maxBody,
+		redactHeaders: splitSet(headers, true),
+		redactFields:  splitSet(os.Getenv("RECORD_REDACT_FIELDS"), false),
+	}, nil
+}
+
+// Comma separated list to set
+func splitSet(list string, fold bool) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if fold {
+			item = strings.ToLower(item)
+		}
+		set[item] = true
+	}
+	return set
+}
+
+// Middleware recording sampled traffic
+func (r *Recorder) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if r.sampleRate < 1 && rand.Float64() >= r.sampleRate {
+			return c.Next()
+		}
+
+		start := time.Now()
+		if err := c.Next(); err != nil {
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				return herr
+			}
+		}
+
+		exchange := RecordedExchange{
+			Time:            start.UTC(),
+			Method:          c.Method(),
+			URI:             string(c.Request().RequestURI()),
+			RequestHeaders:  map[string]string{},
+			RequestBody:     r.redactBody(c.Body()),
+			Status:          c.Response().StatusCode(),
+			ResponseHeaders: map[string]string{},
+			DurationMs:      time.Since(start).Milliseconds(),
+		}
+		// Body() would read a stream (exports, SendFile) into memory
+		if resp := c.Response(); resp.IsBodyStream() || len(resp.Body()) > r.maxBody {
+			exchange.ResponseBodyOmitted = true
+		} else {
+			exchange.ResponseBody = r.redactBody(resp.Body())
+		}
+		c.Request().Header.VisitAll(func(k, v []byte) {
+			exchange.RequestHeaders[string(k)] = r.redactHeader(string(k), string(v))
+		})
+		c.Response().Header.VisitAll(func(k, v []byte) {
+			exchange.ResponseHeaders[string(k)] = r.redactHeader(string(k), string(v))
+		})
+
+		if err := r.write(exchange); err != nil {
+			fmt.Printf("❌ Traffic recording failed: %v\n", err)
+		}
+		return nil
+	}
+}
+
+func (r *Recorder) redactHeader(key, value string) string {
+	if r.redactHeaders[strings.ToLower(key)] {
+		return redacted
+	}
+	return value
+}
+
+// Redact configured JSON fields, non-JSON bodies are kept as is
+func (r *Recorder) redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if len(r.redactFields) == 0 {
+		return string(body)
+	}
+
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return string(body)
+	}
+	out, err := json.Marshal(redactValue(doc, r.redactFields))
+	if err != nil {
+		return string(body)
+	}
+	return string(out)
+}
+
+func redactValue(v any, fields map[string]bool) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, inner := range val {
+			if fields[k] {
+				val[k] = redacted
+			} else {
+				val[k] = redactValue(inner, fields)
+			}
+		}
+	case []any:
+		for i, inner := range val {
+			val[i] = redactValue(inner, fields)
+		}
+	}
+	return v
+}
+
+// Append exchange to today's file
+func (r *Recorder) write(exchange RecordedExchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	day := exchange.Time.Format("2006-01-02")
+	if r.file == nil || r.day != day {
+		if r.file != nil {
+			r.file.Close()
+		}
+		path := filepath.Join(r.dir, "traffic-"+day+".ndjson")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			r.file = nil
+			return err
+		}
+		r.file, r.day = f, day
+	}
+
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close current file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
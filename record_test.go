@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func testRecordApp(t *testing.T, r *Recorder, greeting string) *fiber.App {
+	t.Helper()
+	app := fiber.New()
+	if r != nil {
+		app.Use(r.Middleware())
+	}
+	app.Post("/echo", func(c *fiber.Ctx) error {
+		var body map[string]any
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+		return c.Status(201).JSON(fiber.Map{"id": 7, "greeting": greeting, "name": body["name"]})
+	})
+	app.Get("/export", func(c *fiber.Ctx) error {
+		return c.SendStream(bytes.NewReader([]byte(`{"users":[]}`)))
+	})
+	app.Get("/big", func(c *fiber.Ctx) error {
+		return c.SendString(strings.Repeat("x", 100))
+	})
+	return app
+}
+
+// HTTP client answering from app, so replay needs no listener
+type appTransport struct{ app *fiber.App }
+
+func (t appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.app.Test(req, -1)
+}
+
+func recordTraffic(t *testing.T, requests ...*http.Request) []RecordedExchange {
+	t.Helper()
+	dir := t.TempDir()
+	r := &Recorder{
+		dir:           dir,
+		sampleRate:    1,
+		maxBody:       64,
+		redactHeaders: splitSet("Authorization", true),
+		redactFields:  splitSet("password", false),
+	}
+	app := testRecordApp(t, r, "hello")
+	for _, req := range requests {
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "traffic-*.ndjson"))
+	if len(files) != 1 {
+		t.Fatalf("got recordings %v, want one file", files)
+	}
+	exchanges, err := readRecording(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != len(requests) {
+		t.Fatalf("got %d exchanges, want %d", len(exchanges), len(requests))
+	}
+	return exchanges
+}
+
+func testEchoRequest() *http.Request {
+	req := httptest.NewRequest("POST", "/echo?x=1", strings.NewReader(`{"name":"Ann","password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestRecorderMiddleware(t *testing.T) {
+	exchanges := recordTraffic(t, testEchoRequest(), httptest.NewRequest("GET", "/export", nil), httptest.NewRequest("GET", "/big", nil))
+
+	echo := exchanges[0]
+	if echo.Method != "POST" || echo.URI != "/echo?x=1" || echo.Status != 201 {
+		t.Errorf("echo: got %s %s %d", echo.Method, echo.URI, echo.Status)
+	}
+	if echo.RequestHeaders["Authorization"] != redacted {
+		t.Errorf("authorization header: got %q", echo.RequestHeaders["Authorization"])
+	}
+	if echo.RequestBody != `{"name":"Ann","password":"[REDACTED]"}` {
+		t.Errorf("request body: got %s", echo.RequestBody)
+	}
+	if echo.ResponseBodyOmitted || !strings.Contains(echo.ResponseBody, `"greeting":"hello"`) {
+		t.Errorf("response body: got %q, omitted %v", echo.ResponseBody, echo.ResponseBodyOmitted)
+	}
+
+	for _, e := range exchanges[1:] {
+		if !e.ResponseBodyOmitted || e.ResponseBody != "" || e.Status != 200 {
+			t.Errorf("%s: got body %q, omitted %v, status %d; want omitted", e.URI, e.ResponseBody, e.ResponseBodyOmitted, e.Status)
+		}
+	}
+}
+
+func TestReplayExchange(t *testing.T) {
+	exchanges := recordTraffic(t, testEchoRequest(), httptest.NewRequest("GET", "/export", nil))
+
+	tests := []struct {
+		name     string
+		greeting string
+		want     [][]string // diffs per exchange
+	}{
+		{"same behavior", "hello", [][]string{nil, nil}},
+		{"changed response", "bonjour", [][]string{{`$.greeting: expected "hello", got "bonjour"`}, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: appTransport{testRecordApp(t, nil, tt.greeting)}}
+			for i, e := range exchanges {
+				// The redacted password and header are not sent, the id is ignored
+				diffs, err := replayExchange(client, "http://replay.test", e, splitSet("id", false))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Join(diffs, "\n") != strings.Join(tt.want[i], "\n") {
+					t.Errorf("%s: got %q, want %q", e.URI, diffs, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiffBodies(t *testing.T) {
+	tests := []struct {
+		expected, actual string
+		want             []string
+	}{
+		{`{"a":1,"id":"x"}`, `{"id":"y","a":1}`, nil},
+		{`{"a":[1,2]}`, `{"a":[1,3]}`, []string{"$.a[1]: expected 2, got 3"}},
+		{`{"a":[1,2]}`, `{"a":[1]}`, []string{"$.a: expected [1,2], got [1]"}},
+		{`{"a":1}`, `{"b":1}`, []string{"$.a: expected 1, got null", "$.b: expected null, got 1"}},
+		{`plain`, `plain`, nil},
+		{`plain`, `other`, []string{`body: expected "plain", got "other"`}},
+	}
+	for _, tt := range tests {
+		got := diffBodies(tt.expected, tt.actual, splitSet("id", false))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s vs %s: got %q, want %q", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
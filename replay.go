@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// replay command: re-send recorded traffic and diff responses
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:5000", "base URL of the instance to replay against")
+	ignore := fs.String("ignore", "_id,id", "comma separated JSON fields ignored when diffing")
+	timeout := fs.Duration("timeout", 10*time.Second, "per request timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-fiber-api replay [flags] <file.ndjson>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no recording files given")
+	}
+
+	httpClient := &http.Client{Timeout: *timeout}
+	ignored := splitSet(*ignore, false)
+	base := strings.TrimRight(*target, "/")
+
+	total, mismatched := 0, 0
+	for _, path := range fs.Args() {
+		exchanges, err := readRecording(path)
+		if err != nil {
+			return err
+		}
+
+		for _, exchange := range exchanges {
+			total++
+			diffs, err := replayExchange(httpClient, base, exchange, ignored)
+			if err != nil {
+				mismatched++
+				fmt.Printf("❌ %s %s: %v\n", exchange.Method, exchange.URI, err)
+				continue
+			}
+			if len(diffs) > 0 {
+				mismatched++
+				fmt.Printf("❌ %s %s\n", exchange.Method, exchange.URI)
+				for _, d := range diffs {
+					fmt.Printf("    %s\n", d)
+				}
+				continue
+			}
+			fmt.Printf("✅ %s %s\n", exchange.Method, exchange.URI)
+		}
+	}
+
+	fmt.Printf("\n📊 Replayed %d requests, %d mismatched\n", total, mismatched)
+	if mismatched > 0 {
+		return fmt.Errorf("%d responses differ", mismatched)
+	}
+	return nil
+}
+
+func readRecording(path string) ([]RecordedExchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var exchanges []RecordedExchange
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var exchange RecordedExchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, scanner.Err()
+}
+
+// Send one recorded request and return the differences found
+func replayExchange(httpClient *http.Client, base string, exchange RecordedExchange, ignored map[string]bool) ([]string, error) {
+	req, err := http.NewRequest(exchange.Method, base+exchange.URI, strings.NewReader(exchange.RequestBody))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range exchange.RequestHeaders {
+		// Redacted values are useless, let the target reject them instead
+		if v == redacted || strings.EqualFold(k, "Host") || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	if resp.StatusCode != exchange.Status {
+		diffs = append(diffs, fmt.Sprintf("status: expected %d, got %d", exchange.Status, resp.StatusCode))
+	}
+	if exchange.ResponseBodyOmitted {
+		return diffs, nil
+	}
+	return append(diffs, diffBodies(exchange.ResponseBody, string(body), ignored)...), nil
+}
+
+// Structural diff for JSON bodies, plain comparison otherwise
+func diffBodies(expected, actual string, ignored map[string]bool) []string {
+	var exp, act any
+	if json.Unmarshal([]byte(expected), &exp) != nil || json.Unmarshal([]byte(actual), &act) != nil {
+		if expected != actual {
+			return []string{fmt.Sprintf("body: expected %q, got %q", expected, actual)}
+		}
+		return nil
+	}
+	return diffJSON("$", exp, act, ignored)
+}
+
+func diffJSON(path string, exp, act any, ignored map[string]bool) []string {
+	expMap, expIsMap := exp.(map[string]any)
+	actMap, actIsMap := act.(map[string]any)
+	if expIsMap && actIsMap {
+		keys := map[string]bool{}
+		for k := range expMap {
+			keys[k] = true
+		}
+		for k := range actMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			if !ignored[k] {
+				sorted = append(sorted, k)
+			}
+		}
+		sort.Strings(sorted)
+
+		var diffs []string
+		for _, k := range sorted {
+			diffs = append(diffs, diffJSON(path+"."+k, expMap[k], actMap[k], ignored)...)
+		}
+		return diffs
+	}
+
+	expList, expIsList := exp.([]any)
+	actList, actIsList := act.([]any)
+	if expIsList && actIsList && len(expList) == len(actList) {
+		var diffs []string
+		for i := range expList {
+			diffs = append(diffs, diffJSON(fmt.Sprintf("%s[%d]", path, i), expList[i], actList[i], ignored)...)
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(exp, act) {
+		e, _ := json.Marshal(exp)
+		a, _ := json.Marshal(act)
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, e, a)}
+	}
+	return nil
+}
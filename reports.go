@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report limits, definitions choose within them
+const (
+	maxReportStages        = 20
+	maxReportRows          = 10000
+	defaultReportRows      = 1000
+	minReportTimeoutMS     = 100
+	maxReportTimeoutMS     = 30000
+	defaultReportTimeoutMS = 5000
+)
+
+// Read-only stages over the users collection. Nothing that writes ($out,
+// $merge), reads other collections ($lookup, $unionWith) or inspects the server.
+var reportStages = []string{
+	"$match", "$project", "$addFields", "$set", "$unset", "$group", "$sort",
+	"$limit", "$skip", "$count", "$unwind", "$bucket", "$bucketAuto",
+	"$sortByCount", "$facet", "$replaceRoot", "$replaceWith", "$sample",
+}
+
+// Query and expression operators; no JavaScript ($where, $function, $accumulator)
+var reportOperators = []string{
+	// query
+	"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin", "$and", "$or",
+	"$nor", "$not", "$exists", "$type", "$regex", "$options", "$size", "$all",
+	"$elemMatch", "$expr",
+	// accumulators
+	"$sum", "$avg", "$min", "$max", "$first", "$last", "$push", "$addToSet",
+	"$count", "$stdDevPop", "$stdDevSamp",
+	// arithmetic, strings, dates, conversion, arrays, conditionals
+	"$add", "$subtract", "$multiply", "$divide", "$mod", "$round", "$floor",
+	"$ceil", "$abs", "$trunc", "$cmp", "$concat", "$toLower", "$toUpper",
+	"$substrCP", "$strLenCP", "$split", "$trim", "$year", "$month", "$dayOfMonth",
+	"$dayOfWeek", "$hour", "$dateToString", "$toString", "$toInt", "$toLong",
+	"$toDouble", "$toBool", "$toDate", "$literal", "$arrayElemAt", "$filter",
+	"$map", "$slice", "$isArray", "$cond", "$ifNull", "$switch", "$setUnion",
+	"$objectToArray", "$arrayToObject", "$mergeObjects",
+}
+
+// Admin-defined aggregation over users, run by GET /reports/:name
+type Report struct {
+	Name        string        `json:"name" bson:"_id"`
+	Description string        `json:"description,omitempty" bson:"description,omitempty"`
+	Params      []CustomField `json:"params,omitempty" bson:"params,omitempty"`
+	// Stages as JSON; string values of exactly "{{param}}" are replaced by the
+	// typed parameter value when the report runs
+	Pipeline json.RawMessage `json:"pipeline" bson:"-"`
+	// Pipeline as stored: Mongo restricts "$" field names inside documents
+	Source    string    `json:"-" bson:"pipeline"`
+	MaxRows   int       `json:"max_rows" bson:"max_rows"`
+	TimeoutMS int       `json:"timeout_ms" bson:"timeout_ms"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+var reportCollection *mongo.Collection
+
+// Stages with nested documents kept in order, as $sort needs
+func (r Report) stages() (bson.A, error) {
+	src := r.Source
+	if src == "" {
+		src = string(r.Pipeline)
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(`{"pipeline":`+src+`}`), false, &doc); err != nil {
+		return nil, err
+	}
+	stages, ok := doc[0].Value.(bson.A)
+	if !ok {
+		return nil, errors.New("pipeline must be an array")
+	}
+	return stages, nil
+}
+
+// Check a definition, filling defaults and normalizing parameters
+func (r *Report) check() []*FieldError {
+	var errs []*FieldError
+	if !customFieldName.MatchString(r.Name) {
+		errs = append(errs, &FieldError{Field: "name", Code: ErrFieldInvalidFormat, Params: fiber.Map{"pattern": customFieldName.String()}})
+	}
+
+	declared := map[string]bool{}
+	for i := range r.Params {
+		p := &r.Params[i]
+		for _, ferr := range p.check() {
+			ferr.Field = fmt.Sprintf("params.%d.%s", i, ferr.Field)
+			errs = append(errs, ferr)
+		}
+		if declared[p.Name] || p.Name == "format" {
+			errs = append(errs, &FieldError{Field: fmt.Sprintf("params.%d.name", i), Code: ErrReportParamTaken, Params: fiber.Map{"name": p.Name}})
+		}
+		declared[p.Name] = true
+	}
+
+	if r.MaxRows == 0 {
+		r.MaxRows = defaultReportRows
+	}
+	if r.MaxRows < 1 || r.MaxRows > maxReportRows {
+		errs = append(errs, &FieldError{Field: "max_rows", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 1, "max": maxReportRows}})
+	}
+	if r.TimeoutMS == 0 {
+		r.TimeoutMS = defaultReportTimeoutMS
+	}
+	if r.TimeoutMS < minReportTimeoutMS || r.TimeoutMS > maxReportTimeoutMS {
+		errs = append(errs, &FieldError{Field: "timeout_ms", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": minReportTimeoutMS, "max": maxReportTimeoutMS}})
+	}
+
+	stages, err := r.stages()
+	if err != nil {
+		return append(errs, &FieldError{Field: "pipeline", Code: ErrFieldWrongType, Params: fiber.Map{"type": "array"}})
+	}
+	errs = append(errs, checkReportPipeline("pipeline", stages, declared, true)...)
+
+	// Stored compact, keys in the order given
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, r.Pipeline); err != nil {
+		return append(errs, &FieldError{Field: "pipeline", Code: ErrFieldWrongType, Params: fiber.Map{"type": "array"}})
+	}
+	r.Source = compact.String()
+	return errs
+}
+
+// Safelist check of a pipeline; $facet pipelines are checked but may not nest
+func checkReportPipeline(path string, stages bson.A, params map[string]bool, top bool) []*FieldError {
+	if len(stages) == 0 || len(stages) > maxReportStages {
+		return []*FieldError{{Field: path, Code: ErrReportStages, Params: fiber.Map{"max": maxReportStages}}}
+	}
+	var errs []*FieldError
+	for i, s := range stages {
+		stagePath := fmt.Sprintf("%s.%d", path, i)
+		stage, ok := s.(bson.D)
+		if !ok || len(stage) != 1 {
+			errs = append(errs, &FieldError{Field: stagePath, Code: ErrReportStages, Params: fiber.Map{"max": maxReportStages}})
+			continue
+		}
+		name, body := stage[0].Key, stage[0].Value
+		if !slices.Contains(reportStages, name) || (name == "$facet" && !top) {
+			errs = append(errs, &FieldError{Field: stagePath, Code: ErrReportNotAllowed, Params: fiber.Map{"name": name}})
+			continue
+		}
+		if name == "$facet" {
+			facets, _ := body.(bson.D)
+			for _, f := range facets {
+				sub, _ := f.Value.(bson.A)
+				errs = append(errs, checkReportPipeline(stagePath+".$facet."+f.Key, sub, params, false)...)
+			}
+			continue
+		}
+		errs = append(errs, checkReportValue(stagePath+"."+name, body, params)...)
+	}
+	return errs
+}
+
+// Every "$" key must be safelisted and every placeholder declared
+func checkReportValue(path string, v any, params map[string]bool) []*FieldError {
+	var errs []*FieldError
+	switch x := v.(type) {
+	case bson.D:
+		for _, e := range x {
+			if strings.HasPrefix(e.Key, "$") && !slices.Contains(reportOperators, e.Key) {
+				errs = append(errs, &FieldError{Field: path, Code: ErrReportNotAllowed, Params: fiber.Map{"name": e.Key}})
+				continue
+			}
+			errs = append(errs, checkReportValue(path+"."+e.Key, e.Value, params)...)
+		}
+	case bson.A:
+		for i, e := range x {
+			errs = append(errs, checkReportValue(fmt.Sprintf("%s.%d", path, i), e, params)...)
+		}
+	case string:
+		if name, ok := reportPlaceholder(x); ok && !params[name] {
+			errs = append(errs, &FieldError{Field: path, Code: ErrReportParamUndeclared, Params: fiber.Map{"name": name}})
+		}
+	}
+	return errs
+}
+
+// "{{name}}" -> name
+func reportPlaceholder(s string) (string, bool) {
+	name, ok := strings.CutPrefix(s, "{{")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(name, "}}")
+}
+
+// Copy of v with placeholders replaced by their values
+func bindReport(v any, values map[string]any) any {
+	switch x := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(x))
+		for i, e := range x {
+			out[i] = bson.E{Key: e.Key, Value: bindReport(e.Value, values)}
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(x))
+		for i, e := range x {
+			out[i] = bindReport(e, values)
+		}
+		return out
+	case string:
+		if name, ok := reportPlaceholder(x); ok {
+			return values[name]
+		}
+	}
+	return v
+}
+
+// Parameter values from the query string. Strings may not start with "$",
+// which expressions would read as a field path.
+func (r Report) bind(c *fiber.Ctx) (map[string]any, []*FieldError) {
+	var errs []*FieldError
+	values := map[string]any{}
+	for _, p := range r.Params {
+		raw := c.Query(p.Name)
+		if raw == "" {
+			switch {
+			case p.Default != nil:
+				values[p.Name] = p.Default
+			case p.Required:
+				errs = append(errs, &FieldError{Field: p.Name, Code: ErrFieldRequired})
+			default:
+				values[p.Name] = nil
+			}
+			continue
+		}
+		v, ferr := p.parse(p.Name, raw)
+		if ferr == nil {
+			v, ferr = p.coerce(p.Name, v)
+		}
+		if ferr == nil && strings.HasPrefix(raw, "$") && p.Type == CustomString {
+			ferr = &FieldError{Field: p.Name, Code: ErrFieldInvalidFormat, Params: fiber.Map{"pattern": "^[^$]"}}
+		}
+		if ferr != nil {
+			errs = append(errs, ferr)
+			continue
+		}
+		values[p.Name] = v
+	}
+
+	for param := range c.Queries() {
+		if param != "format" && !slices.ContainsFunc(r.Params, func(p CustomField) bool { return p.Name == param }) {
+			errs = append(errs, &FieldError{Field: param, Code: ErrFieldUnknown})
+		}
+	}
+	return values, errs
+}
+
+// BSON result values as JSON friendly ones
+func reportValue(v any) any {
+	switch x := v.(type) {
+	case bson.D:
+		m := make(fiber.Map, len(x))
+		for _, e := range x {
+			m[e.Key] = reportValue(e.Value)
+		}
+		return m
+	case bson.A:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = reportValue(e)
+		}
+		return out
+	case primitive.DateTime:
+		return x.Time().UTC()
+	case primitive.ObjectID:
+		return x.Hex()
+	}
+	return v
+}
+
+// CSV cell; text that spreadsheets would run as a formula is quoted
+func reportCell(v any) string {
+	switch x := reportValue(v).(type) {
+	case nil:
+		return ""
+	case string:
+		if x != "" && strings.ContainsRune("=+-@", rune(x[0])) {
+			return "'" + x
+		}
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339)
+	case fiber.Map, []any:
+		data, _ := json.Marshal(x)
+		return string(data)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// Rows as CSV, columns in first seen order
+func writeReportCSV(c *fiber.Ctx, name string, rows []bson.D) error {
+	var columns []string
+	for _, row := range rows {
+		for _, e := range row {
+			if !slices.Contains(columns, e.Key) {
+				columns = append(columns, e.Key)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for _, e := range row {
+			record[slices.Index(columns, e.Key)] = reportCell(e.Value)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+	return c.SendString(sb.String())
+}
+
+// GET /reports
+func listReports(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := reportCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	list := []Report{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	for i := range list {
+		list[i].Pipeline = json.RawMessage(list[i].Source)
+	}
+	return c.JSON(list)
+}
+
+// PUT /reports/:name creates or replaces a definition
+func putReport(c *fiber.Ctx) error {
+	var report Report
+	if err := json.Unmarshal(c.Body(), &report); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	report.Name = c.Params("name")
+	if errs := report.check(); len(errs) > 0 {
+		return validationError(c, errs)
+	}
+	report.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := reportCollection.ReplaceOne(ctx, bson.M{"_id": report.Name}, report, options.Replace().SetUpsert(true))
+	if err != nil {
+		return apiError(c, 500, ErrReportSaveFailed)
+	}
+	status := 200
+	if res.UpsertedCount > 0 {
+		status = 201
+	}
+	report.Pipeline = json.RawMessage(report.Source)
+	return c.Status(status).JSON(report)
+}
+
+// DELETE /reports/:name
+func deleteReport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var report Report
+	if err := reportCollection.FindOneAndDelete(ctx, bson.M{"_id": c.Params("name")}).Decode(&report); err != nil {
+		return lookupError(c, err, ErrReportNotFound)
+	}
+	report.Pipeline = json.RawMessage(report.Source)
+	return c.JSON(fiber.Map{"message": "Report deleted", "report": report})
+}
+
+// GET /reports/:name?param=...&format=csv runs a report over users. At most
+// MaxRows rows are returned, flagged as truncated when there were more.
+func runReport(c *fiber.Ctx) error {
+	format := c.Query("format", "json")
+	if format != "json" && format != "csv" {
+		return validationError(c, []*FieldError{{Field: "format", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "json, csv"}}})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var report Report
+	err := reportCollection.FindOne(ctx, bson.M{"_id": c.Params("name")}).Decode(&report)
+	cancel()
+	if err != nil {
+		return lookupError(c, err, ErrReportNotFound)
+	}
+
+	values, errs := report.bind(c)
+	if len(errs) > 0 {
+		return validationError(c, errs)
+	}
+	stages, err := report.stages()
+	if err != nil {
+		return apiError(c, 500, ErrReportFailed)
+	}
+	pipeline := bindReport(stages, values).(bson.A)
+	pipeline = append(pipeline, bson.D{{Key: "$limit", Value: report.MaxRows + 1}})
+
+	timeout := time.Duration(report.TimeoutMS) * time.Millisecond
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	started := time.Now()
+	cursor, err := userCollection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(timeout))
+	var rows []bson.D
+	if err == nil {
+		err = cursor.All(ctx, &rows)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+			return apiError(c, 504, ErrReportTimeout, fiber.Map{"timeout_ms": report.TimeoutMS})
+		}
+		fmt.Printf("⚠️  Report %s failed: %v\n", report.Name, err)
+		return apiError(c, 500, ErrReportFailed)
+	}
+
+	truncated := len(rows) > report.MaxRows
+	if truncated {
+		rows = rows[:report.MaxRows]
+		c.Set("X-Report-Truncated", "true")
+	}
+	fmt.Printf("📊 Report %s: %d rows in %s\n", report.Name, len(rows), time.Since(started).Round(time.Millisecond))
+
+	if format == "csv" {
+		return writeReportCSV(c, report.Name, rows)
+	}
+	out := make([]any, len(rows))
+	for i, row := range rows {
+		out[i] = reportValue(row)
+	}
+	return c.JSON(fiber.Map{"report": report.Name, "rows": out, "count": len(out), "truncated": truncated})
+}
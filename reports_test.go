@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReportCheck(t *testing.T) {
+	params := []CustomField{{Name: "min_age", Type: CustomInteger}}
+	tests := []struct {
+		name     string
+		pipeline string
+		want     [][2]string
+	}{
+		{"allowed", `[{"$match":{"age":{"$gte":"{{min_age}}"}}},{"$group":{"_id":"$age","n":{"$sum":1}}},{"$sort":{"n":-1}}]`, nil},
+		{"facet", `[{"$facet":{"young":[{"$match":{"age":{"$lt":30}}}],"all":[{"$count":"n"}]}}]`, nil},
+		{"empty", `[]`, [][2]string{{"pipeline", ErrReportStages}}},
+		{"not an array", `{"$match":{}}`, [][2]string{{"pipeline", ErrFieldWrongType}}},
+		{"two keys in a stage", `[{"$match":{},"$sort":{"age":1}}]`, [][2]string{{"pipeline.0", ErrReportStages}}},
+		{"writing stage", `[{"$match":{}},{"$out":"copy"}]`, [][2]string{{"pipeline.1", ErrReportNotAllowed}}},
+		{"other collection", `[{"$lookup":{"from":"secrets","as":"s"}}]`, [][2]string{{"pipeline.0", ErrReportNotAllowed}}},
+		{"javascript", `[{"$match":{"$where":"sleep(1000)"}}]`, [][2]string{{"pipeline.0.$match", ErrReportNotAllowed}}},
+		{"nested operator", `[{"$project":{"x":{"$function":{"body":"f","args":[],"lang":"js"}}}}]`, [][2]string{{"pipeline.0.$project.x", ErrReportNotAllowed}}},
+		{"in an array", `[{"$match":{"$or":[{"age":1},{"$where":"x"}]}}]`, [][2]string{{"pipeline.0.$match.$or.1", ErrReportNotAllowed}}},
+		{"facet checked", `[{"$facet":{"a":[{"$out":"copy"}]}}]`, [][2]string{{"pipeline.0.$facet.a.0", ErrReportNotAllowed}}},
+		{"nested facet", `[{"$facet":{"a":[{"$facet":{"b":[{"$count":"n"}]}}]}}]`, [][2]string{{"pipeline.0.$facet.a.0", ErrReportNotAllowed}}},
+		{"undeclared parameter", `[{"$match":{"name":"{{who}}"}}]`, [][2]string{{"pipeline.0.$match.name", ErrReportParamUndeclared}}},
+	}
+	for _, tt := range tests {
+		r := Report{Name: "ages", Params: params, Pipeline: json.RawMessage(tt.pipeline)}
+		if got := errorCodes(r.check()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	var long []string
+	for range maxReportStages + 1 {
+		long = append(long, `{"$skip":0}`)
+	}
+	r := Report{Name: "long", Pipeline: json.RawMessage("[" + strings.Join(long, ",") + "]")}
+	if got := errorCodes(r.check()); !reflect.DeepEqual(got, [][2]string{{"pipeline", ErrReportStages}}) {
+		t.Errorf("%d stages: got %q", len(long), got)
+	}
+}
+
+func TestReportCheckDefinition(t *testing.T) {
+	r := Report{
+		Name:     "By-Age",
+		Params:   []CustomField{{Name: "n", Type: CustomInteger}, {Name: "n", Type: CustomInteger}, {Name: "format", Type: CustomString}},
+		Pipeline: json.RawMessage("[ {\"$sort\": {\"age\": -1, \"name\": 1}} ]"),
+		MaxRows:  maxReportRows + 1,
+	}
+	want := [][2]string{
+		{"name", ErrFieldInvalidFormat},
+		{"params.1.name", ErrReportParamTaken},
+		{"params.2.name", ErrReportParamTaken},
+		{"max_rows", ErrFieldOutOfRange},
+	}
+	if got := errorCodes(r.check()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Defaults filled, source compact with keys in the order given
+	r = Report{Name: "by_age", Pipeline: json.RawMessage("[ {\"$sort\": {\"age\": -1, \"name\": 1}} ]")}
+	if errs := r.check(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %q", errorCodes(errs))
+	}
+	if r.MaxRows != defaultReportRows || r.TimeoutMS != defaultReportTimeoutMS {
+		t.Errorf("defaults: got %d rows %d ms", r.MaxRows, r.TimeoutMS)
+	}
+	if r.Source != `[{"$sort":{"age":-1,"name":1}}]` {
+		t.Errorf("source %s", r.Source)
+	}
+	stages, err := r.stages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := stages[0].(bson.D)[0].Value.(bson.D); keys[0].Key != "age" || keys[1].Key != "name" {
+		t.Errorf("sort keys reordered: %v", keys)
+	}
+}
+
+func TestBindReport(t *testing.T) {
+	stages := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "age", Value: bson.D{{Key: "$gte", Value: "{{min_age}}"}}},
+			{Key: "tags", Value: bson.D{{Key: "$in", Value: bson.A{"{{tag}}", "{{tag"}}}},
+		}}},
+	}
+	got := bindReport(stages, map[string]any{"min_age": int64(30), "tag": nil})
+	want := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "age", Value: bson.D{{Key: "$gte", Value: int64(30)}}},
+			{Key: "tags", Value: bson.D{{Key: "$in", Value: bson.A{nil, "{{tag"}}}},
+		}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The stored stages are left as they were
+	if v := stages[0].(bson.D)[0].Value.(bson.D)[0].Value.(bson.D)[0].Value; v != "{{min_age}}" {
+		t.Errorf("stages changed: %v", v)
+	}
+}
+
+func TestReportBindQuery(t *testing.T) {
+	report := Report{Params: []CustomField{
+		{Name: "min_age", Type: CustomInteger, Required: true},
+		{Name: "tag", Type: CustomString, Default: "vip"},
+		{Name: "name", Type: CustomString},
+	}}
+
+	var values map[string]any
+	var errs []*FieldError
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		values, errs = report.bind(c)
+		return nil
+	})
+
+	tests := []struct {
+		query  string
+		values map[string]any
+		errs   [][2]string
+	}{
+		{"min_age=30&format=csv", map[string]any{"min_age": int64(30), "tag": "vip", "name": nil}, nil},
+		{"min_age=30&tag=new&name=Ann", map[string]any{"min_age": int64(30), "tag": "new", "name": "Ann"}, nil},
+		{"", nil, [][2]string{{"min_age", ErrFieldRequired}}},
+		{"min_age=old", nil, [][2]string{{"min_age", ErrFieldWrongType}}},
+		{"min_age=30&name=$password", nil, [][2]string{{"name", ErrFieldInvalidFormat}}},
+		{"min_age=30&limit=5", nil, [][2]string{{"limit", ErrFieldUnknown}}},
+	}
+	for _, tt := range tests {
+		if _, err := app.Test(httptest.NewRequest("GET", "/?"+tt.query, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if got := errorCodes(errs); !reflect.DeepEqual(got, tt.errs) {
+			t.Errorf("%q: errors %q, want %q", tt.query, got, tt.errs)
+		}
+		if tt.values != nil && !reflect.DeepEqual(values, tt.values) {
+			t.Errorf("%q: values %v, want %v", tt.query, values, tt.values)
+		}
+	}
+}
+
+func TestReportCell(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	id := primitive.NewObjectID()
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{nil, ""},
+		{"Ann", "Ann"},
+		{"=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@sum", "'@sum"},
+		{int32(42), "42"},
+		{3.5, "3.5"},
+		{true, "true"},
+		{primitive.NewDateTimeFromTime(at), "2024-05-01T12:00:00Z"},
+		{id, id.Hex()},
+		{bson.A{"a", int32(1)}, `["a",1]`},
+		{bson.D{{Key: "n", Value: int32(1)}}, `{"n":1}`},
+	}
+	for _, tt := range tests {
+		if got := reportCell(tt.v); got != tt.want {
+			t.Errorf("reportCell(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestWriteReportCSV(t *testing.T) {
+	rows := []bson.D{
+		{{Key: "_id", Value: int32(30)}, {Key: "n", Value: int32(2)}},
+		{{Key: "n", Value: int32(1)}, {Key: "names", Value: bson.A{"Ann", "Bob"}}},
+	}
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error { return writeReportCSV(c, "ages", rows) })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	want := "_id,n,names\n30,2,\n,1,\"[\"\"Ann\"\",\"\"Bob\"\"]\"\n"
+	if string(body) != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+	if got := resp.Header.Get(fiber.HeaderContentDisposition); got != `attachment; filename="ages.csv"` {
+		t.Errorf("content disposition %q", got)
+	}
+}
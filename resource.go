@@ -0,0 +1,812 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Resource operations, also the keys of Resource.Before
+const (
+	OpList   = "list"
+	OpGet    = "get"
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpPatch  = "patch"
+	OpDelete = "delete"
+)
+
+// Returned by resource stores when the path key is malformed
+var ErrInvalidKey = errors.New("invalid key")
+
+// Storage behind a Resource, items are addressed by the path key as given
+type ResourceStore[T any] interface {
+	List(ctx context.Context, q ResourceQuery) ([]T, error)
+	Get(ctx context.Context, key string) (T, error)
+	// Create assigns an identity when the item has none
+	Create(ctx context.Context, item *T) error
+	// Update replaces the item and returns it as stored
+	Update(ctx context.Context, key string, item T) (T, error)
+	Delete(ctx context.Context, key string) (T, error)
+}
+
+// Optionally implemented by resource stores to answer $count, include_count
+// and HEAD without listing every match
+type ResourceCounter interface {
+	// Count ignores the sort and page of q
+	Count(ctx context.Context, q ResourceQuery) (ResourceCount, error)
+}
+
+// Number of matches, sent as X-Total-Count. Estimated counts come from
+// metadata or stand in for an exact count that took too long.
+type ResourceCount struct {
+	N         int64
+	Estimated bool
+}
+
+// Query parameter filtering a list
+type ResourceFilter struct {
+	Param       string
+	Description string
+	Schema      fiber.Map
+	// Parse converts the raw value, nil keeps the string
+	Parse func(param, raw string) (any, *FieldError)
+}
+
+// Parsed list request: filters by parameter, $filter, sort keys and page
+type ResourceQuery struct {
+	Filters map[string]any
+	Where   ODataExpr // nil without $filter
+	Sort    []SortKey // of Resource.Sorts, empty for the store's natural order
+	Limit   int       // 0 means no limit
+	Offset  int
+	// Applied by the resource itself: $select fields, $count and include_count
+	Select       []string
+	Count        bool
+	IncludeCount bool
+}
+
+// OData system query options understood by list routes
+var odataOptions = []string{"$filter", "$orderby", "$select", "$top", "$skip", "$count"}
+
+// Write passed to hooks. Before is the stored item for updates, patches and
+// deletes; Item is what is written, or what is deleted.
+type ResourceWrite[T any] struct {
+	Op       string
+	Key      string
+	Before   *T
+	Item     *T
+	DryRun   bool
+	Warnings []string // reported by dry runs
+}
+
+type ResourceHooks[T any] struct {
+	// After validation, also on dry runs. May adjust the item; an error aborts
+	// the write, a *FieldError as a 400.
+	BeforeWrite func(ctx context.Context, w *ResourceWrite[T]) error
+	// After the write succeeded, never on dry runs
+	AfterWrite func(ctx context.Context, w *ResourceWrite[T])
+	// Answers a get whose key matched nothing, instead of the 404
+	NotFound func(c *fiber.Ctx, key string) error
+}
+
+// Error codes answered by a Resource
+type ResourceErrors struct {
+	InvalidKey string
+	NotFound   string
+	Fetch      string
+	Create     string
+	Update     string
+	Delete     string
+}
+
+// CRUD routes for one collection: list (filters, sort, limit/offset), get,
+// create, update (PUT), patch (JSON merge patch) and delete, with validation,
+// dry runs, hooks and the matching OpenAPI paths.
+type Resource[T any] struct {
+	Name  string // item key in responses, "user"
+	Title string // subject of messages, "User"
+	Path  string // collection path, "/users"
+	Key   string // path parameter addressing an item, "id"
+	Ops   []string
+	Store ResourceStore[T]
+
+	Validate func(item *T) []*FieldError
+	// Checks updates and patches instead of Validate when set, current is the stored item
+	ValidateChange func(current T, item *T) []*FieldError
+	// Item as stored over current, i.e. with current's identity
+	Replace func(current, item T) T
+	// Reported as "id" by creates
+	ID func(item T) any
+	// Request schema (see schema.go) checked against bodies and patched documents
+	BodySchema string
+	// JSON field left out of patched documents before the schema check
+	IDField string
+
+	Filters func() []ResourceFilter
+	// Query parameter prefixes that must name a declared filter
+	Reserved []string
+	// Sort keys, each also accepted with a leading "-"
+	Sorts    func() []string
+	MaxLimit int
+	// Properties for $filter and $select by stored path ("custom.department")
+	// with their type (CustomString...); nil disables both
+	Fields func() map[string]string
+
+	// Handlers run before an operation, e.g. to answer it another way
+	Before map[string][]fiber.Handler
+	Hooks  ResourceHooks[T]
+	Errors ResourceErrors
+
+	// OpenAPI schema names of an item and of a request body
+	Component      string
+	InputComponent string
+	// Adjusts a generated OpenAPI operation
+	Describe func(op string, operation fiber.Map)
+}
+
+func (r *Resource[T]) has(op string) bool {
+	return len(r.Ops) == 0 || slices.Contains(r.Ops, op)
+}
+
+// Add the routes of every enabled operation
+func (r *Resource[T]) Register(router fiber.Router) {
+	item := r.Path + "/:" + r.Key
+	routes := []struct {
+		op, method, path string
+		handler          fiber.Handler
+	}{
+		{OpList, fiber.MethodGet, r.Path, r.list},
+		{OpList, fiber.MethodHead, r.Path, r.head},
+		{OpGet, fiber.MethodGet, item, r.get},
+		{OpCreate, fiber.MethodPost, r.Path, r.create},
+		{OpUpdate, fiber.MethodPut, item, r.update},
+		{OpPatch, fiber.MethodPatch, item, r.patch},
+		{OpDelete, fiber.MethodDelete, item, r.delete},
+	}
+	for _, route := range routes {
+		if !r.has(route.op) {
+			continue
+		}
+		handlers := append(slices.Clone(r.Before[route.op]), route.handler)
+		router.Add(route.method, route.path, handlers...)
+	}
+}
+
+func (r *Resource[T]) filters() []ResourceFilter {
+	if r.Filters == nil {
+		return nil
+	}
+	return r.Filters()
+}
+
+func (r *Resource[T]) sorts() []string {
+	if r.Sorts == nil {
+		return nil
+	}
+	return r.Sorts()
+}
+
+func (r *Resource[T]) sortChoices() string {
+	var choices []string
+	for _, key := range r.sorts() {
+		choices = append(choices, key, "-"+key)
+	}
+	return strings.Join(choices, ", ")
+}
+
+// Filters, sort and page from the query string
+func (r *Resource[T]) parseQuery(c *fiber.Ctx) (ResourceQuery, *FieldError) {
+	q := ResourceQuery{Filters: map[string]any{}}
+
+	declared := map[string]bool{}
+	for _, f := range r.filters() {
+		declared[f.Param] = true
+		raw := c.Query(f.Param)
+		if raw == "" {
+			continue
+		}
+		var v any = raw
+		if f.Parse != nil {
+			var ferr *FieldError
+			if v, ferr = f.Parse(f.Param, raw); ferr != nil {
+				return q, ferr
+			}
+		}
+		q.Filters[f.Param] = v
+	}
+
+	params := make([]string, 0, len(c.Queries()))
+	for param := range c.Queries() {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		if strings.HasPrefix(param, "$") && !slices.Contains(odataOptions, param) {
+			return q, &FieldError{Field: param, Code: ErrFieldUnknown}
+		}
+		for _, prefix := range r.Reserved {
+			if strings.HasPrefix(param, prefix) && !declared[param] {
+				return q, &FieldError{Field: param, Code: ErrFieldUnknown}
+			}
+		}
+	}
+
+	if sortBy := c.Query("sort"); sortBy != "" {
+		key := SortKey{Field: strings.TrimPrefix(sortBy, "-"), Desc: strings.HasPrefix(sortBy, "-")}
+		if !slices.Contains(r.sorts(), key.Field) {
+			return q, &FieldError{Field: "sort", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": r.sortChoices()}}
+		}
+		q.Sort = []SortKey{key}
+	}
+
+	q.Limit = c.QueryInt("limit", 0)
+	if q.Limit < 0 || q.Limit > r.MaxLimit {
+		return q, &FieldError{Field: "limit", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 0, "max": r.MaxLimit}}
+	}
+	q.Offset = c.QueryInt("offset", 0)
+	if q.Offset < 0 {
+		return q, &FieldError{Field: "offset", Code: ErrFieldTooSmall, Params: fiber.Map{"min": 0}}
+	}
+	switch c.Query("include_count") {
+	case "", "false":
+	case "true":
+		q.IncludeCount = true
+	default:
+		return q, &FieldError{Field: "include_count", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "true, false"}}
+	}
+	return q, r.parseOData(c, &q)
+}
+
+// OData options, which take precedence over sort, limit and offset
+func (r *Resource[T]) parseOData(c *fiber.Ctx, q *ResourceQuery) *FieldError {
+	fields := map[string]string{}
+	if r.Fields != nil {
+		fields = r.Fields()
+	}
+
+	if raw := c.Query("$filter"); raw != "" {
+		expr, err := parseODataFilter(raw, fields)
+		if err != nil {
+			oerr := err.(*odataError)
+			return &FieldError{Field: "$filter", Code: ErrInvalidFilter, Params: fiber.Map{"pos": oerr.pos, "reason": oerr.reason}}
+		}
+		q.Where = expr
+	}
+	if raw := c.Query("$orderby"); raw != "" {
+		keys, ferr := parseODataOrderBy(raw, r.sorts())
+		if ferr != nil {
+			return ferr
+		}
+		q.Sort = keys
+	}
+	if c.Query("$top") != "" {
+		q.Limit = c.QueryInt("$top", -1)
+		if q.Limit < 1 || q.Limit > r.MaxLimit {
+			return &FieldError{Field: "$top", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 1, "max": r.MaxLimit}}
+		}
+	}
+	if c.Query("$skip") != "" {
+		q.Offset = c.QueryInt("$skip", -1)
+		if q.Offset < 0 {
+			return &FieldError{Field: "$skip", Code: ErrFieldTooSmall, Params: fiber.Map{"min": 0}}
+		}
+	}
+	if raw := c.Query("$select"); raw != "" {
+		for _, path := range strings.Split(raw, ",") {
+			field := odataField(strings.TrimSpace(path))
+			if _, ok := fields[field]; !ok && field != r.IDField {
+				return &FieldError{Field: "$select", Code: ErrFieldUnknown}
+			}
+			q.Select = append(q.Select, field)
+		}
+	}
+	switch c.Query("$count") {
+	case "", "false":
+	case "true":
+		q.Count = true
+	default:
+		return &FieldError{Field: "$count", Code: ErrFieldInvalidChoice, Params: fiber.Map{"choices": "true, false"}}
+	}
+	return nil
+}
+
+// Only the $select fields of an item, plus IDField
+func (r *Resource[T]) project(item T, fields []string) (map[string]any, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	out := map[string]any{}
+	if v, ok := doc[r.IDField]; ok {
+		out[r.IDField] = v
+	}
+	for _, field := range fields {
+		// One level of nesting, e.g. "custom.department"
+		parent, child, nested := strings.Cut(field, ".")
+		v, ok := doc[parent]
+		if !nested {
+			if ok {
+				out[parent] = v
+			}
+			continue
+		}
+		sub, _ := v.(map[string]any)
+		if cv, ok := sub[child]; ok {
+			dst, _ := out[parent].(map[string]any)
+			if dst == nil {
+				dst = map[string]any{}
+				out[parent] = dst
+			}
+			dst[child] = cv
+		}
+	}
+	return out, nil
+}
+
+// 400 for malformed keys, 404 for missing items, 500 otherwise
+func (r *Resource[T]) lookupError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidKey):
+		return apiError(c, 400, r.Errors.InvalidKey)
+	case errors.Is(err, ErrNotFound):
+		return apiError(c, 404, r.Errors.NotFound)
+	}
+	return apiError(c, 500, r.Errors.Fetch)
+}
+
+func (r *Resource[T]) list(c *fiber.Ctx) error {
+	q, ferr := r.parseQuery(c)
+	if ferr != nil {
+		return validationError(c, []*FieldError{ferr})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := r.Store.List(ctx, q)
+	if err != nil {
+		return apiError(c, 500, r.Errors.Fetch)
+	}
+	return r.sendList(c, q, list, func() (ResourceCount, error) { return r.count(ctx, q) })
+}
+
+// Answer a listed page: $select, and the total from count for $count and include_count
+func (r *Resource[T]) sendList(c *fiber.Ctx, q ResourceQuery, list []T, count func() (ResourceCount, error)) error {
+	var body any = list
+	if len(q.Select) > 0 {
+		projected := make([]map[string]any, len(list))
+		for i, item := range list {
+			var err error
+			if projected[i], err = r.project(item, q.Select); err != nil {
+				return apiError(c, 500, r.Errors.Fetch)
+			}
+		}
+		body = projected
+	}
+	if !q.Count && !q.IncludeCount {
+		return c.JSON(body)
+	}
+
+	total, err := count()
+	if err != nil {
+		return apiError(c, 500, r.Errors.Fetch)
+	}
+	setTotalCount(c, total)
+	if !q.Count {
+		return c.JSON(body)
+	}
+	return c.JSON(fiber.Map{"@odata.count": total.N, "value": body})
+}
+
+// HEAD of the list: X-Total-Count without the items
+func (r *Resource[T]) head(c *fiber.Ctx) error {
+	q, ferr := r.parseQuery(c)
+	if ferr != nil {
+		return validationError(c, []*FieldError{ferr})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := r.count(ctx, q)
+	if err != nil {
+		return apiError(c, 500, r.Errors.Fetch)
+	}
+	setTotalCount(c, count)
+	c.Status(200)
+	return nil
+}
+
+// Matches of q, listed and counted when the store cannot count
+func (r *Resource[T]) count(ctx context.Context, q ResourceQuery) (ResourceCount, error) {
+	if counter, ok := r.Store.(ResourceCounter); ok {
+		return counter.Count(ctx, q)
+	}
+	q.Sort, q.Limit, q.Offset = nil, 0, 0
+	matched, err := r.Store.List(ctx, q)
+	return ResourceCount{N: int64(len(matched))}, err
+}
+
+func setTotalCount(c *fiber.Ctx, count ResourceCount) {
+	c.Set("X-Total-Count", strconv.FormatInt(count.N, 10))
+	c.Set("X-Total-Count-Estimated", strconv.FormatBool(count.Estimated))
+}
+
+func (r *Resource[T]) get(c *fiber.Ctx) error {
+	key := c.Params(r.Key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := r.Store.Get(ctx, key)
+	if err == nil {
+		return c.JSON(item)
+	}
+	if errors.Is(err, ErrNotFound) && r.Hooks.NotFound != nil {
+		return r.Hooks.NotFound(c, key)
+	}
+	return r.lookupError(c, err)
+}
+
+// Check a request document against BodySchema; false when a response was sent
+func (r *Resource[T]) checkSchema(c *fiber.Ctx, doc []byte) (bool, error) {
+	if r.BodySchema == "" {
+		return true, nil
+	}
+	lang := requestLanguage(c)
+	details, err := checkSchema(lang, r.BodySchema, doc)
+	if err != nil {
+		return false, apiError(c, 400, ErrInvalidJSON)
+	}
+	if len(details) > 0 {
+		return false, schemaError(c, lang, details)
+	}
+	return true, nil
+}
+
+// Item from a JSON body, schema checked
+func (r *Resource[T]) decode(c *fiber.Ctx) (T, bool, error) {
+	var item T
+	if c.Is("json") {
+		if ok, err := r.checkSchema(c, c.Body()); !ok {
+			return item, false, err
+		}
+	}
+	if err := c.BodyParser(&item); err != nil {
+		return item, false, apiError(c, 400, ErrInvalidJSON)
+	}
+	return item, true, nil
+}
+
+var resourceVerbs = map[string]string{OpCreate: "created", OpUpdate: "updated", OpPatch: "updated", OpDelete: "deleted"}
+
+// Validate, run hooks and persist or preview a write
+func (r *Resource[T]) write(c *fiber.Ctx, w *ResourceWrite[T]) error {
+	failed := map[string]string{OpCreate: r.Errors.Create, OpUpdate: r.Errors.Update, OpPatch: r.Errors.Update, OpDelete: r.Errors.Delete}[w.Op]
+
+	var errs []*FieldError
+	switch {
+	case w.Op == OpDelete:
+	case w.Before != nil && r.ValidateChange != nil:
+		errs = r.ValidateChange(*w.Before, w.Item)
+	case r.Validate != nil:
+		errs = r.Validate(w.Item)
+	}
+	if len(errs) > 0 {
+		return validationError(c, errs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	w.DryRun = isDryRun(c)
+	w.Warnings = []string{}
+	if r.Hooks.BeforeWrite != nil {
+		if err := r.Hooks.BeforeWrite(ctx, w); err != nil {
+			var ferr *FieldError
+			if errors.As(err, &ferr) {
+				return validationError(c, []*FieldError{ferr})
+			}
+			return apiError(c, 500, failed)
+		}
+	}
+
+	status := 200
+	if w.Op == OpCreate {
+		status = 201
+	}
+	if w.DryRun {
+		body := fiber.Map{
+			"message":  fmt.Sprintf("%s would be %s", r.Title, resourceVerbs[w.Op]),
+			r.Name:     *w.Item,
+			"warnings": w.Warnings,
+		}
+		if w.Before != nil && w.Op != OpDelete {
+			body["before"] = *w.Before
+		}
+		return dryRunResult(c, status, body)
+	}
+
+	body := fiber.Map{"message": fmt.Sprintf("%s %s successfully", r.Title, resourceVerbs[w.Op])}
+	var err error
+	switch w.Op {
+	case OpCreate:
+		if err = r.Store.Create(ctx, w.Item); err == nil {
+			body[r.Name] = *w.Item
+			if r.ID != nil {
+				body["id"] = r.ID(*w.Item)
+			}
+		}
+	case OpUpdate, OpPatch:
+		var stored T
+		if stored, err = r.Store.Update(ctx, w.Key, *w.Item); err == nil {
+			*w.Item = stored
+			body[r.Name] = stored
+		}
+	case OpDelete:
+		if _, err = r.Store.Delete(ctx, w.Key); err == nil {
+			body[r.Key] = w.Key
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		return apiError(c, 404, r.Errors.NotFound)
+	}
+	if err != nil {
+		return apiError(c, 500, failed)
+	}
+
+	if r.Hooks.AfterWrite != nil {
+		r.Hooks.AfterWrite(ctx, w)
+	}
+	return c.Status(status).JSON(body)
+}
+
+// Stored item for the path key; false when a response was sent
+func (r *Resource[T]) current(c *fiber.Ctx, key string) (T, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := r.Store.Get(ctx, key)
+	if err != nil {
+		return item, false, r.lookupError(c, err)
+	}
+	return item, true, nil
+}
+
+func (r *Resource[T]) create(c *fiber.Ctx) error {
+	item, ok, err := r.decode(c)
+	if !ok {
+		return err
+	}
+	return r.write(c, &ResourceWrite[T]{Op: OpCreate, Item: &item})
+}
+
+func (r *Resource[T]) update(c *fiber.Ctx) error {
+	item, ok, err := r.decode(c)
+	if !ok {
+		return err
+	}
+	key := c.Params(r.Key)
+	before, ok, err := r.current(c, key)
+	if !ok {
+		return err
+	}
+	if r.Replace != nil {
+		item = r.Replace(before, item)
+	}
+	return r.write(c, &ResourceWrite[T]{Op: OpUpdate, Key: key, Before: &before, Item: &item})
+}
+
+// RFC 7396: objects merge recursively, null removes a member, anything else replaces
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// PATCH with a JSON merge patch applied to the stored item
+func (r *Resource[T]) patch(c *fiber.Ctx) error {
+	var patch map[string]any
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	key := c.Params(r.Key)
+	before, ok, err := r.current(c, key)
+	if !ok {
+		return err
+	}
+
+	// Round trip through JSON so the patch sees the item as clients do
+	data, err := json.Marshal(before)
+	if err != nil {
+		return apiError(c, 500, r.Errors.Update)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return apiError(c, 500, r.Errors.Update)
+	}
+	patched := mergePatch(doc, patch).(map[string]any)
+	if r.IDField != "" {
+		delete(patched, r.IDField)
+	}
+	if data, err = json.Marshal(patched); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	if ok, err := r.checkSchema(c, data); !ok {
+		return err
+	}
+
+	var item T
+	if err := json.Unmarshal(data, &item); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	if r.Replace != nil {
+		item = r.Replace(before, item)
+	}
+	return r.write(c, &ResourceWrite[T]{Op: OpPatch, Key: key, Before: &before, Item: &item})
+}
+
+func (r *Resource[T]) delete(c *fiber.Ctx) error {
+	key := c.Params(r.Key)
+	before, ok, err := r.current(c, key)
+	if !ok {
+		return err
+	}
+	return r.write(c, &ResourceWrite[T]{Op: OpDelete, Key: key, Before: &before, Item: &before})
+}
+
+// Filter and $filter parameters, shared with routes that take the list filters
+func (r *Resource[T]) filterParams() []fiber.Map {
+	params := []fiber.Map{}
+	for _, f := range r.filters() {
+		params = append(params, queryParam(f.Param, f.Description, f.Schema))
+	}
+	if r.Fields != nil {
+		params = append(params, queryParam("$filter", "OData filter: eq, ne, gt, ge, lt, le, and, or, not, startswith(), contains(); e.g. age ge 18 and startswith(name, 'A')", fiber.Map{"type": "string", "maxLength": maxFilterLength}))
+	}
+	return params
+}
+
+// OpenAPI path items of the enabled operations
+func (r *Resource[T]) OpenAPIPaths() fiber.Map {
+	paths := fiber.Map{}
+	itemPath := r.Path + "/{" + r.Key + "}"
+	add := func(op, path, method string, operation fiber.Map) {
+		if !r.has(op) {
+			return
+		}
+		if r.Describe != nil {
+			r.Describe(op, operation)
+		}
+		item, _ := paths[path].(fiber.Map)
+		if item == nil {
+			item = fiber.Map{}
+			paths[path] = item
+		}
+		item[method] = operation
+	}
+
+	key := pathParam(r.Key, r.Title+" "+r.Key)
+	body := fiber.Map{"required": true, "content": jsonContent(schemaRef(r.InputComponent))}
+	patchBody := fiber.Map{"required": true, "content": fiber.Map{
+		"application/merge-patch+json": fiber.Map{"schema": fiber.Map{"type": "object"}},
+		"application/json":             fiber.Map{"schema": fiber.Map{"type": "object"}},
+	}}
+	invalid := jsonResponse("Invalid "+r.Name, schemaRef("ValidationError"))
+	notFound := errorResponse(r.Title + " not found")
+
+	params := r.filterParams()
+	var sortKeys []string
+	for _, key := range r.sorts() {
+		sortKeys = append(sortKeys, key, "-"+key)
+	}
+	if len(sortKeys) > 0 {
+		params = append(params, queryParam("sort", "Sort field", fiber.Map{"type": "string", "enum": sortKeys}))
+	}
+	params = append(params,
+		queryParam("limit", "Page size, 0 for no limit", fiber.Map{"type": "integer", "minimum": 0, "maximum": r.MaxLimit}),
+		queryParam("offset", "Items to skip", fiber.Map{"type": "integer", "minimum": 0}),
+	)
+	if r.Fields != nil {
+		params = append(params,
+			queryParam("$select", "Comma separated properties to return, "+r.IDField+" is always included", fiber.Map{"type": "string"}),
+		)
+	}
+	params = append(params,
+		queryParam("$orderby", "Comma separated sort keys, each optionally followed by asc or desc; overrides sort", fiber.Map{"type": "string"}),
+		queryParam("$top", "Page size; overrides limit", fiber.Map{"type": "integer", "minimum": 1, "maximum": r.MaxLimit}),
+		queryParam("$skip", "Items to skip; overrides offset", fiber.Map{"type": "integer", "minimum": 0}),
+		queryParam("$count", "Answer {\"@odata.count\": total matches, \"value\": page}", fiber.Map{"type": "boolean"}),
+		queryParam("include_count", "Send X-Total-Count", fiber.Map{"type": "boolean"}),
+	)
+	countHeaders := fiber.Map{
+		"X-Total-Count":           fiber.Map{"description": "Total matches, with include_count or $count", "schema": fiber.Map{"type": "integer"}},
+		"X-Total-Count-Estimated": fiber.Map{"description": "Whether X-Total-Count is an estimate", "schema": fiber.Map{"type": "boolean"}},
+	}
+
+	add(OpList, r.Path, "get", fiber.Map{
+		"summary":    "List " + r.Name + "s",
+		"parameters": params,
+		"responses": fiber.Map{
+			"200": jsonResponse(r.Title+"s, wrapped with $count", fiber.Map{"oneOf": []fiber.Map{
+				{"type": "array", "items": schemaRef(r.Component)},
+				{"type": "object", "properties": fiber.Map{
+					"@odata.count": fiber.Map{"type": "integer"},
+					"value":        fiber.Map{"type": "array", "items": schemaRef(r.Component)},
+				}},
+			}}),
+			"400": jsonResponse("Invalid query", schemaRef("ValidationError")),
+		},
+	})
+	if item, ok := paths[r.Path].(fiber.Map); ok {
+		// HEAD takes the list parameters, as adjusted by Describe
+		item["head"] = fiber.Map{
+			"summary":    "Count " + r.Name + "s matching the list parameters",
+			"parameters": item["get"].(fiber.Map)["parameters"],
+			"responses": fiber.Map{
+				"200": fiber.Map{"description": "Counted, no body", "headers": countHeaders},
+				"400": fiber.Map{"description": "Invalid query"},
+			},
+		}
+		item["get"].(fiber.Map)["responses"].(fiber.Map)["200"].(fiber.Map)["headers"] = countHeaders
+	}
+	add(OpGet, itemPath, "get", fiber.Map{
+		"summary":    "Get a " + r.Name + " by " + r.Key,
+		"parameters": []fiber.Map{key},
+		"responses": fiber.Map{
+			"200": jsonResponse(r.Title, schemaRef(r.Component)),
+			"400": errorResponse("Invalid " + r.Key),
+			"404": notFound,
+		},
+	})
+	add(OpCreate, r.Path, "post", fiber.Map{
+		"summary":     "Create a " + r.Name,
+		"parameters":  []fiber.Map{dryRunParam},
+		"requestBody": body,
+		"responses":   fiber.Map{"201": jsonResponse("Created", fiber.Map{"type": "object"}), "400": invalid},
+	})
+	add(OpUpdate, itemPath, "put", fiber.Map{
+		"summary":     "Replace a " + r.Name,
+		"parameters":  []fiber.Map{key, dryRunParam},
+		"requestBody": body,
+		"responses":   fiber.Map{"200": jsonResponse("Updated", fiber.Map{"type": "object"}), "400": invalid, "404": notFound},
+	})
+	add(OpPatch, itemPath, "patch", fiber.Map{
+		"summary":     "Change fields of a " + r.Name + " (JSON merge patch, null removes)",
+		"parameters":  []fiber.Map{key, dryRunParam},
+		"requestBody": patchBody,
+		"responses":   fiber.Map{"200": jsonResponse("Updated", fiber.Map{"type": "object"}), "400": invalid, "404": notFound},
+	})
+	add(OpDelete, itemPath, "delete", fiber.Map{
+		"summary":    "Delete a " + r.Name,
+		"parameters": []fiber.Map{key, dryRunParam},
+		"responses":  fiber.Map{"200": jsonResponse("Deleted", fiber.Map{"type": "object"}), "404": notFound},
+	})
+	return paths
+}
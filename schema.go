@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Request body schemas and the OpenAPI component each one replaces
+var schemaComponents = map[string]string{
+	"user":  "UserInput",    // POST /user, PUT /user/:name, each item of POST /users/import
+	"merge": "MergeRequest", // POST /users/merge
+}
+
+// JSON Schema (2020-12) documents from SCHEMA_DIR, one <name>.json per request body.
+// Checked on top of the built-in validation, so they can only tighten the rules.
+type schemaRegistry struct {
+	mu       sync.RWMutex
+	dir      string
+	compiled map[string]*jsonschema.Schema
+	docs     map[string]any
+	stamp    string
+}
+
+var schemas = &schemaRegistry{}
+
+// Names, sizes and mod times of the schema files, changes when any file does
+func schemaStamp(dir string) (string, []string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(files)
+
+	var sb strings.Builder
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d;", f, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String(), files, nil
+}
+
+// Compile every schema in dir. On error the previously loaded set stays active.
+func (r *schemaRegistry) load(dir string) error {
+	stamp, files, err := schemaStamp(dir)
+	if err != nil {
+		return err
+	}
+
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+
+	docs := map[string]any{}
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		// Registered under the file path so relative $refs between files resolve
+		if err := c.AddResource(abs, doc); err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		docs[strings.TrimSuffix(filepath.Base(f), ".json")] = doc
+	}
+
+	compiled := map[string]*jsonschema.Schema{}
+	for _, f := range files {
+		abs, _ := filepath.Abs(f)
+		sch, err := c.Compile(abs)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		compiled[strings.TrimSuffix(filepath.Base(f), ".json")] = sch
+	}
+
+	r.mu.Lock()
+	r.dir, r.compiled, r.docs, r.stamp = dir, compiled, docs, stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// Reload when the files change; a broken edit is logged and the old schemas kept
+func (r *schemaRegistry) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.mu.RLock()
+		dir, loaded := r.dir, r.stamp
+		r.mu.RUnlock()
+
+		stamp, _, err := schemaStamp(dir)
+		if err != nil || stamp == loaded {
+			continue
+		}
+		if err := r.load(dir); err != nil {
+			fmt.Printf("❌ Schema reload failed, keeping previous schemas: %v\n", err)
+			// Remember the broken state so it is not retried until the next edit
+			r.mu.Lock()
+			r.stamp = stamp
+			r.mu.Unlock()
+			continue
+		}
+		fmt.Printf("🔁 Reloaded request schemas from %s\n", dir)
+	}
+}
+
+func (r *schemaRegistry) get(name string) *jsonschema.Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.compiled[name]
+}
+
+// Raw document for publishing, nil when there is none
+func (r *schemaRegistry) doc(name string) any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.docs[name]
+}
+
+// Load SCHEMA_DIR if set and watch it every SCHEMA_RELOAD_INTERVAL (default 2s, 0 disables)
+func setupSchemas() error {
+	dir := os.Getenv("SCHEMA_DIR")
+	if dir == "" {
+		return nil
+	}
+	if err := schemas.load(dir); err != nil {
+		return err
+	}
+
+	interval := 2 * time.Second
+	if v := os.Getenv("SCHEMA_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid SCHEMA_RELOAD_INTERVAL %q", v)
+		}
+		interval = d
+	}
+	fmt.Printf("📐 Request schemas loaded from %s (%d files)\n", dir, len(schemas.compiled))
+	if interval > 0 {
+		go schemas.watch(interval)
+	}
+	return nil
+}
+
+// Failed assertions as details, pointers are relative to prefix
+func schemaViolations(lang, prefix string, err error) []fiber.Map {
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []fiber.Map{{"pointer": prefix, "code": ErrSchemaViolation, "message": err.Error()}}
+	}
+
+	var details []fiber.Map
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		pointer := prefix + unit.InstanceLocation
+		shown := pointer
+		if shown == "" {
+			shown = "/"
+		}
+		reason := unit.Error.String()
+		details = append(details, fiber.Map{
+			"pointer": pointer,
+			"keyword": unit.KeywordLocation,
+			"code":    ErrSchemaViolation,
+			"message": translate(lang, ErrSchemaViolation, fiber.Map{"pointer": shown, "reason": reason}),
+		})
+	}
+	return details
+}
+
+// Violations of a JSON document against a named schema, none when it passes
+// or no such schema is loaded. Errors only for malformed JSON.
+func checkSchema(lang, name string, data []byte) ([]fiber.Map, error) {
+	sch := schemas.get(name)
+	if sch == nil {
+		return nil, nil
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := sch.Validate(doc); err != nil {
+		return schemaViolations(lang, "", err), nil
+	}
+	return nil, nil
+}
+
+// 400 listing schema violations
+func schemaError(c *fiber.Ctx, lang string, details []fiber.Map) error {
+	return c.Status(400).JSON(fiber.Map{
+		"error":   translate(lang, ErrValidationFailed, nil),
+		"code":    ErrValidationFailed,
+		"details": details,
+	})
+}
+
+// Validate the JSON body against a named schema. With each, the body must be an
+// array and every item is checked. Without a schema loaded it is a no-op.
+func validateBody(name string, each bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sch := schemas.get(name)
+		if sch == nil || !c.Is("json") {
+			return c.Next()
+		}
+
+		lang := requestLanguage(c)
+		if !each {
+			details, err := checkSchema(lang, name, c.Body())
+			if err != nil {
+				return apiError(c, 400, ErrInvalidJSON)
+			}
+			if len(details) > 0 {
+				return schemaError(c, lang, details)
+			}
+			return c.Next()
+		}
+
+		body, err := jsonschema.UnmarshalJSON(bytes.NewReader(c.Body()))
+		if err != nil {
+			return apiError(c, 400, ErrInvalidJSON)
+		}
+		var details []fiber.Map
+		if items, ok := body.([]any); ok {
+			for i, item := range items {
+				if err := sch.Validate(item); err != nil {
+					details = append(details, schemaViolations(lang, fmt.Sprintf("/%d", i), err)...)
+				}
+			}
+		}
+		// Arrays of the wrong shape are left to the handler's own checks
+
+		if len(details) > 0 {
+			return schemaError(c, lang, details)
+		}
+		return c.Next()
+	}
+}
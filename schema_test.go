@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Fresh registry loaded from dir for the test
+func testSchemas(t *testing.T, dir string) *schemaRegistry {
+	t.Helper()
+	prev := schemas
+	schemas = &schemaRegistry{}
+	t.Cleanup(func() { schemas = prev })
+	if err := schemas.load(dir); err != nil {
+		t.Fatal(err)
+	}
+	return schemas
+}
+
+// Pointers of the violations in a 400 answer, nil when the request passed
+func schemaPointers(t *testing.T, app *fiber.App, path, body string) []string {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == 204 {
+		return nil
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	var answer struct {
+		Code    string
+		Details []struct{ Pointer string }
+	}
+	json.Unmarshal(raw, &answer)
+	if resp.StatusCode != 400 || answer.Code != ErrValidationFailed {
+		t.Fatalf("%s: got %d %s", body, resp.StatusCode, raw)
+	}
+	pointers := []string{}
+	for _, d := range answer.Details {
+		pointers = append(pointers, d.Pointer)
+	}
+	return pointers
+}
+
+func TestValidateBody(t *testing.T) {
+	testSchemas(t, "schemas")
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(204) }
+	app.Post("/user", validateBody("user", false), ok)
+	app.Post("/import", validateBody("user", true), ok)
+	app.Post("/unknown", validateBody("nope", false), ok)
+
+	tests := []struct {
+		path, body string
+		want       []string
+	}{
+		{"/user", `{"name":"Ann","age":30}`, nil},
+		{"/user", `{"age":200}`, []string{"", "/age"}},
+		{"/user", `{"name":"Ann","location":{"type":"Point","coordinates":[200,0]}}`, []string{"/location/coordinates/0"}},
+		{"/import", `[{"name":"Ann"},{"name":"Bob","age":-1},{"name":""}]`, []string{"/1/age", "/2/name"}},
+		{"/import", `{"name":"Ann"}`, nil}, // not an array, left to the handler
+		{"/unknown", `{"anything":true}`, nil},
+	}
+	for _, tt := range tests {
+		if got := schemaPointers(t, app, tt.path, tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: got violations at %q, want %q", tt.path, tt.body, got, tt.want)
+		}
+	}
+}
+
+// A broken edit fails the load and leaves the previous schemas active
+func TestSchemaReloadKeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	write := func(doc string) {
+		if err := os.WriteFile(filepath.Join(dir, "user.json"), []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"type":"object","required":["name"]}`)
+	registry := testSchemas(t, dir)
+
+	write(`{"type":"object","required":`)
+	if err := registry.load(dir); err == nil {
+		t.Fatal("broken schema loaded")
+	}
+	details, err := checkSchema("en", "user", []byte(`{}`))
+	if err != nil || len(details) != 1 {
+		t.Errorf("after a failed reload: got %v, %v; want the old schema's violation", details, err)
+	}
+
+	write(`{"type":"object"}`)
+	if err := registry.load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if details, _ := checkSchema("en", "user", []byte(`{}`)); len(details) != 0 {
+		t.Errorf("after reload: got %v, want none", details)
+	}
+}
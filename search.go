@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxSearchLength = 256
+
+// Text index weights: a name match counts ten custom field matches
+const (
+	searchWeightName   = 10
+	searchWeightTags   = 5
+	searchWeightCustom = 1
+)
+
+// Parsed ?q=: words, "quoted phrases" and -negated words or phrases
+type SearchQuery struct {
+	Terms      []string // folded words, any of them matches
+	Phrases    []string // as typed, every one must match
+	NotTerms   []string // folded words, none may match
+	NotPhrases []string // as typed, none may match
+	Limit      int
+	Offset     int
+}
+
+// User matching a search, with its relevance
+type SearchHit struct {
+	User  `bson:",inline"`
+	Score float64 `json:"score" bson:"score"`
+}
+
+// Field of a hit with its matches wrapped in <em>, the rest HTML escaped
+type SearchHighlight struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+// Text field of a user and its index weight
+type searchField struct {
+	Path   string
+	Text   string
+	Weight float64
+}
+
+// Case and diacritic folding, as the MongoDB text index (version 3) does
+var searchFolder = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+func foldSearch(s string) string {
+	folded, _, err := transform.String(searchFolder, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(folded)
+}
+
+// Letters and digits, everything else delimits words
+func isSearchDelimiter(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Folded words of a text
+func searchWords(s string) []string {
+	return strings.FieldsFunc(foldSearch(s), isSearchDelimiter)
+}
+
+// Parse ?q=, which needs at least one word or phrase that is not negated
+func parseSearch(raw string) (SearchQuery, *FieldError) {
+	var q SearchQuery
+	if strings.TrimSpace(raw) == "" {
+		return q, &FieldError{Field: "q", Code: ErrFieldRequired}
+	}
+	if len(raw) > maxSearchLength {
+		return q, &FieldError{Field: "q", Code: ErrFieldTooLong, Params: fiber.Map{"max": maxSearchLength}}
+	}
+
+	rest := raw
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+		negated := strings.HasPrefix(rest, "-") && len(rest) > 1
+		if negated {
+			rest = rest[1:]
+		}
+
+		if phrase, ok := strings.CutPrefix(rest, `"`); ok {
+			// An unterminated phrase runs to the end
+			phrase, rest, _ = strings.Cut(phrase, `"`)
+			phrase = strings.TrimSpace(phrase)
+			switch {
+			case len(searchWords(phrase)) == 0:
+			case negated:
+				q.NotPhrases = append(q.NotPhrases, phrase)
+			default:
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		for _, word := range searchWords(rest[:end]) {
+			if negated {
+				q.NotTerms = append(q.NotTerms, word)
+			} else if !slices.Contains(q.Terms, word) {
+				q.Terms = append(q.Terms, word)
+			}
+		}
+		rest = rest[end:]
+	}
+
+	if len(q.Terms) == 0 && len(q.Phrases) == 0 {
+		return q, &FieldError{Field: "q", Code: ErrSearchNoTerms}
+	}
+	return q, nil
+}
+
+// $text $search string for the query
+func (q SearchQuery) mongo() string {
+	parts := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, strconv.Quote(strings.ReplaceAll(phrase, `"`, "")))
+	}
+	for _, term := range q.NotTerms {
+		parts = append(parts, "-"+term)
+	}
+	for _, phrase := range q.NotPhrases {
+		parts = append(parts, "-"+strconv.Quote(strings.ReplaceAll(phrase, `"`, "")))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Words that count towards the score: the terms and the words of the phrases
+func (q SearchQuery) scored() []string {
+	words := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		for _, word := range searchWords(phrase) {
+			if !slices.Contains(words, word) {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// Indexed text of a user: name, tags and string custom fields
+func searchFields(u User) []searchField {
+	fields := []searchField{{Path: "name", Text: u.Name, Weight: searchWeightName}}
+	for i, tag := range u.Tags {
+		fields = append(fields, searchField{Path: fmt.Sprintf("tags.%d", i), Text: tag, Weight: searchWeightTags})
+	}
+	for _, f := range searchCustomFields() {
+		if s, ok := u.Custom[f].(string); ok {
+			fields = append(fields, searchField{Path: "custom." + f, Text: s, Weight: searchWeightCustom})
+		}
+	}
+	return fields
+}
+
+// Custom fields in the text index
+func searchCustomFields() []string {
+	var names []string
+	for _, f := range customFields.list() {
+		if f.Type == CustomString {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// Whether u matches: every phrase, or any term when there are none, and nothing negated
+func (q SearchQuery) matches(u User) bool {
+	fields := searchFields(u)
+	hasTerm := func(term string) bool {
+		return slices.ContainsFunc(fields, func(f searchField) bool { return slices.Contains(searchWords(f.Text), term) })
+	}
+	// Phrases match anywhere in a field, not only on word boundaries
+	hasPhrase := func(phrase string) bool {
+		return slices.ContainsFunc(fields, func(f searchField) bool { return strings.Contains(foldSearch(f.Text), foldSearch(phrase)) })
+	}
+
+	if slices.ContainsFunc(q.NotTerms, hasTerm) || slices.ContainsFunc(q.NotPhrases, hasPhrase) {
+		return false
+	}
+	if len(q.Phrases) > 0 {
+		for _, phrase := range q.Phrases {
+			if !hasPhrase(phrase) {
+				return false
+			}
+		}
+		return true
+	}
+	return slices.ContainsFunc(q.Terms, hasTerm)
+}
+
+// MongoDB's text score: per field and word, weight × frequency × coefficient,
+// where repeats count half as much as the previous one
+func (q SearchQuery) score(u User) float64 {
+	words := q.scored()
+	var total float64
+	for _, f := range searchFields(u) {
+		tokens := searchWords(f.Text)
+		for _, word := range words {
+			var count, freq, exp float64 = 0, 0, 1
+			for _, token := range tokens {
+				if token == word {
+					count++
+					freq += 1 / exp
+					exp *= 2
+				}
+			}
+			if count > 0 {
+				// Mongo adds 10% to unstemmed matches, and nothing is stemmed here
+				coeff := 0.5*count/float64(len(tokens)) + 0.5
+				total += f.Weight * freq * coeff * 1.1
+			}
+		}
+	}
+	return total
+}
+
+// Search by scanning every user, for backends without a text index
+func searchEach(ctx context.Context, each func(context.Context, func(User) error) error, q SearchQuery) ([]SearchHit, int64, error) {
+	hits := []SearchHit{}
+	err := each(ctx, func(u User) error {
+		if q.matches(u) {
+			hits = append(hits, SearchHit{User: u, Score: q.score(u)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// Each visits in _id order, which breaks ties
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	total := int64(len(hits))
+	hits = hits[min(q.Offset, len(hits)):]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, total, nil
+}
+
+// Fields of u containing scored words, with those words wrapped in <em>
+func (q SearchQuery) highlight(u User) []SearchHighlight {
+	words := q.scored()
+	highlights := []SearchHighlight{}
+	for _, f := range searchFields(u) {
+		var (
+			b       strings.Builder
+			start   = -1
+			matched bool
+		)
+		flush := func(end int) {
+			if start < 0 {
+				return
+			}
+			word := f.Text[start:end]
+			if slices.Contains(words, foldSearch(word)) {
+				b.WriteString("<em>" + html.EscapeString(word) + "</em>")
+				matched = true
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			start = -1
+		}
+		for i, r := range f.Text {
+			if !isSearchDelimiter(r) || unicode.Is(unicode.Mn, r) {
+				if start < 0 {
+					start = i
+				}
+				continue
+			}
+			flush(i)
+			b.WriteString(html.EscapeString(string(r)))
+		}
+		flush(len(f.Text))
+		if matched {
+			highlights = append(highlights, SearchHighlight{Field: f.Path, Text: b.String()})
+		}
+	}
+	return highlights
+}
+
+// Bring the text index in line with the custom fields, in the background as
+// a rebuild takes a while on large collections
+func refreshTextIndex() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := newMongoStore(userCollection).ensureTextIndex(ctx); err != nil {
+			fmt.Printf("⚠️  Text index update failed: %v\n", err)
+			return
+		}
+		fmt.Println("🔎 Text index matches the custom fields")
+	}()
+}
+
+// GET /users/search?q= ranks users by relevance over name, tags and string custom fields
+func searchUsers(c *fiber.Ctx) error {
+	q, ferr := parseSearch(c.Query("q"))
+	var errs []*FieldError
+	if ferr != nil {
+		errs = append(errs, ferr)
+	}
+	q.Limit = c.QueryInt("limit", 20)
+	if q.Limit < 1 || q.Limit > maxListLimit {
+		errs = append(errs, &FieldError{Field: "limit", Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 1, "max": maxListLimit}})
+	}
+	q.Offset = c.QueryInt("offset", 0)
+	if q.Offset < 0 {
+		errs = append(errs, &FieldError{Field: "offset", Code: ErrFieldTooSmall, Params: fiber.Map{"min": 0}})
+	}
+	if len(errs) > 0 {
+		return validationError(c, errs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hits, total, err := users.Search(ctx, q)
+	if err != nil {
+		fmt.Printf("⚠️  Search failed: %v\n", err)
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	results := make([]fiber.Map, len(hits))
+	for i, hit := range hits {
+		score := math.Round(hit.Score*1000) / 1000
+		results[i] = fiber.Map{"user": hit.User, "score": score, "highlights": q.highlight(hit.User)}
+	}
+	return c.JSON(fiber.Map{"total": total, "results": results})
+}
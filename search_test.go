@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want SearchQuery
+		code string
+	}{
+		{"Ann  vip", SearchQuery{Terms: []string{"ann", "vip"}}, ""},
+		{`"Ann Lee" vip`, SearchQuery{Terms: []string{"vip"}, Phrases: []string{"Ann Lee"}}, ""},
+		{"-sales ann", SearchQuery{Terms: []string{"ann"}, NotTerms: []string{"sales"}}, ""},
+		{`ann -"big co"`, SearchQuery{Terms: []string{"ann"}, NotPhrases: []string{"big co"}}, ""},
+		{`"runs to the end`, SearchQuery{Phrases: []string{"runs to the end"}}, ""},
+		{"Zoë ZOE", SearchQuery{Terms: []string{"zoe"}}, ""},
+		{"o'brien", SearchQuery{Terms: []string{"o", "brien"}}, ""},
+		{"a - b", SearchQuery{Terms: []string{"a", "b"}}, ""},
+		{"-ann", SearchQuery{}, ErrSearchNoTerms},
+		{`"" -"ann"`, SearchQuery{}, ErrSearchNoTerms},
+		{"!?", SearchQuery{}, ErrSearchNoTerms},
+		{"   ", SearchQuery{}, ErrFieldRequired},
+		{strings.Repeat("a", maxSearchLength+1), SearchQuery{}, ErrFieldTooLong},
+	}
+	for _, tt := range tests {
+		got, ferr := parseSearch(tt.raw)
+		if tt.code != "" {
+			if ferr == nil || ferr.Field != "q" || ferr.Code != tt.code {
+				t.Errorf("parseSearch(%q): got error %+v, want %s", tt.raw, ferr, tt.code)
+			}
+			continue
+		}
+		if ferr != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearch(%q) = %+v %+v, want %+v", tt.raw, got, ferr, tt.want)
+		}
+	}
+}
+
+func TestSearchMongo(t *testing.T) {
+	q := SearchQuery{Terms: []string{"ann"}, Phrases: []string{`Ann "Lee"`}, NotTerms: []string{"sales"}, NotPhrases: []string{"big co"}}
+	if got, want := q.mongo(), `ann "Ann Lee" -sales -"big co"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	prev := customFields
+	customFields = &customFieldRegistry{fields: map[string]CustomField{
+		"dept":  {Name: "dept", Type: CustomString},
+		"level": {Name: "level", Type: CustomInteger},
+	}}
+	t.Cleanup(func() { customFields = prev })
+
+	ann := User{Name: "Ann Lee", Tags: []string{"vip"}, Custom: map[string]any{"dept": "Big Co Sales", "level": 3}}
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"ann", true},
+		{"nobody vip", true},
+		{"sales", true},
+		{"3", false}, // only string custom fields are indexed
+		{"an", false},
+		{`"n le"`, true}, // phrases match inside words
+		{`"ann lee" "lee ann"`, false},
+		{"ann -vip", false},
+		{`ann -"co sal"`, false},
+		{"ann -eng", true},
+	}
+	for _, tt := range tests {
+		q, ferr := parseSearch(tt.q)
+		if ferr != nil {
+			t.Fatalf("%s: %v", tt.q, ferr.Code)
+		}
+		if got := q.matches(ann); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	prev := customFields
+	customFields = &customFieldRegistry{fields: map[string]CustomField{"dept": {Name: "dept", Type: CustomString}}}
+	t.Cleanup(func() { customFields = prev })
+
+	tests := []struct {
+		q    string
+		user User
+		want float64
+	}{
+		{"ann", User{Name: "Ann"}, 11},
+		{"vip", User{Name: "Bob", Tags: []string{"vip"}}, 5.5},
+		{"sales", User{Name: "Bob", Custom: map[string]any{"dept": "Sales"}}, 1.1},
+		// A repeat counts half: 10 × (1 + 0.5) × (0.5 × 2/3 + 0.5) × 1.1
+		{"ann", User{Name: "Ann Ann Lee"}, 13.75},
+		{`"ann lee" vip`, User{Name: "Ann Lee", Tags: []string{"vip"}}, 2*10*0.75*1.1 + 5.5},
+		{"bob", User{Name: "Ann"}, 0},
+	}
+	for _, tt := range tests {
+		q, _ := parseSearch(tt.q)
+		if got := q.score(tt.user); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s over %+v: got %v, want %v", tt.q, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestSearchEach(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	list := []User{
+		{ID: fixedID(1), Name: "Bob", Tags: []string{"vip"}},
+		{ID: fixedID(2), Name: "Ann"},
+		{ID: fixedID(3), Name: "Cid", Tags: []string{"vip"}},
+		{ID: fixedID(4), Name: "Ann Vip"},
+		{ID: fixedID(5), Name: "Dee"},
+	}
+	if err := store.InsertMany(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q             string
+		limit, offset int
+		names         []string
+		total         int64
+	}{
+		// Name matches first, tag ties in _id order
+		{"ann vip", 0, 0, []string{"Ann Vip", "Ann", "Bob", "Cid"}, 4},
+		{"ann vip", 2, 1, []string{"Ann", "Bob"}, 4},
+		{"ann vip", 0, 10, nil, 4},
+		{"vip -bob", 0, 0, []string{"Ann Vip", "Cid"}, 2},
+		{"nobody", 0, 0, nil, 0},
+	}
+	for _, tt := range tests {
+		q, _ := parseSearch(tt.q)
+		q.Limit, q.Offset = tt.limit, tt.offset
+		hits, total, err := searchEach(ctx, store.Each, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, hit := range hits {
+			names = append(names, hit.Name)
+		}
+		if !reflect.DeepEqual(names, tt.names) || total != tt.total {
+			t.Errorf("%s limit %d offset %d: got %d of %q, want %d of %q", tt.q, tt.limit, tt.offset, total, names, tt.total, tt.names)
+		}
+		if hits == nil {
+			t.Errorf("%s: hits are nil, want an empty list", tt.q)
+		}
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	prev := customFields
+	customFields = &customFieldRegistry{fields: map[string]CustomField{"dept": {Name: "dept", Type: CustomString}}}
+	t.Cleanup(func() { customFields = prev })
+
+	user := User{Name: "Zoë <Ann>", Tags: []string{"eng", "vip"}, Custom: map[string]any{"dept": "R&D"}}
+	q, _ := parseSearch(`zoe "vip" d`)
+	want := []SearchHighlight{
+		{Field: "name", Text: "<em>Zoë</em> &lt;Ann&gt;"},
+		{Field: "tags.1", Text: "<em>vip</em>"},
+		{Field: "custom.dept", Text: "R&amp;<em>D</em>"},
+	}
+	if got := q.highlight(user); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	q, _ = parseSearch("nobody")
+	if got := q.highlight(user); got == nil || len(got) != 0 {
+		t.Errorf("no match: got %#v, want an empty list", got)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	store := testMemoryUsers(t)
+	for _, u := range []User{{Name: "Ann"}, {Name: "Bob", Tags: []string{"ann"}}} {
+		if err := store.Insert(context.Background(), &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/users/search", searchUsers)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users/search?q=ann&limit=1", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Total   int64 `json:"total"`
+		Results []struct {
+			User       User              `json:"user"`
+			Score      float64           `json:"score"`
+			Highlights []SearchHighlight `json:"highlights"`
+		} `json:"results"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != 200 || body.Total != 2 || len(body.Results) != 1 {
+		t.Fatalf("got %d %+v", resp.StatusCode, body)
+	}
+	if r := body.Results[0]; r.User.Name != "Ann" || r.Score != 11 || len(r.Highlights) != 1 {
+		t.Errorf("first result %+v", r)
+	}
+
+	for _, query := range []string{"", "q=-ann", "q=ann&limit=0", "q=ann&offset=-1", "q=" + url.QueryEscape(strings.Repeat("a", maxSearchLength+1))} {
+		req := httptest.NewRequest("GET", "/users/search?"+query, nil)
+		if status, code := testResponse(t, app, req); status != 400 || code != ErrValidationFailed {
+			t.Errorf("%q: got %d %q, want 400 %q", query, status, code, ErrValidationFailed)
+		}
+	}
+}
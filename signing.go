@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-fiber-api/signing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// c.Locals keys: the client ID of a verified signed request, and whether that
+// client has the admin scope
+const (
+	signedClientKey = "signed_client"
+	signedAdminKey  = "signed_admin"
+)
+
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// Verifies HMAC signed requests from the clients in SIGNING_CLIENTS
+type RequestVerifier struct {
+	secrets  map[string][]byte
+	admins   map[string]bool // clients passing ADMIN_TOKEN checks
+	maxSkew  time.Duration
+	required bool
+	nonces   *nonceCache
+}
+
+// Build verifier from env, nil when SIGNING_CLIENTS is not set
+func newRequestVerifierFromEnv() (*RequestVerifier, error) {
+	list := os.Getenv("SIGNING_CLIENTS")
+	if list == "" {
+		return nil, nil
+	}
+
+	secrets := map[string][]byte{}
+	for _, item := range strings.Split(list, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || id == "" || len(secret) < 32 {
+			return nil, fmt.Errorf("SIGNING_CLIENTS entries must be id:secret with secrets of at least 32 characters, got %q", id)
+		}
+		if _, taken := secrets[id]; taken {
+			return nil, fmt.Errorf("SIGNING_CLIENTS lists %q twice", id)
+		}
+		secrets[id] = []byte(secret)
+	}
+
+	// Signing alone grants no admin rights, clients are opted in by name
+	admins := map[string]bool{}
+	for _, id := range strings.Split(os.Getenv("SIGNING_ADMIN_CLIENTS"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if _, known := secrets[id]; !known {
+			return nil, fmt.Errorf("SIGNING_ADMIN_CLIENTS lists %q, which is not in SIGNING_CLIENTS", id)
+		}
+		admins[id] = true
+	}
+
+	skew := 5 * time.Minute
+	if v := os.Getenv("SIGNING_MAX_SKEW"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("SIGNING_MAX_SKEW must be a positive duration, got %q", v)
+		}
+		skew = parsed
+	}
+	required, _ := strconv.ParseBool(os.Getenv("SIGNING_REQUIRED"))
+
+	// A timestamp is accepted for skew either side of now, so is its nonce
+	return &RequestVerifier{secrets: secrets, admins: admins, maxSkew: skew, required: required, nonces: newNonceCache(2 * skew)}, nil
+}
+
+// Middleware checking signed requests; unsigned ones pass unless SIGNING_REQUIRED
+func (v *RequestVerifier) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get(signing.HeaderSignature) == "" {
+			if v.required {
+				return apiError(c, 401, ErrSignatureRequired)
+			}
+			return c.Next()
+		}
+
+		clientID := c.Get(signing.HeaderClientID)
+		secret, known := v.secrets[clientID]
+		timestamp, err := strconv.ParseInt(c.Get(signing.HeaderTimestamp), 10, 64)
+		nonce := c.Get(signing.HeaderNonce)
+		if !known || err != nil || !noncePattern.MatchString(nonce) {
+			return apiError(c, 401, ErrSignatureInvalid)
+		}
+
+		expected := signing.Signature(secret, signing.StringToSign(c.Method(), string(c.Request().RequestURI()), timestamp, nonce, signing.BodyHash(c.Body())))
+		if !hmac.Equal([]byte(c.Get(signing.HeaderSignature)), []byte(expected)) {
+			return apiError(c, 401, ErrSignatureInvalid)
+		}
+
+		// Checked after the signature so forged requests cannot use up nonces
+		now := time.Now()
+		if skew := now.Sub(time.Unix(timestamp, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+			return apiError(c, 401, ErrSignatureExpired, fiber.Map{"max_skew_s": int(v.maxSkew.Seconds())})
+		}
+		if !v.nonces.add(clientID+":"+nonce, now) {
+			return apiError(c, 401, ErrSignatureReplayed)
+		}
+
+		c.Locals(signedClientKey, clientID)
+		c.Locals(signedAdminKey, v.admins[clientID])
+		c.Locals(auditActorKey, "client:"+clientID)
+		return c.Next()
+	}
+}
+
+// Whether the request was signed by a client in SIGNING_ADMIN_CLIENTS
+func signedAdmin(c *fiber.Ctx) bool {
+	admin, _ := c.Locals(signedAdminKey).(bool)
+	return admin
+}
+
+// Nonces seen in the last ttl, in process memory: each instance keeps its own
+type nonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time // expiry by nonce
+	nextSweep time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: map[string]time.Time{}}
+}
+
+// Record a nonce, false when it was already seen
+func (n *nonceCache) add(nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if now.After(n.nextSweep) {
+		for key, expires := range n.seen {
+			if now.After(expires) {
+				delete(n.seen, key)
+			}
+		}
+		n.nextSweep = now.Add(n.ttl)
+	}
+
+	if expires, ok := n.seen[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	n.seen[nonce] = now.Add(n.ttl)
+	return true
+}
@@ -0,0 +1,109 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestStringToSign(t *testing.T) {
+	tests := []struct {
+		method, uri string
+		timestamp   int64
+		nonce, body string
+		want        string
+	}{
+		{"get", "/users", 1760515200, "n1", "", "GET\n/users\n1760515200\nn1\n" + emptyHash},
+		{"POST", "/users?dry_run=true", 1, "n2", "{}", "POST\n/users?dry_run=true\n1\nn2\n" + BodyHash([]byte("{}"))},
+		// The raw query is signed as sent, encoding included
+		{"GET", "/users?name=A%20B&x=1", 1, "n3", "", "GET\n/users?name=A%20B&x=1\n1\nn3\n" + emptyHash},
+	}
+	for _, tt := range tests {
+		got := StringToSign(tt.method, tt.uri, tt.timestamp, tt.nonce, BodyHash([]byte(tt.body)))
+		if got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.uri, got, tt.want)
+		}
+	}
+}
+
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestBodyHashEmpty(t *testing.T) {
+	if got := BodyHash(nil); got != emptyHash {
+		t.Errorf("got %s, want %s", got, emptyHash)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write([]byte("GET\n/users"))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if got := Signature(testSecret, "GET\n/users"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if Signature([]byte("another secret, 32 characters ok"), "GET\n/users") == want {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestNewNonce(t *testing.T) {
+	a, b := NewNonce(), NewNonce()
+	if len(a) != 32 || a == b {
+		t.Errorf("got %q and %q, want two different 32 character nonces", a, b)
+	}
+}
+
+func TestSign(t *testing.T) {
+	req := httptest.NewRequest("PUT", "http://api.test/users/1?dry_run=true", strings.NewReader(`{"age":3}`))
+	if err := Sign(req, "billing", testSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"age":3}` {
+		t.Errorf("body not put back: %q", body)
+	}
+	if got := req.Header.Get(HeaderClientID); got != "billing" {
+		t.Errorf("client ID: got %q", got)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp: %v", err)
+	}
+	want := Signature(testSecret, StringToSign("PUT", "/users/1?dry_run=true", timestamp, req.Header.Get(HeaderNonce), BodyHash(body)))
+	if got := req.Header.Get(HeaderSignature); got != want {
+		t.Errorf("signature: got %s, want %s", got, want)
+	}
+}
+
+func TestTransportLeavesRequestAlone(t *testing.T) {
+	var signed http.Header
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		signed = req.Header
+		return &http.Response{StatusCode: 204, Body: http.NoBody, Request: req}, nil
+	})
+	client := &http.Client{Transport: &Transport{ClientID: "billing", Secret: testSecret, Base: base}}
+
+	req, _ := http.NewRequest("GET", "http://api.test/users", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if signed.Get(HeaderSignature) == "" {
+		t.Error("request sent unsigned")
+	}
+	if req.Header.Get(HeaderSignature) != "" {
+		t.Error("caller's request was changed")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-fiber-api/signing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	testBillingSecret = []byte("billing-secret-of-32-characters!")
+	testOpsSecret     = []byte("ops-secret-of-at-least-32-chars!")
+)
+
+// Verifier for billing and ops, ops having the admin scope
+func testVerifier(required bool) *RequestVerifier {
+	return &RequestVerifier{
+		secrets:  map[string][]byte{"billing": testBillingSecret, "ops": testOpsSecret},
+		admins:   map[string]bool{"ops": true},
+		maxSkew:  time.Minute,
+		required: required,
+		nonces:   newNonceCache(2 * time.Minute),
+	}
+}
+
+func testVerifierApp(required bool) *fiber.App {
+	app := fiber.New()
+	app.Use(testVerifier(required).Middleware())
+	app.All("/echo", func(c *fiber.Ctx) error {
+		client, _ := c.Locals(signedClientKey).(string)
+		return c.SendString(client)
+	})
+	app.Put("/admin", adminAuth("admin-token"), func(c *fiber.Ctx) error {
+		return c.SendStatus(204)
+	})
+	return app
+}
+
+// Request signed by hand so each part can be tampered with
+type testSigned struct {
+	method, target, body string
+	client               string
+	secret               []byte
+	timestamp            int64
+	nonce                string
+	signature            string // computed when empty
+}
+
+func (s testSigned) request() *http.Request {
+	req := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+	if s.signature == "" {
+		s.signature = signing.Signature(s.secret, signing.StringToSign(s.method, req.URL.RequestURI(), s.timestamp, s.nonce, signing.BodyHash([]byte(s.body))))
+	}
+	req.Header.Set(signing.HeaderClientID, s.client)
+	req.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(s.timestamp, 10))
+	req.Header.Set(signing.HeaderNonce, s.nonce)
+	req.Header.Set(signing.HeaderSignature, s.signature)
+	return req
+}
+
+// Status and code, or body when the request went through
+func testResponse(t *testing.T, app *fiber.App, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var apiErr struct{ Code string }
+	if resp.StatusCode >= 400 && json.Unmarshal(body, &apiErr) == nil {
+		return resp.StatusCode, apiErr.Code
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestRequestVerifier(t *testing.T) {
+	now := time.Now().Unix()
+	valid := testSigned{method: "POST", target: "/echo?x=1", body: `{"a":1}`, client: "billing", secret: testBillingSecret, timestamp: now, nonce: "0123456789abcdef"}
+
+	tests := []struct {
+		name   string
+		edit   func(s *testSigned)
+		req    func(s testSigned) *http.Request
+		status int
+		want   string
+	}{
+		{"valid", func(s *testSigned) {}, nil, 200, "billing"},
+		{"unknown client", func(s *testSigned) { s.client = "nobody" }, nil, 401, ErrSignatureInvalid},
+		{"wrong secret", func(s *testSigned) { s.secret = testOpsSecret }, nil, 401, ErrSignatureInvalid},
+		{"bad timestamp", nil, func(s testSigned) *http.Request {
+			req := s.request()
+			req.Header.Set(signing.HeaderTimestamp, "soon")
+			return req
+		}, 401, ErrSignatureInvalid},
+		{"short nonce", func(s *testSigned) { s.nonce = "abc" }, nil, 401, ErrSignatureInvalid},
+		{"tampered body", nil, func(s testSigned) *http.Request {
+			signed := s.request()
+			req := httptest.NewRequest(s.method, s.target, strings.NewReader(`{"a":2}`))
+			req.Header = signed.Header
+			return req
+		}, 401, ErrSignatureInvalid},
+		{"tampered query", nil, func(s testSigned) *http.Request {
+			signed := s.request()
+			req := httptest.NewRequest(s.method, "/echo?x=2", strings.NewReader(s.body))
+			req.Header = signed.Header
+			return req
+		}, 401, ErrSignatureInvalid},
+		{"tampered method", nil, func(s testSigned) *http.Request {
+			signed := s.request()
+			req := httptest.NewRequest("PUT", s.target, strings.NewReader(s.body))
+			req.Header = signed.Header
+			return req
+		}, 401, ErrSignatureInvalid},
+		{"too old", func(s *testSigned) { s.timestamp = now - 120 }, nil, 401, ErrSignatureExpired},
+		{"too far ahead", func(s *testSigned) { s.timestamp = now + 120 }, nil, 401, ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			var req *http.Request
+			if tt.edit != nil {
+				tt.edit(&s)
+				req = s.request()
+			} else {
+				req = tt.req(s)
+			}
+			status, got := testResponse(t, testVerifierApp(false), req)
+			if status != tt.status || got != tt.want {
+				t.Errorf("got %d %q, want %d %q", status, got, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestVerifierReplay(t *testing.T) {
+	app := testVerifierApp(false)
+	s := testSigned{method: "GET", target: "/echo", client: "billing", secret: testBillingSecret, timestamp: time.Now().Unix(), nonce: "0123456789abcdef"}
+	if status, _ := testResponse(t, app, s.request()); status != 200 {
+		t.Fatalf("first request: got %d", status)
+	}
+	if status, code := testResponse(t, app, s.request()); status != 401 || code != ErrSignatureReplayed {
+		t.Errorf("replay: got %d %q", status, code)
+	}
+
+	// Nonces are per client
+	s.client, s.secret = "ops", testOpsSecret
+	if status, _ := testResponse(t, app, s.request()); status != 200 {
+		t.Errorf("same nonce, other client: got %d", status)
+	}
+
+	// A forged request does not use up the nonce
+	forged := testSigned{method: "GET", target: "/echo", client: "billing", secret: testOpsSecret, timestamp: time.Now().Unix(), nonce: "fedcba9876543210"}
+	testResponse(t, app, forged.request())
+	forged.secret = testBillingSecret
+	if status, _ := testResponse(t, app, forged.request()); status != 200 {
+		t.Errorf("nonce of a forged request: got %d", status)
+	}
+}
+
+func TestRequestVerifierUnsigned(t *testing.T) {
+	for _, tt := range []struct {
+		required bool
+		status   int
+		want     string
+	}{
+		{false, 200, ""},
+		{true, 401, ErrSignatureRequired},
+	} {
+		status, got := testResponse(t, testVerifierApp(tt.required), httptest.NewRequest("GET", "/echo", nil))
+		if status != tt.status || got != tt.want {
+			t.Errorf("required=%v: got %d %q, want %d %q", tt.required, status, got, tt.status, tt.want)
+		}
+	}
+}
+
+// Only clients in SIGNING_ADMIN_CLIENTS stand in for ADMIN_TOKEN
+func TestSignedAdmin(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"unsigned", func() *http.Request { return httptest.NewRequest("PUT", "/admin", nil) }, 401},
+		{"bearer token", func() *http.Request {
+			req := httptest.NewRequest("PUT", "/admin", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer admin-token")
+			return req
+		}, 204},
+		{"signed by a plain client", func() *http.Request {
+			return testSigned{method: "PUT", target: "/admin", client: "billing", secret: testBillingSecret, timestamp: now, nonce: "0123456789abcdef"}.request()
+		}, 401},
+		{"signed by an admin client", func() *http.Request {
+			return testSigned{method: "PUT", target: "/admin", client: "ops", secret: testOpsSecret, timestamp: now, nonce: "0123456789abcdef"}.request()
+		}, 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := testResponse(t, testVerifierApp(false), tt.req()); status != tt.status {
+				t.Errorf("got %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestNewRequestVerifierFromEnv(t *testing.T) {
+	secret := string(testBillingSecret)
+	tests := []struct {
+		name    string
+		clients string
+		admins  string
+		skew    string
+		ok      bool
+	}{
+		{"unset", "", "", "", true},
+		{"one client", "billing:" + secret, "", "", true},
+		{"admin client", "billing:" + secret + ", ops:" + secret, "ops", "30s", true},
+		{"short secret", "billing:short", "", "", false},
+		{"missing secret", "billing", "", "", false},
+		{"listed twice", "billing:" + secret + ",billing:" + secret, "", "", false},
+		{"unknown admin", "billing:" + secret, "ops", "", false},
+		{"bad skew", "billing:" + secret, "", "-1m", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SIGNING_CLIENTS", tt.clients)
+			t.Setenv("SIGNING_ADMIN_CLIENTS", tt.admins)
+			t.Setenv("SIGNING_MAX_SKEW", tt.skew)
+			v, err := newRequestVerifierFromEnv()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.admins != "" && tt.ok && !v.admins[tt.admins] {
+				t.Errorf("%s is not an admin client", tt.admins)
+			}
+		})
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	start := time.Unix(1760515200, 0)
+	n := newNonceCache(time.Minute)
+	steps := []struct {
+		nonce string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, true},
+		{"a", 30 * time.Second, false},
+		{"b", 30 * time.Second, true},
+		{"a", 61 * time.Second, true}, // expired, swept
+		{"b", 61 * time.Second, false},
+	}
+	for _, s := range steps {
+		if got := n.add(s.nonce, start.Add(s.after)); got != s.want {
+			t.Errorf("%s at +%s: got %v, want %v", s.nonce, s.after, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// Returned by stores when no user matches
+	ErrNotFound = errors.New("user not found")
+	// Returned by inserts when the ID is taken
+	ErrDuplicateID = errors.New("duplicate user id")
+)
+
+// User data operations, implemented once per storage backend
+type UserStore interface {
+	List(ctx context.Context, q ListQuery) ([]User, error)
+	// CountList counts the users matching q, ignoring its sort and page
+	CountList(ctx context.Context, q ListQuery) (int64, error)
+	Count(ctx context.Context) (int64, error)
+	// EstimatedCount answers from metadata where the backend keeps it, which may lag
+	EstimatedCount(ctx context.Context) (int64, error)
+	// Each visits every user in _id order
+	Each(ctx context.Context, fn func(User) error) error
+	Get(ctx context.Context, id primitive.ObjectID) (User, error)
+	FindByName(ctx context.Context, name string) (User, error)
+	CountByName(ctx context.Context, name string) (int64, error)
+	// Insert assigns an ID when the user has none
+	Insert(ctx context.Context, user *User) error
+	InsertMany(ctx context.Context, users []User) error
+	// UpdateByName sets name and age of the oldest user with that name, nothing else
+	UpdateByName(ctx context.Context, name string, update User) (User, error)
+	// Upsert replaces the user with the same ID or inserts it
+	Upsert(ctx context.Context, user User) error
+	DeleteByName(ctx context.Context, name string) (User, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// AddTags appends the tags the user lacks, failing with ErrTooManyTags past maxTags
+	AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error)
+	RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error)
+	// Facets counts tags over the users matching q, ignoring its sort and page
+	Facets(ctx context.Context, q ListQuery) (UserFacets, error)
+	// Search returns a page of matches, most relevant first, and the number of matches
+	Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error)
+}
+
+// Active store used by the handlers
+var users UserStore
+
+const maxListLimit = 1000
+
+// Filters, sort and page for listing users. Every backend must return the same
+// users in the same order: ties are always broken by _id.
+type ListQuery struct {
+	Name       string // exact match
+	NamePrefix string // case sensitive
+	MinAge     *int
+	MaxAge     *int
+	Custom     map[string]any // exact match on custom fields, values typed by the registry
+	Where      ODataExpr      // $filter, ANDed with the fields above
+	Near       *GeoCircle     // ordered by distance, then _id, unless Sort is set
+	Box        *GeoBox
+	TagsAll    []string  // users with every one of these tags
+	TagsAny    []string  // users with at least one
+	Sort       []SortKey // then _id
+	Limit      int       // 0 means no limit
+	Offset     int
+}
+
+// One $orderby / sort key
+type SortKey struct {
+	Field string // "name", "age" or "custom.<field>"
+	Type  string // custom field type when sorting by one
+	Desc  bool
+}
+
+// Value of a $filter property, nil when missing
+func userField(u User, field string) any {
+	switch field {
+	case "name":
+		return u.Name
+	case "age":
+		return int64(u.Age)
+	}
+	if name, ok := strings.CutPrefix(field, "custom."); ok {
+		return u.Custom[name]
+	}
+	return nil
+}
+
+// Filter check for backends that scan in Go
+func (q ListQuery) matches(u User) bool {
+	if q.Name != "" && u.Name != q.Name {
+		return false
+	}
+	if q.NamePrefix != "" && !strings.HasPrefix(u.Name, q.NamePrefix) {
+		return false
+	}
+	if q.MinAge != nil && u.Age < *q.MinAge {
+		return false
+	}
+	if q.MaxAge != nil && u.Age > *q.MaxAge {
+		return false
+	}
+	for name, v := range q.Custom {
+		if !customEqual(u.Custom[name], v) {
+			return false
+		}
+	}
+	for _, tag := range q.TagsAll {
+		if !slices.Contains(u.Tags, tag) {
+			return false
+		}
+	}
+	if len(q.TagsAny) > 0 && !slices.ContainsFunc(q.TagsAny, func(tag string) bool { return slices.Contains(u.Tags, tag) }) {
+		return false
+	}
+	if q.Near != nil && !q.Near.contains(u.Location) {
+		return false
+	}
+	if q.Box != nil && !q.Box.contains(u.Location) {
+		return false
+	}
+	if q.Where != nil && !q.Where.eval(func(field string) any { return userField(u, field) }) {
+		return false
+	}
+	return true
+}
+
+// Whether any filter is set
+func (q ListQuery) filtered() bool {
+	return q.Name != "" || q.NamePrefix != "" || q.MinAge != nil || q.MaxAge != nil || len(q.Custom) > 0 ||
+		q.Where != nil || q.Near != nil || q.Box != nil || len(q.TagsAll) > 0 || len(q.TagsAny) > 0
+}
+
+// Same filters without sort or page, for counting
+func (q ListQuery) unpaged() ListQuery {
+	q.Sort, q.Limit, q.Offset = nil, 0, 0
+	return q
+}
+
+// Sort and paginate filtered users in Go, mirroring the Mongo query
+func (q ListQuery) apply(list []User) []User {
+	var distance map[primitive.ObjectID]float64
+	if q.Near != nil && len(q.Sort) == 0 {
+		distance = make(map[primitive.ObjectID]float64, len(list))
+		for _, u := range list {
+			distance[u.ID] = geoDistance(q.Near.Center, u.Location)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if da, db := distance[a.ID], distance[b.ID]; da != db {
+			return da < db
+		}
+		for _, key := range q.Sort {
+			var cmp int
+			switch key.Field {
+			case "name":
+				cmp = strings.Compare(a.Name, b.Name)
+			case "age":
+				cmp = a.Age - b.Age
+			default:
+				if name, ok := strings.CutPrefix(key.Field, "custom."); ok {
+					cmp = compareCustom(key.Type, a.Custom[name], b.Custom[name])
+				}
+			}
+			if key.Desc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+
+	if q.Offset >= len(list) {
+		return []User{}
+	}
+	list = list[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(list) {
+		list = list[:q.Limit]
+	}
+	return list
+}
+
+// Sort by ID, i.e. by creation time
+func sortUsersByID(list []User) {
+	sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i].ID[:], list[j].ID[:]) < 0 })
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	boltUsersBucket  = []byte("users")
+	boltByNameBucket = []byte("users_by_name") // name + 0x00 + id -> nil
+)
+
+// UserStore in an embedded bbolt file, for nodes that cannot reach Atlas.
+// Every write is an fsynced bbolt transaction.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltUsersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltByNameBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func nameKey(name string, id primitive.ObjectID) []byte {
+	key := make([]byte, 0, len(name)+1+len(id))
+	key = append(key, name...)
+	key = append(key, 0)
+	return append(key, id[:]...)
+}
+
+func decodeBoltUser(v []byte) (User, error) {
+	var user User
+	err := bson.Unmarshal(v, &user)
+	return user, err
+}
+
+func boltGet(tx *bolt.Tx, id primitive.ObjectID) (User, error) {
+	v := tx.Bucket(boltUsersBucket).Get(id[:])
+	if v == nil {
+		return User{}, ErrNotFound
+	}
+	return decodeBoltUser(v)
+}
+
+// Write user and its name index entry, replacing the previous version
+func boltPut(tx *bolt.Tx, user User) error {
+	users, byName := tx.Bucket(boltUsersBucket), tx.Bucket(boltByNameBucket)
+	if old, err := boltGet(tx, user.ID); err == nil {
+		if err := byName.Delete(nameKey(old.Name, old.ID)); err != nil {
+			return err
+		}
+	}
+	doc, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if err := users.Put(user.ID[:], doc); err != nil {
+		return err
+	}
+	return byName.Put(nameKey(user.Name, user.ID), nil)
+}
+
+// Put that refuses to overwrite, like a Mongo insert on an existing _id
+func boltInsert(tx *bolt.Tx, user User) error {
+	if tx.Bucket(boltUsersBucket).Get(user.ID[:]) != nil {
+		return ErrDuplicateID
+	}
+	return boltPut(tx, user)
+}
+
+func boltDelete(tx *bolt.Tx, user User) error {
+	if err := tx.Bucket(boltByNameBucket).Delete(nameKey(user.Name, user.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltUsersBucket).Delete(user.ID[:])
+}
+
+// Visit users whose name is name, or starts with it, in name then ID order
+func boltScanName(tx *bolt.Tx, name string, exact bool, fn func(User) (bool, error)) error {
+	prefix := []byte(name)
+	if exact {
+		prefix = append(prefix, 0)
+	}
+	c := tx.Bucket(boltByNameBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if exact && !exactNameKey(k, name) {
+			continue
+		}
+		var id primitive.ObjectID
+		copy(id[:], k[len(k)-len(id):])
+		user, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		more, err := fn(user)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether an index key is one of name's. Names may hold 0x00 themselves, so
+// name + 0x00 also prefixes the keys of longer names.
+func exactNameKey(k []byte, name string) bool {
+	return len(k) == len(name)+1+len(primitive.ObjectID{})
+}
+
+// First user with that name, i.e. the oldest one
+func boltFindByName(tx *bolt.Tx, name string) (User, error) {
+	found, result := false, User{}
+	err := boltScanName(tx, name, true, func(u User) (bool, error) {
+		found, result = true, u
+		return false, nil
+	})
+	if err == nil && !found {
+		err = ErrNotFound
+	}
+	return result, err
+}
+
+func (s *boltStore) List(ctx context.Context, q ListQuery) ([]User, error) {
+	list := []User{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		collect := func(u User) (bool, error) {
+			if q.matches(u) {
+				list = append(list, u)
+			}
+			return true, nil
+		}
+
+		// Name filters use the index, everything else is a scan
+		switch {
+		case q.Name != "":
+			return boltScanName(tx, q.Name, true, collect)
+		case q.NamePrefix != "":
+			return boltScanName(tx, q.NamePrefix, false, collect)
+		}
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, v []byte) error {
+			user, err := decodeBoltUser(v)
+			if err != nil {
+				return err
+			}
+			_, err = collect(user)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q.apply(list), nil
+}
+
+func (s *boltStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	list, err := s.List(ctx, q.unpaged())
+	return int64(len(list)), err
+}
+
+func (s *boltStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = int64(tx.Bucket(boltUsersBucket).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
+
+func (s *boltStore) EstimatedCount(ctx context.Context) (int64, error) {
+	return s.Count(ctx)
+}
+
+func (s *boltStore) Each(ctx context.Context, fn func(User) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		// Keys are ObjectIDs, so bucket order is _id order
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			user, err := decodeBoltUser(v)
+			if err != nil {
+				return err
+			}
+			return fn(user)
+		})
+	})
+}
+
+func (s *boltStore) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var user User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = boltGet(tx, id)
+		return err
+	})
+	return user, err
+}
+
+func (s *boltStore) FindByName(ctx context.Context, name string) (User, error) {
+	var user User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = boltFindByName(tx, name)
+		return err
+	})
+	return user, err
+}
+
+func (s *boltStore) CountByName(ctx context.Context, name string) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltByNameBucket).Cursor()
+		prefix := append([]byte(name), 0)
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if exactNameKey(k, name) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *boltStore) Insert(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltInsert(tx, *user)
+	})
+}
+
+// All or nothing, like an ordered Mongo InsertMany that fails on the first error
+func (s *boltStore) InsertMany(ctx context.Context, list []User) error {
+	for i := range list {
+		if list[i].ID.IsZero() {
+			list[i].ID = primitive.NewObjectID()
+		}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, user := range list {
+			if err := boltInsert(tx, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	var user User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if user, err = boltFindByName(tx, name); err != nil {
+			return err
+		}
+		user.Name, user.Age = update.Name, update.Age
+		return boltPut(tx, user)
+	})
+	return user, err
+}
+
+func (s *boltStore) Upsert(ctx context.Context, user User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, user)
+	})
+}
+
+func (s *boltStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	var user User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if user, err = boltFindByName(tx, name); err != nil {
+			return err
+		}
+		return boltDelete(tx, user)
+	})
+	return user, err
+}
+
+func (s *boltStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		user, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		return boltDelete(tx, user)
+	})
+}
+
+func (s *boltStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	var user User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if user, err = boltGet(tx, id); err != nil {
+			return err
+		}
+		if user.Tags, err = withTags(user.Tags, tags); err != nil {
+			return err
+		}
+		return boltPut(tx, user)
+	})
+	return user, err
+}
+
+func (s *boltStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	var user User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if user, err = boltGet(tx, id); err != nil {
+			return err
+		}
+		user.Tags = withoutTags(user.Tags, tags)
+		return boltPut(tx, user)
+	})
+	return user, err
+}
+
+func (s *boltStore) Facets(ctx context.Context, q ListQuery) (UserFacets, error) {
+	list, err := s.List(ctx, q.unpaged())
+	return countFacets(list), err
+}
+
+func (s *boltStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	return searchEach(ctx, s.Each, q)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// Names may contain 0x00, the index separator: "a" must not match "a\x00b"
+func TestBoltNamesWithSeparator(t *testing.T) {
+	store, err := openBoltStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	longer := User{ID: fixedID(1), Name: "a\x00b"}
+	exact := User{ID: fixedID(2), Name: "a"}
+	for _, u := range []*User{&longer, &exact} {
+		if err := store.Insert(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.FindByName(ctx, "a")
+	if err := expectUser("find by name", got, err, exact); err != nil {
+		t.Error(err)
+	}
+	n, err := store.CountByName(ctx, "a")
+	if err := expectCount("count by name", n, err, 1); err != nil {
+		t.Error(err)
+	}
+	list, err := store.List(ctx, ListQuery{Name: "a"})
+	if err != nil || len(list) != 1 || list[0].ID != exact.ID {
+		t.Errorf("list by name: got %v, %v", list, err)
+	}
+	if list, _ := store.List(ctx, ListQuery{NamePrefix: "a"}); len(list) != 2 {
+		t.Errorf("list by prefix: got %d users, want 2", len(list))
+	}
+
+	deleted, err := store.DeleteByName(ctx, "a")
+	if err := expectUser("delete by name", deleted, err, exact); err != nil {
+		t.Error(err)
+	}
+	_, err = store.FindByName(ctx, "a")
+	if err := expectErr("find after delete", err, ErrNotFound); err != nil {
+		t.Error(err)
+	}
+}
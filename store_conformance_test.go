@@ -0,0 +1,713 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Run the UserStore contract against every backend in a scratch database,
+// so every backend answers the API the same way. MongoDB and PostgreSQL are
+// skipped unless MONGO_URI and DATABASE_URL are set.
+func TestStoreConformance(t *testing.T) {
+	backends := []struct {
+		name, env string
+	}{{"memory", ""}, {"bolt", ""}, {"mongo", "MONGO_URI"}, {"postgres", "DATABASE_URL"}}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			if b.env != "" && os.Getenv(b.env) == "" {
+				t.Skipf("%s not set", b.env)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			store, cleanup, err := scratchStore(ctx, b.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+
+			for _, tc := range conformanceCases {
+				t.Run(tc.name, func(t *testing.T) {
+					if err := resetStore(ctx, store); err != nil {
+						t.Fatalf("reset: %v", err)
+					}
+					if err := tc.run(ctx, store); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// Empty store of the given kind that never touches real data
+func scratchStore(ctx context.Context, backend string) (UserStore, func(), error) {
+	suffix := primitive.NewObjectID().Hex()
+
+	switch backend {
+	case "memory":
+		return newMemoryStore(), func() {}, nil
+
+	case "bolt":
+		dir, err := os.MkdirTemp("", "conformance")
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := openBoltStore(filepath.Join(dir, "users.db"))
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		return store, func() {
+			store.Close()
+			os.RemoveAll(dir)
+		}, nil
+
+	case "mongo":
+		uri := os.Getenv("MONGO_URI")
+		if uri == "" {
+			return nil, nil, errors.New("MONGO_URI missing")
+		}
+		scratchClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			return nil, nil, err
+		}
+		db := scratchClient.Database("conformance_" + suffix)
+		store := newMongoStore(db.Collection("users"))
+		if err := store.ensureIndexes(ctx); err == nil {
+			err = store.ensureTextIndex(ctx)
+		}
+		if err != nil {
+			scratchClient.Disconnect(ctx)
+			return nil, nil, err
+		}
+		return store, func() {
+			db.Drop(context.Background())
+			scratchClient.Disconnect(context.Background())
+		}, nil
+
+	case "postgres":
+		cfg, err := postgresConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		// Own schema, so migrations and data stay out of the real one
+		schema := "conformance_" + suffix
+		admin, err := pgx.ConnectConfig(ctx, cfg.ConnConfig.Copy())
+		if err != nil {
+			return nil, nil, err
+		}
+		drop := func() {
+			admin.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+schema+" CASCADE")
+			admin.Close(context.Background())
+		}
+		if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+			admin.Close(ctx)
+			return nil, nil, err
+		}
+		cfg.ConnConfig.RuntimeParams["search_path"] = schema
+		store, err := openPostgresStore(ctx, cfg)
+		if err != nil {
+			drop()
+			return nil, nil, err
+		}
+		return store, func() {
+			store.Close()
+			drop()
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown backend %q, expected mongo, bolt, postgres or memory", backend)
+}
+
+func resetStore(ctx context.Context, store UserStore) error {
+	var ids []primitive.ObjectID
+	err := store.Each(ctx, func(u User) error {
+		ids = append(ids, u.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := store.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type conformanceCase struct {
+	name string
+	run  func(ctx context.Context, store UserStore) error
+}
+
+// Deterministic ID, so expected orders do not depend on the clock
+func fixedID(n int) primitive.ObjectID {
+	var id primitive.ObjectID
+	id[0], id[10], id[11] = 0x60, byte(n>>8), byte(n)
+	return id
+}
+
+// Names chosen to catch collation, case and LIKE/regex escaping differences,
+// custom fields to catch missing value and numeric ordering differences, tags
+// to catch facet tie ordering differences
+var conformanceSeed = []User{
+	{ID: fixedID(1), Name: "Bob", Age: 30, Custom: map[string]any{"dept": "Sales", "level": int64(2)}, Tags: []string{"vip", "sales"}},
+	{ID: fixedID(2), Name: "ann", Age: 25, Location: newGeoPoint(2.3522, 48.8566), Tags: []string{"new"}},
+	{ID: fixedID(3), Name: "Anna", Age: 30, Custom: map[string]any{"dept": "Eng", "level": int64(10)}, Location: newGeoPoint(-0.1276, 51.5072), Tags: []string{"vip", "eng", "beta"}},
+	{ID: fixedID(4), Name: "Ann", Age: 41, Custom: map[string]any{"dept": "Sales"}, Location: newGeoPoint(2.3522, 48.8566), Tags: []string{"sales"}},
+	{ID: fixedID(5), Name: "A_n", Age: 25, Location: newGeoPoint(4.8357, 45.764)},
+	{ID: fixedID(6), Name: "A%n", Age: 0, Location: newGeoPoint(179.5, -16.5)},
+	{ID: fixedID(7), Name: "Ann", Age: 18, Location: newGeoPoint(-179.5, -17)},
+	{ID: fixedID(8), Name: "Zoë", Age: 150, Custom: map[string]any{"dept": "eng", "level": int64(1), "active": true}, Tags: []string{"eng", "vip"}},
+	{ID: fixedID(9), Name: "Ab.c", Age: 30},
+	{ID: fixedID(10), Name: "Éva", Age: 25},
+}
+
+func seedStore(ctx context.Context, store UserStore) error {
+	return store.InsertMany(ctx, append([]User(nil), conformanceSeed...))
+}
+
+// $filter over the seed's fields, which must parse
+func conformanceWhere(src string) ODataExpr {
+	fields := map[string]string{
+		"name":          CustomString,
+		"age":           CustomInteger,
+		"custom.dept":   CustomString,
+		"custom.level":  CustomInteger,
+		"custom.active": CustomBoolean,
+	}
+	expr, err := parseODataFilter(src, fields)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// What every backend must return for q over the seed
+func expectedList(q ListQuery) []User {
+	list := []User{}
+	for _, u := range conformanceSeed {
+		if q.matches(u) {
+			list = append(list, u)
+		}
+	}
+	return q.apply(list)
+}
+
+func expectUser(what string, got User, err error, want User) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: got %+v, want %+v", what, got, want)
+	}
+	return nil
+}
+
+func expectErr(what string, err, want error) error {
+	if !errors.Is(err, want) {
+		return fmt.Errorf("%s: got error %v, want %v", what, err, want)
+	}
+	return nil
+}
+
+func expectCount(what string, got int64, err error, want int64) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	if got != want {
+		return fmt.Errorf("%s: got %d, want %d", what, got, want)
+	}
+	return nil
+}
+
+var conformanceQueries = []struct {
+	name string
+	q    ListQuery
+}{
+	{"everything in _id order", ListQuery{}},
+	{"exact name", ListQuery{Name: "Ann"}},
+	{"exact name is case sensitive", ListQuery{Name: "ann"}},
+	{"prefix", ListQuery{NamePrefix: "An"}},
+	{"prefix with underscore", ListQuery{NamePrefix: "A_"}},
+	{"prefix with percent", ListQuery{NamePrefix: "A%"}},
+	{"prefix with regex dot", ListQuery{NamePrefix: "Ab."}},
+	{"prefix with non ASCII", ListQuery{NamePrefix: "É"}},
+	{"name and matching prefix", ListQuery{Name: "Anna", NamePrefix: "An"}},
+	{"name and contradictory prefix", ListQuery{Name: "Bob", NamePrefix: "An"}},
+	{"age range", ListQuery{MinAge: intPtr(25), MaxAge: intPtr(30)}},
+	{"zero min age", ListQuery{MinAge: intPtr(0), MaxAge: intPtr(0)}},
+	{"sort by name", ListQuery{Sort: []SortKey{{Field: "name"}}}},
+	{"sort by name descending", ListQuery{Sort: []SortKey{{Field: "name", Desc: true}}}},
+	{"sort by age with ties", ListQuery{Sort: []SortKey{{Field: "age"}}}},
+	{"sort by age descending with ties", ListQuery{Sort: []SortKey{{Field: "age", Desc: true}}}},
+	{"limit", ListQuery{Sort: []SortKey{{Field: "age"}}, Limit: 3}},
+	{"offset", ListQuery{Sort: []SortKey{{Field: "name"}}, Offset: 4}},
+	{"limit and offset", ListQuery{Sort: []SortKey{{Field: "age", Desc: true}}, Limit: 2, Offset: 3}},
+	{"offset past the end", ListQuery{Offset: 50}},
+	{"filters, sort and page", ListQuery{NamePrefix: "A", MinAge: intPtr(20), Sort: []SortKey{{Field: "age"}}, Limit: 2, Offset: 1}},
+	{"custom field", ListQuery{Custom: map[string]any{"dept": "Sales"}}},
+	{"custom number", ListQuery{Custom: map[string]any{"level": int64(10)}}},
+	{"custom boolean", ListQuery{Custom: map[string]any{"active": true}}},
+	{"sort by custom string, missing first", ListQuery{Sort: []SortKey{{Field: "custom.dept", Type: CustomString}}}},
+	{"sort by custom integer descending", ListQuery{Sort: []SortKey{{Field: "custom.level", Type: CustomInteger, Desc: true}}}},
+	{"sort by age then name descending", ListQuery{Sort: []SortKey{{Field: "age"}, {Field: "name", Desc: true}}}},
+	{"$filter comparisons", ListQuery{Where: conformanceWhere("age ge 25 and age lt 41 and name ne 'Bob'")}},
+	{"$filter or and not", ListQuery{Where: conformanceWhere("not (age gt 20) or custom/dept eq 'Eng'")}},
+	{"$filter startswith and contains", ListQuery{Where: conformanceWhere("startswith(name, 'A') and contains(name, 'n')")}},
+	{"$filter regex characters are literal", ListQuery{Where: conformanceWhere("contains(name, '.') or startswith(name, 'A_')")}},
+	{"$filter null matches missing", ListQuery{Where: conformanceWhere("custom/level eq null")}},
+	{"$filter ne null", ListQuery{Where: conformanceWhere("custom/dept ne null and custom/active ne true")}},
+	{"$filter custom range skips missing", ListQuery{Where: conformanceWhere("custom/level le 2")}},
+	{"$filter with filters, sort and page", ListQuery{MinAge: intPtr(20), Where: conformanceWhere("name ne 'Zoë'"), Sort: []SortKey{{Field: "name"}}, Limit: 3, Offset: 1}},
+	{"near, nearest first with ties", ListQuery{Near: &GeoCircle{Center: newGeoPoint(2.35, 48.85), Radius: 500_000}}},
+	{"near, sorted by name and paged", ListQuery{Near: &GeoCircle{Center: newGeoPoint(2.35, 48.85), Radius: 500_000}, Sort: []SortKey{{Field: "name"}}, Limit: 1, Offset: 1}},
+	{"near with filters and page", ListQuery{Near: &GeoCircle{Center: newGeoPoint(2.35, 48.85), Radius: 1_000_000}, MinAge: intPtr(20), Limit: 2}},
+	{"near the antimeridian", ListQuery{Near: &GeoCircle{Center: newGeoPoint(180, -16.75), Radius: 200_000}}},
+	{"bounding box", ListQuery{Box: &GeoBox{MinLng: -1, MinLat: 45, MaxLng: 3, MaxLat: 52}}},
+	{"bounding box across the antimeridian", ListQuery{Box: &GeoBox{MinLng: 179, MinLat: -20, MaxLng: -179, MaxLat: -10}}},
+	{"all of the tags", ListQuery{TagsAll: []string{"vip", "eng"}}},
+	{"any of the tags", ListQuery{TagsAny: []string{"new", "beta", "missing"}}},
+	{"all and any tags, sorted and paged", ListQuery{TagsAll: []string{"vip"}, TagsAny: []string{"eng", "sales"}, Sort: []SortKey{{Field: "name"}}, Limit: 2}},
+	{"custom filter, sort and page", ListQuery{Custom: map[string]any{"dept": "Sales"}, Sort: []SortKey{{Field: "custom.level", Type: CustomInteger}}, Limit: 1}},
+}
+
+// Searches over the seed; names differ in case and diacritics, tags repeat
+var conformanceSearches = []struct {
+	name          string
+	q             string
+	limit, offset int
+}{
+	{"word, case insensitive, ties in _id order", "ANN", 0, 0},
+	{"any of the words, weighted by field", "vip eng", 0, 0},
+	{"diacritic insensitive", "zoe eva", 0, 0},
+	{"delimiters split words", "n", 0, 0},
+	{"phrase", `"ann"`, 0, 0},
+	{"phrase and word", `"an" vip`, 0, 0},
+	{"negated word", "vip -sales", 0, 0},
+	{"negated phrase", `ann -"nn"`, 0, 0},
+	{"no match", "nobody", 0, 0},
+	{"paged", "ann vip eng", 2, 1},
+}
+
+// IDs of search hits, in order
+func searchIDs(hits []SearchHit) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+var conformanceCases = []conformanceCase{
+	{"empty store", func(ctx context.Context, store UserStore) error {
+		n, err := store.Count(ctx)
+		if err := expectCount("count", n, err, 0); err != nil {
+			return err
+		}
+		list, err := store.List(ctx, ListQuery{})
+		if err != nil {
+			return err
+		}
+		if list == nil || len(list) != 0 {
+			return fmt.Errorf("list: got %#v, want empty non-nil slice", list)
+		}
+		_, err = store.Get(ctx, fixedID(1))
+		if err := expectErr("get", err, ErrNotFound); err != nil {
+			return err
+		}
+		_, err = store.FindByName(ctx, "Ann")
+		if err := expectErr("find by name", err, ErrNotFound); err != nil {
+			return err
+		}
+		_, err = store.UpdateByName(ctx, "Ann", User{Name: "Ann", Age: 1})
+		if err := expectErr("update by name", err, ErrNotFound); err != nil {
+			return err
+		}
+		_, err = store.DeleteByName(ctx, "Ann")
+		if err := expectErr("delete by name", err, ErrNotFound); err != nil {
+			return err
+		}
+		return expectErr("delete", store.Delete(ctx, fixedID(1)), ErrNotFound)
+	}},
+
+	{"insert assigns IDs and rejects duplicates", func(ctx context.Context, store UserStore) error {
+		user := User{Name: "Ann", Age: 41}
+		if err := store.Insert(ctx, &user); err != nil {
+			return err
+		}
+		if user.ID.IsZero() {
+			return errors.New("insert: no ID assigned")
+		}
+		got, err := store.Get(ctx, user.ID)
+		if err := expectUser("get", got, err, user); err != nil {
+			return err
+		}
+		dup := User{ID: user.ID, Name: "Other", Age: 1}
+		if err := expectErr("insert duplicate", store.Insert(ctx, &dup), ErrDuplicateID); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, user.ID)
+		return expectUser("get after duplicate", got, err, user)
+	}},
+
+	{"returned users are copies", func(ctx context.Context, store UserStore) error {
+		user := User{ID: fixedID(1), Name: "Ann", Custom: map[string]any{"dept": "Eng"}, Tags: []string{"vip"}, Location: newGeoPoint(1, 2)}
+		if err := store.Insert(ctx, &user); err != nil {
+			return err
+		}
+		// Changing what reads return must not reach the stored user
+		scribble := func(u User) {
+			u.Custom["dept"] = "changed"
+			u.Tags[0] = "changed"
+			u.Location.Coordinates[0] = 99
+		}
+		got, err := store.Get(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		scribble(got)
+		got, err = store.FindByName(ctx, "Ann")
+		if err != nil {
+			return err
+		}
+		scribble(got)
+		list, err := store.List(ctx, ListQuery{})
+		if err != nil || len(list) != 1 {
+			return fmt.Errorf("list: got %v, %v", list, err)
+		}
+		scribble(list[0])
+		if err := store.Each(ctx, func(u User) error { scribble(u); return nil }); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, user.ID)
+		return expectUser("get after changing reads", got, err, user)
+	}},
+
+	{"insert many", func(ctx context.Context, store UserStore) error {
+		list := []User{{Name: "Ann", Age: 1}, {Name: "Bob", Age: 2}, {ID: fixedID(3), Name: "Eve", Age: 3}}
+		if err := store.InsertMany(ctx, list); err != nil {
+			return err
+		}
+		for _, u := range list {
+			if u.ID.IsZero() {
+				return errors.New("insert many: no ID assigned")
+			}
+			got, err := store.Get(ctx, u.ID)
+			if err := expectUser("get "+u.Name, got, err, u); err != nil {
+				return err
+			}
+		}
+		err := store.InsertMany(ctx, []User{{ID: fixedID(3), Name: "Eve", Age: 3}})
+		if err := expectErr("insert many duplicate", err, ErrDuplicateID); err != nil {
+			return err
+		}
+		n, err := store.Count(ctx)
+		return expectCount("count", n, err, 3)
+	}},
+
+	{"list filters, sorting and pagination", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		for _, tc := range conformanceQueries {
+			got, err := store.List(ctx, tc.q)
+			if err != nil {
+				return fmt.Errorf("%s: %w", tc.name, err)
+			}
+			if want := expectedList(tc.q); !reflect.DeepEqual(got, want) {
+				return fmt.Errorf("%s: got %v, want %v", tc.name, got, want)
+			}
+			n, err := store.CountList(ctx, tc.q)
+			if err := expectCount(tc.name+": count", n, err, int64(len(expectedList(tc.q.unpaged())))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+
+	{"each visits users in _id order", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		var got []User
+		err := store.Each(ctx, func(u User) error {
+			got = append(got, u)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if want := expectedList(ListQuery{}); !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("got %v, want %v", got, want)
+		}
+
+		stop := errors.New("stop")
+		visited := 0
+		err = store.Each(ctx, func(User) error {
+			visited++
+			return stop
+		})
+		if !errors.Is(err, stop) || visited != 1 {
+			return fmt.Errorf("callback error: got %v after %d users, want stop after 1", err, visited)
+		}
+		return nil
+	}},
+
+	{"name lookups use the oldest match", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		oldest, newer := conformanceSeed[3], conformanceSeed[6]
+
+		got, err := store.FindByName(ctx, "Ann")
+		if err := expectUser("find by name", got, err, oldest); err != nil {
+			return err
+		}
+		n, err := store.CountByName(ctx, "Ann")
+		if err := expectCount("count by name", n, err, 2); err != nil {
+			return err
+		}
+
+		updated := oldest
+		updated.Age = 42
+		got, err = store.UpdateByName(ctx, "Ann", User{Name: "Ann", Age: 42})
+		if err := expectUser("update by name", got, err, updated); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, newer.ID)
+		if err := expectUser("untouched duplicate", got, err, newer); err != nil {
+			return err
+		}
+
+		got, err = store.DeleteByName(ctx, "Ann")
+		if err := expectUser("delete by name", got, err, updated); err != nil {
+			return err
+		}
+		got, err = store.FindByName(ctx, "Ann")
+		if err := expectUser("find after delete", got, err, newer); err != nil {
+			return err
+		}
+		n, err = store.CountByName(ctx, "Ann")
+		return expectCount("count after delete", n, err, 1)
+	}},
+
+	{"rename moves the user between names", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		bob := conformanceSeed[0]
+		renamed := bob
+		renamed.Name, renamed.Age = "Robert", 31
+		got, err := store.UpdateByName(ctx, "Bob", User{Name: "Robert", Age: 31})
+		if err := expectUser("update by name", got, err, renamed); err != nil {
+			return err
+		}
+		_, err = store.FindByName(ctx, "Bob")
+		if err := expectErr("old name", err, ErrNotFound); err != nil {
+			return err
+		}
+		list, err := store.List(ctx, ListQuery{NamePrefix: "Rob"})
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(list, []User{renamed}) {
+			return fmt.Errorf("list by new prefix: got %v", list)
+		}
+		return nil
+	}},
+
+	{"custom fields round trip", func(ctx context.Context, store UserStore) error {
+		user := User{Name: "Ann", Age: 20, Custom: map[string]any{"dept": "Eng", "score": 1.5, "level": int64(3), "active": false}}
+		if err := store.Insert(ctx, &user); err != nil {
+			return err
+		}
+		got, err := store.Get(ctx, user.ID)
+		if err := expectUser("get", got, err, user); err != nil {
+			return err
+		}
+
+		// Updates by name only set name and age, custom fields are kept
+		updated := user
+		updated.Age = 21
+		got, err = store.UpdateByName(ctx, "Ann", User{Name: "Ann", Age: 21})
+		if err := expectUser("update by name", got, err, updated); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, user.ID)
+		if err := expectUser("get after update", got, err, updated); err != nil {
+			return err
+		}
+
+		// Upsert replaces them, and none read back as nil
+		cleared := User{ID: user.ID, Name: "Ann", Age: 21}
+		if err := store.Upsert(ctx, cleared); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, user.ID)
+		return expectUser("get after upsert", got, err, cleared)
+	}},
+
+	{"upsert inserts and replaces", func(ctx context.Context, store UserStore) error {
+		user := User{ID: fixedID(42), Name: "Ann", Age: 20}
+		if err := store.Upsert(ctx, user); err != nil {
+			return err
+		}
+		got, err := store.Get(ctx, user.ID)
+		if err := expectUser("after insert", got, err, user); err != nil {
+			return err
+		}
+		user.Name, user.Age = "Anne", 21
+		if err := store.Upsert(ctx, user); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, user.ID)
+		if err := expectUser("after replace", got, err, user); err != nil {
+			return err
+		}
+		n, err := store.CountByName(ctx, "Ann")
+		if err := expectCount("old name count", n, err, 0); err != nil {
+			return err
+		}
+		n, err = store.Count(ctx)
+		return expectCount("count", n, err, 1)
+	}},
+
+	{"delete by ID", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		target := conformanceSeed[2]
+		if err := store.Delete(ctx, target.ID); err != nil {
+			return err
+		}
+		_, err := store.Get(ctx, target.ID)
+		if err := expectErr("get deleted", err, ErrNotFound); err != nil {
+			return err
+		}
+		if err := expectErr("delete again", store.Delete(ctx, target.ID), ErrNotFound); err != nil {
+			return err
+		}
+		n, err := store.CountByName(ctx, target.Name)
+		if err := expectCount("count by name", n, err, 0); err != nil {
+			return err
+		}
+		n, err = store.Count(ctx)
+		return expectCount("count", n, err, int64(len(conformanceSeed)-1))
+	}},
+
+	{"tags add, remove and facets", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		anna := conformanceSeed[2]
+
+		// Existing tags keep their place, new ones are appended in order
+		want := anna
+		want.Tags = []string{"vip", "eng", "beta", "new", "alpha"}
+		got, err := store.AddTags(ctx, anna.ID, []string{"eng", "new", "alpha"})
+		if err := expectUser("add", got, err, want); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, anna.ID)
+		if err := expectUser("get after add", got, err, want); err != nil {
+			return err
+		}
+
+		many := make([]string, maxTags)
+		for i := range many {
+			many[i] = fmt.Sprintf("t%d", i)
+		}
+		_, err = store.AddTags(ctx, anna.ID, many)
+		if err := expectErr("add past the limit", err, ErrTooManyTags); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, anna.ID)
+		if err := expectUser("get after refused add", got, err, want); err != nil {
+			return err
+		}
+		_, err = store.AddTags(ctx, fixedID(99), []string{"vip"})
+		if err := expectErr("add to missing user", err, ErrNotFound); err != nil {
+			return err
+		}
+
+		want.Tags = []string{"vip", "beta", "new", "alpha"}
+		got, err = store.RemoveTags(ctx, anna.ID, []string{"eng", "unknown"})
+		if err := expectUser("remove", got, err, want); err != nil {
+			return err
+		}
+		ann := conformanceSeed[3]
+		ann.Tags = nil
+		got, err = store.RemoveTags(ctx, ann.ID, []string{"sales"})
+		if err := expectUser("remove the last tag", got, err, ann); err != nil {
+			return err
+		}
+		got, err = store.Get(ctx, ann.ID)
+		if err := expectUser("get after removing the last tag", got, err, ann); err != nil {
+			return err
+		}
+		_, err = store.RemoveTags(ctx, fixedID(99), []string{"vip"})
+		if err := expectErr("remove from missing user", err, ErrNotFound); err != nil {
+			return err
+		}
+
+		for _, q := range []ListQuery{{}, {MinAge: intPtr(30)}, {TagsAny: []string{"eng", "new"}}, {Name: "nobody"}} {
+			facets, err := store.Facets(ctx, q)
+			if err != nil {
+				return fmt.Errorf("facets %+v: %w", q, err)
+			}
+			list, err := store.List(ctx, q)
+			if err != nil {
+				return err
+			}
+			if want := countFacets(list); !reflect.DeepEqual(facets, want) {
+				return fmt.Errorf("facets %+v: got %+v, want %+v", q, facets, want)
+			}
+		}
+		return nil
+	}},
+
+	{"search ranks, filters and pages", func(ctx context.Context, store UserStore) error {
+		if err := seedStore(ctx, store); err != nil {
+			return err
+		}
+		seed := newMemoryStore()
+		if err := seedStore(ctx, seed); err != nil {
+			return err
+		}
+		for _, tc := range conformanceSearches {
+			q, ferr := parseSearch(tc.q)
+			if ferr != nil {
+				return fmt.Errorf("%s: %v", tc.name, ferr.Code)
+			}
+			q.Limit, q.Offset = tc.limit, tc.offset
+			got, total, err := store.Search(ctx, q)
+			if err != nil {
+				return fmt.Errorf("%s: %w", tc.name, err)
+			}
+			want, wantTotal, _ := searchEach(ctx, seed.Each, q)
+			// Scores are compared through the order, floating point sums may differ
+			if !reflect.DeepEqual(searchIDs(got), searchIDs(want)) || total != wantTotal {
+				return fmt.Errorf("%s: got %d of %v, want %d of %v", tc.name, total, searchIDs(got), wantTotal, searchIDs(want))
+			}
+		}
+		return nil
+	}},
+}
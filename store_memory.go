@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserStore in process memory, for --dev and experiments. Lost on exit.
+type memoryStore struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]User
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{users: map[primitive.ObjectID]User{}}
+}
+
+// Copy with its own custom map, so callers cannot change stored users
+func detached(u User) User {
+	u.Custom = maps.Clone(u.Custom)
+	u.Location = u.Location.clone()
+	u.Tags = slices.Clone(u.Tags)
+	return u
+}
+
+// All users in _id order, caller holds the lock
+func (s *memoryStore) sorted() []User {
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sortUsersByID(list)
+	return list
+}
+
+// Oldest user with that name, caller holds the lock
+func (s *memoryStore) findByName(name string) (User, bool) {
+	for _, u := range s.sorted() {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *memoryStore) List(ctx context.Context, q ListQuery) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []User{}
+	for _, u := range s.users {
+		if q.matches(u) {
+			list = append(list, detached(u))
+		}
+	}
+	return q.apply(list), nil
+}
+
+func (s *memoryStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	list, err := s.List(ctx, q.unpaged())
+	return int64(len(list)), err
+}
+
+func (s *memoryStore) Count(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.users)), nil
+}
+
+func (s *memoryStore) EstimatedCount(ctx context.Context) (int64, error) {
+	return s.Count(ctx)
+}
+
+// Visits a snapshot, so fn may write to the store
+func (s *memoryStore) Each(ctx context.Context, fn func(User) error) error {
+	s.mu.RLock()
+	list := s.sorted()
+	s.mu.RUnlock()
+
+	for _, u := range list {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(detached(u)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return detached(user), nil
+}
+
+func (s *memoryStore) FindByName(ctx context.Context, name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.findByName(name)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return detached(user), nil
+}
+
+func (s *memoryStore) CountByName(ctx context.Context, name string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int64
+	for _, u := range s.users {
+		if u.Name == name {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Insert(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.users[user.ID]; taken {
+		return ErrDuplicateID
+	}
+	s.users[user.ID] = detached(*user)
+	return nil
+}
+
+// All or nothing, like the bolt store
+func (s *memoryStore) InsertMany(ctx context.Context, list []User) error {
+	for i := range list {
+		if list[i].ID.IsZero() {
+			list[i].ID = primitive.NewObjectID()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[primitive.ObjectID]bool, len(list))
+	for _, u := range list {
+		if _, taken := s.users[u.ID]; taken || seen[u.ID] {
+			return ErrDuplicateID
+		}
+		seen[u.ID] = true
+	}
+	for _, u := range list {
+		s.users[u.ID] = detached(u)
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.findByName(name)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.Name, user.Age = update.Name, update.Age
+	s.users[user.ID] = detached(user)
+	return user, nil
+}
+
+func (s *memoryStore) Upsert(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = detached(user)
+	return nil
+}
+
+func (s *memoryStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.findByName(name)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	delete(s.users, user.ID)
+	return user, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+func (s *memoryStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	merged, err := withTags(user.Tags, tags)
+	if err != nil {
+		return User{}, err
+	}
+	user.Tags = merged
+	s.users[id] = detached(user)
+	return user, nil
+}
+
+func (s *memoryStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.Tags = withoutTags(user.Tags, tags)
+	s.users[id] = detached(user)
+	return user, nil
+}
+
+func (s *memoryStore) Facets(ctx context.Context, q ListQuery) (UserFacets, error) {
+	list, err := s.List(ctx, q.unpaged())
+	return countFacets(list), err
+}
+
+func (s *memoryStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	return searchEach(ctx, s.Each, q)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserStore backed by a MongoDB collection
+type mongoStore struct {
+	coll *mongo.Collection
+}
+
+func newMongoStore(coll *mongo.Collection) *mongoStore {
+	return &mongoStore{coll: coll}
+}
+
+// Index backing name lookups and prefix filters
+func (s *mongoStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}},
+		// Serves $geoNear and $geoWithin; users without a location are skipped
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+	})
+	return err
+}
+
+// Text index over the fields of searchFields, with the same weights
+const mongoTextIndex = "users_text"
+
+func mongoTextWeights() map[string]int32 {
+	weights := map[string]int32{"name": searchWeightName, "tags": searchWeightTags}
+	for _, name := range searchCustomFields() {
+		weights["custom."+name] = searchWeightCustom
+	}
+	return weights
+}
+
+// Create the text index, or rebuild it when the string custom fields changed;
+// a collection has at most one. Searches fail while it is rebuilt.
+func (s *mongoStore) ensureTextIndex(ctx context.Context) error {
+	want := mongoTextWeights()
+	cursor, err := s.coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name    string           `bson:"name"`
+		Weights map[string]int32 `bson:"weights"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Weights == nil {
+			continue
+		}
+		if maps.Equal(index.Weights, want) {
+			return nil
+		}
+		if _, err := s.coll.Indexes().DropOne(ctx, index.Name); err != nil {
+			return err
+		}
+	}
+
+	keys := bson.D{}
+	for _, field := range slices.Sorted(maps.Keys(want)) {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	// No stemming or stop words, so the Go fallback matches the same words
+	opts := options.Index().SetName(mongoTextIndex).SetWeights(want).SetDefaultLanguage("none")
+	_, err = s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return err
+}
+
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func duplicateID(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateID
+	}
+	return err
+}
+
+// Name lookups pick the oldest match, like the other backends
+var oldestFirst = bson.D{{Key: "_id", Value: 1}}
+
+// Mongo filter and options for a ListQuery
+func mongoListQuery(q ListQuery) (bson.M, *options.FindOptions) {
+	filter := bson.M{}
+	switch {
+	case q.Name != "":
+		filter["name"] = q.Name
+	case q.NamePrefix != "":
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.NamePrefix)}
+	}
+	if q.Name != "" && q.NamePrefix != "" && !strings.HasPrefix(q.Name, q.NamePrefix) {
+		// Contradictory filters, match nothing like the Go matcher does
+		filter["_id"] = bson.M{"$exists": false}
+	}
+
+	age := bson.M{}
+	if q.MinAge != nil {
+		age["$gte"] = *q.MinAge
+	}
+	if q.MaxAge != nil {
+		age["$lte"] = *q.MaxAge
+	}
+	if len(age) > 0 {
+		filter["age"] = age
+	}
+	for name, v := range q.Custom {
+		filter["custom."+name] = v
+	}
+	tags := bson.M{}
+	if len(q.TagsAll) > 0 {
+		tags["$all"] = q.TagsAll
+	}
+	if len(q.TagsAny) > 0 {
+		tags["$in"] = q.TagsAny
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+	and := bson.A{}
+	if q.Near != nil {
+		and = append(and, q.Near.mongo())
+	}
+	if q.Box != nil {
+		and = append(and, q.Box.mongo())
+	}
+	if q.Where != nil {
+		and = append(and, q.Where.mongo())
+	}
+	if len(and) > 0 {
+		filter = bson.M{"$and": append(bson.A{filter}, and...)}
+	}
+
+	sort := bson.D{}
+	for _, key := range q.Sort {
+		dir := 1
+		if key.Desc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: key.Field, Value: dir})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+
+	opts := options.Find().SetSort(sort).SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	return filter, opts
+}
+
+// Nearest first through $geoNear, which uses the 2dsphere index
+func mongoNearPipeline(q ListQuery) mongo.Pipeline {
+	near := *q.Near
+	q.Near = nil
+	filter, _ := mongoListQuery(q)
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          near.Center,
+			"key":           "location",
+			"distanceField": "_distance",
+			"maxDistance":   near.Radius,
+			"spherical":     true,
+			"query":         filter,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_distance", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: q.Offset}},
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+	return append(pipeline, bson.D{{Key: "$unset", Value: "_distance"}})
+}
+
+func (s *mongoStore) List(ctx context.Context, q ListQuery) ([]User, error) {
+	var (
+		cursor *mongo.Cursor
+		err    error
+	)
+	if q.Near != nil && len(q.Sort) == 0 {
+		cursor, err = s.coll.Aggregate(ctx, mongoNearPipeline(q))
+	} else {
+		filter, opts := mongoListQuery(q)
+		cursor, err = s.coll.Find(ctx, filter, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	list := []User{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Stops on the server too when ctx has a deadline
+func (s *mongoStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	filter, _ := mongoListQuery(q)
+	opts := options.Count()
+	if deadline, ok := ctx.Deadline(); ok {
+		opts.SetMaxTime(time.Until(deadline))
+	}
+	return s.coll.CountDocuments(ctx, filter, opts)
+}
+
+func (s *mongoStore) Count(ctx context.Context) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{})
+}
+
+// From collection metadata, may be off after unclean shutdowns or on sharded clusters
+func (s *mongoStore) EstimatedCount(ctx context.Context) (int64, error) {
+	return s.coll.EstimatedDocumentCount(ctx)
+}
+
+func (s *mongoStore) Each(ctx context.Context, fn func(User) error) error {
+	cursor, err := s.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoStore) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var user User
+	err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (s *mongoStore) FindByName(ctx context.Context, name string) (User, error) {
+	var user User
+	err := s.coll.FindOne(ctx, bson.M{"name": name}, options.FindOne().SetSort(oldestFirst)).Decode(&user)
+	return user, notFound(err)
+}
+
+func (s *mongoStore) CountByName(ctx context.Context, name string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"name": name})
+}
+
+func (s *mongoStore) Insert(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	_, err := s.coll.InsertOne(ctx, user)
+	return duplicateID(err)
+}
+
+func (s *mongoStore) InsertMany(ctx context.Context, list []User) error {
+	docs := make([]any, len(list))
+	for i := range list {
+		if list[i].ID.IsZero() {
+			list[i].ID = primitive.NewObjectID()
+		}
+		docs[i] = list[i]
+	}
+	_, err := s.coll.InsertMany(ctx, docs)
+	return duplicateID(err)
+}
+
+func (s *mongoStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	var user User
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"name": name},
+		bson.M{"$set": bson.M{"name": update.Name, "age": update.Age}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(oldestFirst),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+func (s *mongoStore) Upsert(ctx context.Context, user User) error {
+	_, err := s.coll.ReplaceOne(ctx, bson.M{"_id": user.ID}, user, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	var user User
+	err := s.coll.FindOneAndDelete(ctx, bson.M{"name": name}, options.FindOneAndDelete().SetSort(oldestFirst)).Decode(&user)
+	return user, notFound(err)
+}
+
+func (s *mongoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Adds in one write, only when the union stays within maxTags
+func (s *mongoStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	var user User
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "$expr": bson.M{"$lte": bson.A{
+			bson.M{"$size": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}},
+			maxTags,
+		}}},
+		bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either missing or over the limit
+		if _, err := s.Get(ctx, id); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrTooManyTags
+	}
+	return user, err
+}
+
+// Removes the tags and the field with them when none are left
+func (s *mongoStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	left := bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}},
+		"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", tags}}}},
+	}}
+	var user User
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"tags": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$size": left}, 0}}, "$$REMOVE", left,
+		}}}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+// Total and tag counts in one aggregation
+func (s *mongoStore) Facets(ctx context.Context, q ListQuery) (UserFacets, error) {
+	filter, _ := mongoListQuery(q)
+	cursor, err := s.coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"tags": bson.A{
+				bson.M{"$unwind": "$tags"},
+				bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}}},
+	})
+	if err != nil {
+		return UserFacets{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+		Tags []TagCount `bson:"tags"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return UserFacets{}, err
+	}
+	facets := UserFacets{Tags: []TagCount{}}
+	if len(result) > 0 {
+		if len(result[0].Total) > 0 {
+			facets.Total = result[0].Total[0].N
+		}
+		facets.Tags = append(facets.Tags, result[0].Tags...)
+	}
+	return facets, nil
+}
+
+// Most relevant first through the text index, ties in _id order
+func (s *mongoStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	filter := bson.M{"$text": bson.M{"$search": q.mongo(), "$caseSensitive": false, "$diacriticSensitive": false}}
+	total, err := s.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	hits := []SearchHit{}
+	if err := cursor.All(ctx, &hits); err != nil {
+		return nil, 0, err
+	}
+	return hits, total, nil
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema migrations, applied in order and recorded in schema_migrations.
+// Never edit an applied entry, append a new one instead.
+var pgMigrations = []string{
+	// 1: users. COLLATE "C" sorts by bytes like Mongo and lets the name index serve prefix LIKE.
+	`CREATE TABLE users (
+		id   CHAR(24) PRIMARY KEY,
+		name TEXT COLLATE "C" NOT NULL,
+		age  INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX users_name_idx ON users (name, id);
+	CREATE INDEX users_age_idx ON users (age, id);`,
+	// 2: custom fields, the GIN index serves containment filters
+	`ALTER TABLE users ADD COLUMN custom JSONB NOT NULL DEFAULT '{}';
+	CREATE INDEX users_custom_idx ON users USING GIN (custom jsonb_path_ops);`,
+	// 3: GeoJSON location; geo filters run in Go
+	`ALTER TABLE users ADD COLUMN location JSONB;`,
+	// 4: tags, the GIN index serves @> and && filters
+	`ALTER TABLE users ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+	CREATE INDEX users_tags_idx ON users USING GIN (tags);`,
+}
+
+// Arbitrary key serializing concurrent migrators
+const pgMigrationLock = 7_310_431
+
+// UserStore backed by PostgreSQL. IDs are ObjectIDs stored as 24 char hex,
+// whose text order equals their byte order.
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// Pool settings from DATABASE_URL, tuned by PG_MAX_CONNS, PG_MIN_CONNS,
+// PG_MAX_CONN_LIFETIME and PG_MAX_CONN_IDLE_TIME
+func postgresConfig() (*pgxpool.Config, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return nil, errors.New("DATABASE_URL missing")
+	}
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("PG_MAX_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PG_MAX_CONNS %q", v)
+		}
+		cfg.MaxConns = int32(n)
+	}
+	if v := os.Getenv("PG_MIN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid PG_MIN_CONNS %q", v)
+		}
+		cfg.MinConns = int32(n)
+	}
+	if v := os.Getenv("PG_MAX_CONN_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PG_MAX_CONN_LIFETIME %q", v)
+		}
+		cfg.MaxConnLifetime = d
+	}
+	if v := os.Getenv("PG_MAX_CONN_IDLE_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PG_MAX_CONN_IDLE_TIME %q", v)
+		}
+		cfg.MaxConnIdleTime = d
+	}
+	return cfg, nil
+}
+
+// Connect and bring the schema up to date
+func openPostgresStore(ctx context.Context, cfg *pgxpool.Config) (*postgresStore, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	store := &postgresStore{pool: pool}
+	if err := store.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return store, nil
+}
+
+func (s *postgresStore) Close() {
+	s.pool.Close()
+}
+
+func (s *postgresStore) migrate(ctx context.Context) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1::bigint)", pgMigrationLock); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1::bigint)", pgMigrationLock)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRow(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1").Scan(&current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	for i := current; i < len(pgMigrations); i++ {
+		version := i + 1
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, pgMigrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+		fmt.Printf("🗄️  Applied Postgres migration %d\n", version)
+	}
+	return nil
+}
+
+func pgID(id primitive.ObjectID) string {
+	return id.Hex()
+}
+
+// Columns read by scanPgUser and written from pgUserValues, in order
+const pgUserColumns = "id, name, age, custom, location, tags"
+
+// INSERT of pgUserValues
+const pgInsertUser = "INSERT INTO users (" + pgUserColumns + ") VALUES ($1, $2, $3, $4::jsonb, $5::jsonb, $6::text[])"
+
+// Column values of a user, in pgUserColumns order
+func pgUserValues(u User) ([]any, error) {
+	custom, err := pgCustom(u.Custom)
+	if err != nil {
+		return nil, err
+	}
+	var location any
+	if u.Location != nil {
+		data, err := json.Marshal(u.Location)
+		if err != nil {
+			return nil, err
+		}
+		location = string(data)
+	}
+	tags := u.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return []any{pgID(u.ID), u.Name, u.Age, custom, location, tags}, nil
+}
+
+// Custom fields as a JSONB document, {} when there are none
+func pgCustom(custom map[string]any) (string, error) {
+	if len(custom) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(custom)
+	return string(data), err
+}
+
+// Decode JSONB custom fields with the Go types the other backends return:
+// int64 for integral numbers, float64 otherwise, nil when empty
+func decodePgCustom(data []byte) (map[string]any, error) {
+	var custom map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&custom); err != nil {
+		return nil, err
+	}
+	if len(custom) == 0 {
+		return nil, nil
+	}
+	for k, v := range custom {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := n.Int64(); err == nil {
+			custom[k] = i
+		} else if f, err := n.Float64(); err == nil {
+			custom[k] = f
+		}
+	}
+	return custom, nil
+}
+
+func scanPgUser(row pgx.Row) (User, error) {
+	var (
+		user     User
+		id       string
+		custom   []byte
+		location []byte
+	)
+	if err := row.Scan(&id, &user.Name, &user.Age, &custom, &location, &user.Tags); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return User{}, ErrNotFound
+		}
+		return User{}, err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = oid
+	if len(user.Tags) == 0 {
+		user.Tags = nil
+	}
+	if user.Custom, err = decodePgCustom(custom); err != nil {
+		return User{}, err
+	}
+	if location != nil {
+		if err := json.Unmarshal(location, &user.Location); err != nil {
+			return User{}, err
+		}
+	}
+	return user, nil
+}
+
+func collectPgUsers(rows pgx.Rows) ([]User, error) {
+	defer rows.Close()
+	list := []User{}
+	for rows.Next() {
+		user, err := scanPgUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, user)
+	}
+	return list, rows.Err()
+}
+
+func pgWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicateID
+	}
+	return err
+}
+
+// Escape LIKE wildcards so the prefix is matched literally
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// Parameterized SQL for a ListQuery, same semantics as mongoListQuery.
+// $filter expressions and geo filters are not translated: with those set the
+// query is unpaged and the caller filters and pages in Go.
+func pgListQuery(q ListQuery) (string, []any) {
+	sql, args := pgListWhere(q, "SELECT "+pgUserColumns+" FROM users")
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// Sort fields are resource sort keys, never user text
+	var order []string
+	for _, key := range q.Sort {
+		dir := "ASC"
+		if key.Desc {
+			dir = "DESC"
+		}
+		if key.Field == "name" || key.Field == "age" {
+			order = append(order, key.Field+" "+dir)
+		} else if name, ok := strings.CutPrefix(key.Field, "custom."); ok {
+			// Values of another type sort as missing, and missing comes first like null in Mongo
+			k := arg(name) + "::text"
+			var expr string
+			switch key.Type {
+			case CustomString:
+				expr = "(CASE WHEN jsonb_typeof(custom->" + k + ") = 'string' THEN custom->>" + k + " END) COLLATE \"C\""
+			case CustomBoolean:
+				expr = "CASE WHEN jsonb_typeof(custom->" + k + ") = 'boolean' THEN (custom->>" + k + ")::boolean END"
+			default:
+				expr = "CASE WHEN jsonb_typeof(custom->" + k + ") = 'number' THEN (custom->>" + k + ")::numeric END"
+			}
+			nulls := "NULLS FIRST"
+			if key.Desc {
+				nulls = "NULLS LAST"
+			}
+			order = append(order, expr+" "+dir+" "+nulls)
+		}
+	}
+	sql += " ORDER BY " + strings.Join(append(order, "id"), ", ")
+
+	if pgFiltersInGo(q) {
+		return sql, args
+	}
+	if q.Limit > 0 {
+		sql += " LIMIT " + arg(q.Limit)
+	}
+	if q.Offset > 0 {
+		sql += " OFFSET " + arg(q.Offset)
+	}
+	return sql, args
+}
+
+// Whether the query has filters pgListWhere leaves to Go
+func pgFiltersInGo(q ListQuery) bool {
+	return q.Where != nil || q.Near != nil || q.Box != nil
+}
+
+// WHERE clause for the ListQuery fields other than those of pgFiltersInGo
+func pgListWhere(q ListQuery, sql string) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.Name != "" {
+		where = append(where, "name = "+arg(q.Name))
+	}
+	if q.NamePrefix != "" {
+		where = append(where, "name LIKE "+arg(likePrefix(q.NamePrefix)))
+	}
+	if q.MinAge != nil {
+		where = append(where, "age >= "+arg(*q.MinAge))
+	}
+	if q.MaxAge != nil {
+		where = append(where, "age <= "+arg(*q.MaxAge))
+	}
+	if len(q.Custom) > 0 {
+		// Containment compares numbers by value, like Mongo equality
+		doc, _ := json.Marshal(q.Custom)
+		where = append(where, "custom @> "+arg(string(doc))+"::jsonb")
+	}
+	if len(q.TagsAll) > 0 {
+		where = append(where, "tags @> "+arg(q.TagsAll)+"::text[]")
+	}
+	if len(q.TagsAny) > 0 {
+		where = append(where, "tags && "+arg(q.TagsAny)+"::text[]")
+	}
+
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	return sql, args
+}
+
+func (s *postgresStore) List(ctx context.Context, q ListQuery) ([]User, error) {
+	sql, args := pgListQuery(q)
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	list, err := collectPgUsers(rows)
+	if err != nil || !pgFiltersInGo(q) {
+		return list, err
+	}
+	filtered := []User{}
+	for _, u := range list {
+		if q.matches(u) {
+			filtered = append(filtered, u)
+		}
+	}
+	return q.apply(filtered), nil
+}
+
+func (s *postgresStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	if pgFiltersInGo(q) {
+		list, err := s.List(ctx, q.unpaged())
+		return int64(len(list)), err
+	}
+	sql, args := pgListWhere(q, "SELECT COUNT(*) FROM users")
+	var n int64
+	err := s.pool.QueryRow(ctx, sql, args...).Scan(&n)
+	return n, err
+}
+
+func (s *postgresStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// Planner statistics, refreshed by (auto)vacuum and analyze; counts when the
+// table was never analyzed
+func (s *postgresStore) EstimatedCount(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = 'users'::regclass").Scan(&n)
+	if err != nil || n >= 0 {
+		return n, err
+	}
+	return s.Count(ctx)
+}
+
+func (s *postgresStore) Each(ctx context.Context, fn func(User) error) error {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgUserColumns+" FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanPgUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *postgresStore) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return scanPgUser(s.pool.QueryRow(ctx, "SELECT "+pgUserColumns+" FROM users WHERE id = $1", pgID(id)))
+}
+
+// The oldest user with that name, like the other backends
+func (s *postgresStore) FindByName(ctx context.Context, name string) (User, error) {
+	return scanPgUser(s.pool.QueryRow(ctx, "SELECT "+pgUserColumns+" FROM users WHERE name = $1 ORDER BY id LIMIT 1", name))
+}
+
+func (s *postgresStore) CountByName(ctx context.Context, name string) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE name = $1", name).Scan(&n)
+	return n, err
+}
+
+func (s *postgresStore) Insert(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	values, err := pgUserValues(*user)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, pgInsertUser, values...)
+	return pgWriteError(err)
+}
+
+func (s *postgresStore) InsertMany(ctx context.Context, list []User) error {
+	for i := range list {
+		if list[i].ID.IsZero() {
+			list[i].ID = primitive.NewObjectID()
+		}
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for _, user := range list {
+			values, err := pgUserValues(user)
+			if err != nil {
+				return err
+			}
+			batch.Queue(pgInsertUser, values...)
+		}
+		return pgWriteError(tx.SendBatch(ctx, batch).Close())
+	})
+}
+
+func (s *postgresStore) UpdateByName(ctx context.Context, name string, update User) (User, error) {
+	return scanPgUser(s.pool.QueryRow(ctx, `
+		UPDATE users SET name = $2, age = $3
+		WHERE id = (SELECT id FROM users WHERE name = $1 ORDER BY id LIMIT 1)
+		RETURNING `+pgUserColumns, name, update.Name, update.Age))
+}
+
+func (s *postgresStore) Upsert(ctx context.Context, user User) error {
+	values, err := pgUserValues(user)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, pgInsertUser+`
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, age = EXCLUDED.age, custom = EXCLUDED.custom, location = EXCLUDED.location,
+			tags = EXCLUDED.tags`,
+		values...)
+	return err
+}
+
+func (s *postgresStore) DeleteByName(ctx context.Context, name string) (User, error) {
+	return scanPgUser(s.pool.QueryRow(ctx, `
+		DELETE FROM users
+		WHERE id = (SELECT id FROM users WHERE name = $1 ORDER BY id LIMIT 1)
+		RETURNING `+pgUserColumns, name))
+}
+
+func (s *postgresStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM users WHERE id = $1", pgID(id))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Read, change and write the user's tags under a row lock
+func (s *postgresStore) updateTags(ctx context.Context, id primitive.ObjectID, change func([]string) ([]string, error)) (User, error) {
+	var user User
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var err error
+		user, err = scanPgUser(tx.QueryRow(ctx, "SELECT "+pgUserColumns+" FROM users WHERE id = $1 FOR UPDATE", pgID(id)))
+		if err != nil {
+			return err
+		}
+		if user.Tags, err = change(user.Tags); err != nil {
+			return err
+		}
+		tags := user.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		_, err = tx.Exec(ctx, "UPDATE users SET tags = $2::text[] WHERE id = $1", pgID(id), tags)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *postgresStore) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	return s.updateTags(ctx, id, func(current []string) ([]string, error) {
+		return withTags(current, tags)
+	})
+}
+
+func (s *postgresStore) RemoveTags(ctx context.Context, id primitive.ObjectID, tags []string) (User, error) {
+	return s.updateTags(ctx, id, func(current []string) ([]string, error) {
+		return withoutTags(current, tags), nil
+	})
+}
+
+// Counted in SQL unless the query has filters left to Go
+func (s *postgresStore) Facets(ctx context.Context, q ListQuery) (UserFacets, error) {
+	if pgFiltersInGo(q) {
+		list, err := s.List(ctx, q.unpaged())
+		return countFacets(list), err
+	}
+	total, err := s.CountList(ctx, q)
+	if err != nil {
+		return UserFacets{}, err
+	}
+	sql, args := pgListWhere(q, "SELECT tags FROM users")
+	rows, err := s.pool.Query(ctx, `
+		SELECT tag, COUNT(*) FROM (`+sql+`) matched, unnest(matched.tags) AS tag
+		GROUP BY tag ORDER BY COUNT(*) DESC, tag COLLATE "C"`, args...)
+	if err != nil {
+		return UserFacets{}, err
+	}
+	defer rows.Close()
+
+	facets := UserFacets{Total: total, Tags: []TagCount{}}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return UserFacets{}, err
+		}
+		facets.Tags = append(facets.Tags, tc)
+	}
+	return facets, rows.Err()
+}
+
+// Scans the table, there is no full-text index
+func (s *postgresStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	return searchEach(ctx, s.Each, q)
+}
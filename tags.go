@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxTags = 32
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Returned by AddTags when the user would end up with more than maxTags
+var ErrTooManyTags = errors.New("too many tags")
+
+// Tag counts over the users matching a query
+type UserFacets struct {
+	Total int64      `json:"total"`
+	Tags  []TagCount `json:"tags"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// Trimmed, lowercased and deduplicated tags in their first seen order, nil
+// when there are none so every backend reads the user back the same way
+func normalizeTags(path string, tags []string) ([]string, []*FieldError) {
+	var (
+		out  []string
+		errs []*FieldError
+	)
+	for i, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			errs = append(errs, &FieldError{Field: fmt.Sprintf("%s.%d", path, i), Code: ErrFieldInvalidFormat, Params: fiber.Map{"pattern": tagPattern.String()}})
+			continue
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	if len(out) > maxTags {
+		errs = append(errs, &FieldError{Field: path, Code: ErrFieldTooMany, Params: fiber.Map{"max": maxTags}})
+	}
+	return out, errs
+}
+
+// Comma separated tags from a query parameter
+func parseTagsParam(param, raw string) (any, *FieldError) {
+	tags, errs := normalizeTags(param, strings.Split(raw, ","))
+	if len(errs) > 0 {
+		errs[0].Field = param
+		return nil, errs[0]
+	}
+	return tags, nil
+}
+
+// Tags after adding some, as $addToSet does: new ones appended in order
+func withTags(current, add []string) ([]string, error) {
+	out := slices.Clone(current)
+	for _, tag := range add {
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	if len(out) > maxTags {
+		return current, ErrTooManyTags
+	}
+	return out, nil
+}
+
+// Tags after removing some, as $pull does; nil when none are left
+func withoutTags(current, remove []string) []string {
+	out := slices.DeleteFunc(slices.Clone(current), func(tag string) bool { return slices.Contains(remove, tag) })
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Facets computed in Go, for backends that scan
+func countFacets(list []User) UserFacets {
+	counts := map[string]int64{}
+	for _, u := range list {
+		for _, tag := range u.Tags {
+			counts[tag]++
+		}
+	}
+	facets := UserFacets{Total: int64(len(list)), Tags: make([]TagCount, 0, len(counts))}
+	for tag, n := range counts {
+		facets.Tags = append(facets.Tags, TagCount{Tag: tag, Count: n})
+	}
+	sortTagCounts(facets.Tags)
+	return facets
+}
+
+// Most used first, then by tag
+func sortTagCounts(tags []TagCount) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+}
+
+// User ID and tags of a tag request; false when a response was sent
+func tagRequest(c *fiber.Ctx, tags []string) (primitive.ObjectID, []string, bool, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return id, nil, false, apiError(c, 400, ErrInvalidID)
+	}
+	if len(tags) == 0 {
+		return id, nil, false, validationError(c, []*FieldError{{Field: "tags", Code: ErrFieldRequired}})
+	}
+	tags, errs := normalizeTags("tags", tags)
+	if len(errs) > 0 {
+		return id, nil, false, validationError(c, errs)
+	}
+	return id, tags, true, nil
+}
+
+// Answer a tag write: 404, 400 over maxTags, 500 otherwise
+func tagWriteError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return apiError(c, 404, ErrUserNotFound)
+	case errors.Is(err, ErrTooManyTags):
+		return validationError(c, []*FieldError{{Field: "tags", Code: ErrFieldTooMany, Params: fiber.Map{"max": maxTags}}})
+	}
+	return apiError(c, 500, ErrUpdateFailed)
+}
+
+// POST /users/:id/tags {"tags": ["vip"]} adds tags, keeping those already set
+func addUserTags(c *fiber.Ctx) error {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return apiError(c, 400, ErrInvalidJSON)
+	}
+	id, tags, ok, err := tagRequest(c, body.Tags)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if isDryRun(c) {
+		user, err := users.Get(ctx, id)
+		if err == nil {
+			user.Tags, err = withTags(user.Tags, tags)
+		}
+		if err != nil {
+			return tagWriteError(c, err)
+		}
+		return dryRunResult(c, 200, fiber.Map{"message": "Tags would be added", "user": user})
+	}
+
+	user, err := users.AddTags(ctx, id, tags)
+	if err != nil {
+		return tagWriteError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Tags added successfully", "user": user})
+}
+
+// DELETE /users/:id/tags/:tag
+func removeUserTag(c *fiber.Ctx) error {
+	id, tags, ok, err := tagRequest(c, []string{c.Params("tag")})
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if isDryRun(c) {
+		user, err := users.Get(ctx, id)
+		if err != nil {
+			return tagWriteError(c, err)
+		}
+		user.Tags = withoutTags(user.Tags, tags)
+		return dryRunResult(c, 200, fiber.Map{"message": "Tag would be removed", "user": user})
+	}
+
+	user, err := users.RemoveTags(ctx, id, tags)
+	if err != nil {
+		return tagWriteError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Tag removed successfully", "user": user})
+}
+
+// GET /users/facets takes the GET /users filters and counts tags over the matches
+func userFacets(c *fiber.Ctx) error {
+	q, ferr := userResource.parseQuery(c)
+	if ferr != nil {
+		return validationError(c, []*FieldError{ferr})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	facets, err := users.Facets(ctx, userListQuery(q).unpaged())
+	if err != nil {
+		return apiError(c, 500, ErrFetchFailed)
+	}
+	return c.JSON(facets)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+		errs [][2]string
+	}{
+		{[]string{" VIP", "eng", "vip", "a_b-c"}, []string{"vip", "eng", "a_b-c"}, nil},
+		{nil, nil, nil},
+		{[]string{}, nil, nil},
+		{[]string{"ok", "-dash", "", "has space"}, []string{"ok"}, [][2]string{{"tags.1", ErrFieldInvalidFormat}, {"tags.2", ErrFieldInvalidFormat}, {"tags.3", ErrFieldInvalidFormat}}},
+		{[]string{strings.Repeat("x", 33)}, nil, [][2]string{{"tags.0", ErrFieldInvalidFormat}}},
+	}
+	for _, tt := range tests {
+		got, errs := normalizeTags("tags", tt.in)
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(errorCodes(errs), tt.errs) {
+			t.Errorf("normalizeTags(%q) = %q %q, want %q %q", tt.in, got, errorCodes(errs), tt.want, tt.errs)
+		}
+	}
+
+	var many []string
+	for i := range maxTags + 1 {
+		many = append(many, fmt.Sprintf("t%d", i))
+	}
+	if _, errs := normalizeTags("tags", many); !reflect.DeepEqual(errorCodes(errs), [][2]string{{"tags", ErrFieldTooMany}}) {
+		t.Errorf("%d tags: got %q", len(many), errorCodes(errs))
+	}
+}
+
+func TestParseTagsParam(t *testing.T) {
+	got, ferr := parseTagsParam("tags_any", "VIP, eng,vip")
+	if ferr != nil || !reflect.DeepEqual(got, []string{"vip", "eng"}) {
+		t.Errorf("got %v %+v, want [vip eng]", got, ferr)
+	}
+	// Errors name the parameter, not an index in it
+	if _, ferr := parseTagsParam("tags_any", "vip,,eng"); ferr == nil || ferr.Field != "tags_any" || ferr.Code != ErrFieldInvalidFormat {
+		t.Errorf("empty tag: got %+v", ferr)
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	current := []string{"vip", "eng"}
+	got, err := withTags(current, []string{"eng", "new"})
+	if err != nil || !reflect.DeepEqual(got, []string{"vip", "eng", "new"}) {
+		t.Errorf("got %q %v", got, err)
+	}
+	if !reflect.DeepEqual(current, []string{"vip", "eng"}) {
+		t.Errorf("current changed: %q", current)
+	}
+
+	var full []string
+	for i := range maxTags {
+		full = append(full, fmt.Sprintf("t%d", i))
+	}
+	if got, err := withTags(full, []string{"t0"}); err != nil || len(got) != maxTags {
+		t.Errorf("re-adding at the limit: got %d tags, %v", len(got), err)
+	}
+	if got, err := withTags(full, []string{"one-more"}); err != ErrTooManyTags || !reflect.DeepEqual(got, full) {
+		t.Errorf("over the limit: got %d tags, %v", len(got), err)
+	}
+}
+
+func TestWithoutTags(t *testing.T) {
+	current := []string{"vip", "eng", "new"}
+	if got := withoutTags(current, []string{"eng", "missing"}); !reflect.DeepEqual(got, []string{"vip", "new"}) {
+		t.Errorf("got %q", got)
+	}
+	if !reflect.DeepEqual(current, []string{"vip", "eng", "new"}) {
+		t.Errorf("current changed: %q", current)
+	}
+	if got := withoutTags(current, current); got != nil {
+		t.Errorf("all removed: got %#v, want nil", got)
+	}
+}
+
+func TestCountFacets(t *testing.T) {
+	list := []User{
+		{Name: "Ann", Tags: []string{"vip", "eng"}},
+		{Name: "Bob", Tags: []string{"eng"}},
+		{Name: "Cid", Tags: []string{"sales"}},
+		{Name: "Dee"},
+	}
+	want := UserFacets{Total: 4, Tags: []TagCount{{"eng", 2}, {"sales", 1}, {"vip", 1}}}
+	if got := countFacets(list); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := countFacets(nil); got.Total != 0 || got.Tags == nil || len(got.Tags) != 0 {
+		t.Errorf("no users: got %#v, want an empty tag list", got)
+	}
+}
+
+func TestTagEndpoints(t *testing.T) {
+	store := testMemoryUsers(t)
+	ann := User{Name: "Ann", Age: 30, Tags: []string{"vip"}}
+	if err := store.Insert(context.Background(), &ann); err != nil {
+		t.Fatal(err)
+	}
+	bob := User{Name: "Bob", Age: 40, Tags: []string{"eng", "vip"}}
+	if err := store.Insert(context.Background(), &bob); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/users/facets", userFacets)
+	app.Post("/users/:id/tags", addUserTags)
+	app.Delete("/users/:id/tags/:tag", removeUserTag)
+
+	tagsOf := func(id primitive.ObjectID) []string {
+		u, err := store.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Tags
+	}
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+		tags               []string
+	}{
+		{"POST", "/users/" + ann.ID.Hex() + "/tags", `{"tags":["VIP","New"]}`, 200, "", []string{"vip", "new"}},
+		{"POST", "/users/" + ann.ID.Hex() + "/tags?dry_run=true", `{"tags":["more"]}`, 200, "", []string{"vip", "new"}},
+		{"POST", "/users/" + ann.ID.Hex() + "/tags", `{"tags":[]}`, 400, ErrValidationFailed, []string{"vip", "new"}},
+		{"POST", "/users/" + ann.ID.Hex() + "/tags", `{"tags":["no way"]}`, 400, ErrValidationFailed, []string{"vip", "new"}},
+		{"POST", "/users/nope/tags", `{"tags":["vip"]}`, 400, ErrInvalidID, []string{"vip", "new"}},
+		{"POST", "/users/" + primitive.NewObjectID().Hex() + "/tags", `{"tags":["vip"]}`, 404, ErrUserNotFound, []string{"vip", "new"}},
+		{"DELETE", "/users/" + ann.ID.Hex() + "/tags/VIP", "", 200, "", []string{"new"}},
+		{"DELETE", "/users/" + ann.ID.Hex() + "/tags/new?dry_run=true", "", 200, "", []string{"new"}},
+		{"DELETE", "/users/" + ann.ID.Hex() + "/tags/new", "", 200, "", nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		status, code := testResponse(t, app, req)
+		if status != tt.status || (tt.code != "" && code != tt.code) {
+			t.Errorf("%s %s %s: got %d %q, want %d %q", tt.method, tt.path, tt.body, status, code, tt.status, tt.code)
+		}
+		if got := tagsOf(ann.ID); !reflect.DeepEqual(got, tt.tags) {
+			t.Errorf("%s %s %s: stored tags %q, want %q", tt.method, tt.path, tt.body, got, tt.tags)
+		}
+	}
+
+	var full []string
+	for i := range maxTags {
+		full = append(full, fmt.Sprintf("t%d", i))
+	}
+	if _, err := store.AddTags(context.Background(), bob.ID, full[:maxTags-2]); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/users/"+bob.ID.Hex()+"/tags", strings.NewReader(`{"tags":["one-more"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	if status, code := testResponse(t, app, req); status != 400 || code != ErrValidationFailed {
+		t.Errorf("over %d tags: got %d %q", maxTags, status, code)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users/facets?min_age=35", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var facets UserFacets
+	json.NewDecoder(resp.Body).Decode(&facets)
+	if resp.StatusCode != 200 || facets.Total != 1 || len(facets.Tags) != maxTags {
+		t.Errorf("facets: got %d %+v", resp.StatusCode, facets)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GET /users filters: name, age range and one per custom field
+func userFilters() []ResourceFilter {
+	age := func(param, raw string) (any, *FieldError) {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 || v > maxAge {
+			return nil, &FieldError{Field: param, Code: ErrFieldOutOfRange, Params: fiber.Map{"min": 0, "max": maxAge}}
+		}
+		return v, nil
+	}
+	ageSchema := fiber.Map{"type": "integer", "minimum": 0, "maximum": maxAge}
+
+	filters := []ResourceFilter{
+		{Param: "name", Description: "Exact name", Schema: fiber.Map{"type": "string"}},
+		{Param: "name_prefix", Description: "Case sensitive name prefix", Schema: fiber.Map{"type": "string"}},
+		{Param: "min_age", Description: "Minimum age", Schema: ageSchema, Parse: age},
+		{Param: "max_age", Description: "Maximum age", Schema: ageSchema, Parse: age},
+		{Param: "bbox", Description: "Location within minLng,minLat,maxLng,maxLat; minLng > maxLng crosses the antimeridian", Schema: fiber.Map{"type": "string"}, Parse: parseBBox},
+		{Param: "tags", Description: "Comma separated tags the user has all of", Schema: fiber.Map{"type": "string"}, Parse: parseTagsParam},
+		{Param: "tags_any", Description: "Comma separated tags the user has at least one of", Schema: fiber.Map{"type": "string"}, Parse: parseTagsParam},
+	}
+	for _, f := range customFields.list() {
+		filters = append(filters, ResourceFilter{
+			Param:       "custom." + f.Name,
+			Description: "Exact " + f.Name + " (custom field)",
+			Schema:      f.schema(),
+			Parse:       f.parse,
+		})
+	}
+	return filters
+}
+
+// $filter and $select properties
+func userFields() map[string]string {
+	fields := map[string]string{"name": CustomString, "age": CustomInteger}
+	for _, f := range customFields.list() {
+		fields["custom."+f.Name] = f.Type
+	}
+	return fields
+}
+
+func userSorts() []string {
+	keys := []string{"name", "age"}
+	for _, f := range customFields.list() {
+		keys = append(keys, "custom."+f.Name)
+	}
+	return keys
+}
+
+// ListQuery for parsed GET /users parameters
+func userListQuery(q ResourceQuery) ListQuery {
+	lq := ListQuery{Where: q.Where, Limit: q.Limit, Offset: q.Offset}
+	for param, v := range q.Filters {
+		switch param {
+		case "name":
+			lq.Name = v.(string)
+		case "name_prefix":
+			lq.NamePrefix = v.(string)
+		case "min_age":
+			age := v.(int)
+			lq.MinAge = &age
+		case "max_age":
+			age := v.(int)
+			lq.MaxAge = &age
+		case "bbox":
+			box := v.(GeoBox)
+			lq.Box = &box
+		case "tags":
+			lq.TagsAll = v.([]string)
+		case "tags_any":
+			lq.TagsAny = v.([]string)
+		default:
+			if name, ok := strings.CutPrefix(param, "custom."); ok {
+				if lq.Custom == nil {
+					lq.Custom = map[string]any{}
+				}
+				lq.Custom[name] = v
+			}
+		}
+	}
+	for _, key := range q.Sort {
+		if name, ok := strings.CutPrefix(key.Field, "custom."); ok {
+			field, _ := customFields.get(name)
+			key.Type = field.Type
+		}
+		lq.Sort = append(lq.Sort, key)
+	}
+	return lq
+}
+
+// Listing shared by both user resources
+type userList struct{}
+
+func (userList) List(ctx context.Context, q ResourceQuery) ([]User, error) {
+	return users.List(ctx, userListQuery(q))
+}
+
+// How totals are counted, from COUNT_MODE and COUNT_TIMEOUT
+var (
+	countEstimateUnfiltered = true
+	countTimeout            = time.Second
+)
+
+// COUNT_MODE=auto (default) estimates unfiltered totals, exact always counts;
+// COUNT_TIMEOUT bounds exact counts, which then fall back to the estimate
+func loadCountConfig() error {
+	switch mode := os.Getenv("COUNT_MODE"); mode {
+	case "", "auto":
+		countEstimateUnfiltered = true
+	case "exact":
+		countEstimateUnfiltered = false
+	default:
+		return fmt.Errorf("invalid COUNT_MODE %q, expected auto or exact", mode)
+	}
+	if v := os.Getenv("COUNT_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid COUNT_TIMEOUT %q", v)
+		}
+		countTimeout = d
+	}
+	return nil
+}
+
+// Exact when filtered, unless that takes longer than countTimeout: the
+// estimate of all users then stands in as an upper bound
+func (userList) Count(ctx context.Context, q ResourceQuery) (ResourceCount, error) {
+	lq := userListQuery(q)
+	if countEstimateUnfiltered && !lq.filtered() {
+		n, err := users.EstimatedCount(ctx)
+		return ResourceCount{N: n, Estimated: true}, err
+	}
+
+	countCtx, cancel := context.WithTimeout(ctx, countTimeout)
+	n, err := users.CountList(countCtx, lq)
+	cancel()
+	if err == nil || ctx.Err() != nil || !(errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)) {
+		return ResourceCount{N: n}, err
+	}
+	fmt.Printf("⏱️  Count took over %s, answering the estimate: %v\n", countTimeout, err)
+	n, err = users.EstimatedCount(ctx)
+	return ResourceCount{N: n, Estimated: true}, err
+}
+
+// The active UserStore addressed by ObjectID, for /users/:id
+type usersByIDStore struct{ userList }
+
+func (usersByIDStore) Get(ctx context.Context, key string) (User, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return User{}, ErrInvalidKey
+	}
+	return users.Get(ctx, id)
+}
+
+func (usersByIDStore) Create(ctx context.Context, user *User) error {
+	return users.Insert(ctx, user)
+}
+
+// Upsert by ID; the resource already checked the user exists
+func (s usersByIDStore) Update(ctx context.Context, key string, user User) (User, error) {
+	current, err := s.Get(ctx, key)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = current.ID
+	return user, users.Upsert(ctx, user)
+}
+
+func (s usersByIDStore) Delete(ctx context.Context, key string) (User, error) {
+	user, err := s.Get(ctx, key)
+	if err != nil {
+		return User{}, err
+	}
+	return user, users.Delete(ctx, user.ID)
+}
+
+// The active UserStore addressed by name, i.e. the oldest user with it,
+// for the original /user/:name routes
+type usersByNameStore struct{ userList }
+
+func (usersByNameStore) Get(ctx context.Context, name string) (User, error) {
+	return users.FindByName(ctx, name)
+}
+
+func (usersByNameStore) Create(ctx context.Context, user *User) error {
+	return users.Insert(ctx, user)
+}
+
+func (usersByNameStore) Update(ctx context.Context, name string, user User) (User, error) {
+	return users.UpdateByName(ctx, name, user)
+}
+
+func (usersByNameStore) Delete(ctx context.Context, name string) (User, error) {
+	return users.DeleteByName(ctx, name)
+}
+
+var userHooks = ResourceHooks[User]{
+	// Names are used as keys by /user/:name, so dry runs flag collisions
+	BeforeWrite: func(ctx context.Context, w *ResourceWrite[User]) error {
+		if !w.DryRun || w.Op != OpCreate {
+			return nil
+		}
+		existing, err := users.CountByName(ctx, w.Item.Name)
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			w.Warnings = append(w.Warnings, fmt.Sprintf("%d user(s) already named %q", existing, w.Item.Name))
+		}
+		return nil
+	},
+}
+
+var userErrors = ResourceErrors{
+	InvalidKey: ErrInvalidID,
+	NotFound:   ErrUserNotFound,
+	Fetch:      ErrFetchFailed,
+	Create:     ErrCreateFailed,
+	Update:     ErrUpdateFailed,
+	Delete:     ErrDeleteFailed,
+}
+
+// Settings shared by both user resources
+func newUserResource(path, key string, store ResourceStore[User]) *Resource[User] {
+	return &Resource[User]{
+		Name:     "user",
+		Title:    "User",
+		Path:     path,
+		Key:      key,
+		Store:    store,
+		Validate: validateUser,
+		Replace: func(current, user User) User {
+			user.ID = current.ID
+			return user
+		},
+		ID:             func(u User) any { return u.ID },
+		BodySchema:     "user",
+		IDField:        "_id",
+		Filters:        userFilters,
+		Reserved:       []string{"custom."},
+		Sorts:          userSorts,
+		Fields:         userFields,
+		MaxLimit:       maxListLimit,
+		Hooks:          userHooks,
+		Errors:         userErrors,
+		Component:      "User",
+		InputComponent: "UserInput",
+	}
+}
+
+// /users and /users/:id. Reads answer ?as_of= from history and follow merge redirects.
+var userResource = func() *Resource[User] {
+	r := newUserResource("/users", "id", usersByIDStore{})
+	r.Before = map[string][]fiber.Handler{
+		OpList: {usersAsOfHandler},
+		OpGet:  {usersAsOfHandler},
+	}
+	r.Hooks.NotFound = followRedirect
+	r.Describe = func(op string, operation fiber.Map) {
+		switch op {
+		case OpList:
+			operation["summary"] = "List users, ties broken by _id"
+			operation["parameters"] = append(operation["parameters"].([]fiber.Map), asOfParamSpec)
+		case OpGet:
+			operation["summary"] = "Get a user by ID, following merge redirects"
+			operation["parameters"] = append(operation["parameters"].([]fiber.Map), asOfParamSpec)
+			operation["responses"].(fiber.Map)["301"] = fiber.Map{"description": "Merged into another user, see Location"}
+		}
+	}
+	return r
+}()
+
+// The original name-keyed routes: POST /user, PUT/PATCH/DELETE /user/:name
+var legacyUserResource = func() *Resource[User] {
+	r := newUserResource("/user", "name", usersByNameStore{})
+	r.Ops = []string{OpCreate, OpUpdate, OpPatch, OpDelete}
+	// Writes by name only change name and age, the rest is kept as stored
+	r.Replace = func(current, user User) User {
+		current.Name, current.Age = user.Name, user.Age
+		return current
+	}
+	// The kept fields are as stored, so custom fields deleted or made required
+	// since do not block renames
+	r.ValidateChange = func(_ User, user *User) []*FieldError { return validateNameAge(user) }
+	r.Describe = func(op string, operation fiber.Map) {
+		if op != OpCreate {
+			operation["summary"] = strings.Replace(operation["summary"].(string), "a user", "the oldest user with that name", 1)
+		}
+	}
+	return r
+}()
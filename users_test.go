@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Writes by name only change name and age: custom fields deleted or made
+// required since the user was stored do not block them
+func TestLegacyWriteKeepsStoredCustomFields(t *testing.T) {
+	prev := customFields
+	customFields = &customFieldRegistry{fields: map[string]CustomField{
+		"level": {Name: "level", Type: CustomInteger, Required: true},
+	}}
+	t.Cleanup(func() { customFields = prev })
+
+	store := testMemoryUsers(t)
+	ctx := context.Background()
+	ann := User{Name: "Ann", Age: 30, Custom: map[string]any{"shoe": "42"}}
+	if err := store.Insert(ctx, &ann); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	userResource.Register(app)
+	legacyUserResource.Register(app)
+	send := func(method, path, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return testResponse(t, app, req)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"PUT", "/user/Ann", `{"name":"Anna","age":31}`, 200},
+		{"PATCH", "/user/Anna", `{"age":32}`, 200},
+		{"PUT", "/user/Anna", `{"name":"Anna","age":200}`, 400},
+		{"PUT", "/user/Anna", `{"age":1}`, 400},
+		// Full replacements still check every field
+		{"PUT", "/users/" + ann.ID.Hex(), `{"name":"Anna","age":33}`, 400},
+		{"POST", "/user", `{"name":"Bob"}`, 400},
+	}
+	for _, tt := range tests {
+		if status, body := send(tt.method, tt.path, tt.body); status != tt.status {
+			t.Errorf("%s %s %s: got %d %s, want %d", tt.method, tt.path, tt.body, status, body, tt.status)
+		}
+	}
+
+	got, err := store.Get(ctx, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Anna" || got.Age != 32 || !reflect.DeepEqual(got.Custom, ann.Custom) {
+		t.Errorf("stored %+v, want Anna, 32 and the old custom fields", got)
+	}
+}
+
+func TestLoadCountConfig(t *testing.T) {
+	prevEstimate, prevTimeout := countEstimateUnfiltered, countTimeout
+	t.Cleanup(func() { countEstimateUnfiltered, countTimeout = prevEstimate, prevTimeout })
+
+	tests := []struct {
+		mode, timeout string
+		estimate      bool
+		want          time.Duration
+		err           bool
+	}{
+		{"", "", true, time.Second, false},
+		{"auto", "250ms", true, 250 * time.Millisecond, false},
+		{"exact", "2s", false, 2 * time.Second, false},
+		{"fast", "", false, 0, true},
+		{"", "soon", false, 0, true},
+		{"", "-1s", false, 0, true},
+	}
+	for _, tt := range tests {
+		countEstimateUnfiltered, countTimeout = !tt.estimate, time.Second
+		t.Setenv("COUNT_MODE", tt.mode)
+		t.Setenv("COUNT_TIMEOUT", tt.timeout)
+		err := loadCountConfig()
+		if (err != nil) != tt.err {
+			t.Errorf("mode %q timeout %q: got error %v", tt.mode, tt.timeout, err)
+			continue
+		}
+		if !tt.err && (countEstimateUnfiltered != tt.estimate || countTimeout != tt.want) {
+			t.Errorf("mode %q timeout %q: got estimate %v timeout %s", tt.mode, tt.timeout, countEstimateUnfiltered, countTimeout)
+		}
+	}
+}
+
+// Memory store with a lagging estimate, and exact counts that can be made
+// to outlast their deadline
+type countingStore struct {
+	*memoryStore
+	estimate int64
+	slow     bool
+}
+
+func (s *countingStore) EstimatedCount(context.Context) (int64, error) { return s.estimate, nil }
+
+func (s *countingStore) CountList(ctx context.Context, q ListQuery) (int64, error) {
+	if s.slow {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return s.memoryStore.CountList(ctx, q)
+}
+
+func TestUserCounts(t *testing.T) {
+	prevEstimate, prevTimeout := countEstimateUnfiltered, countTimeout
+	t.Cleanup(func() { countEstimateUnfiltered, countTimeout = prevEstimate, prevTimeout })
+	countTimeout = 20 * time.Millisecond
+
+	store := &countingStore{memoryStore: testMemoryUsers(t), estimate: 10}
+	users = store
+	for _, u := range []User{{Name: "Ann", Age: 30}, {Name: "Bob", Age: 40}, {Name: "Cid", Age: 50}} {
+		if err := store.Insert(context.Background(), &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	userResource.Register(app)
+
+	tests := []struct {
+		name         string
+		method, path string
+		exact, slow  bool
+		total, estim string
+	}{
+		{"unfiltered head estimates", "HEAD", "/users", false, false, "10", "true"},
+		{"filtered head counts", "HEAD", "/users?min_age=35", false, false, "2", "false"},
+		{"paging is ignored", "HEAD", "/users?min_age=35&limit=1&offset=1", false, false, "2", "false"},
+		{"exact mode counts everything", "HEAD", "/users", true, false, "3", "false"},
+		{"slow count falls back", "HEAD", "/users?min_age=35", false, true, "10", "true"},
+		{"include_count", "GET", "/users?max_age=35&include_count=true", false, false, "1", "false"},
+		{"$count", "GET", "/users?$filter=age%20ge%2040&$count=true", false, false, "2", "false"},
+		{"no count asked", "GET", "/users", false, false, "", ""},
+	}
+	for _, tt := range tests {
+		countEstimateUnfiltered, store.slow = !tt.exact, tt.slow
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total, estim := resp.Header.Get("X-Total-Count"), resp.Header.Get("X-Total-Count-Estimated")
+		if resp.StatusCode != 200 || total != tt.total || estim != tt.estim {
+			t.Errorf("%s: got %d total %q estimated %q, want total %q estimated %q", tt.name, resp.StatusCode, total, estim, tt.total, tt.estim)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users?min_age=35&$count=true&limit=1", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Count int64  `json:"@odata.count"`
+		Value []User `json:"value"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Count != 2 || len(body.Value) != 1 {
+		t.Errorf("$count body: got %d with %d users, want 2 with 1", body.Count, len(body.Value))
+	}
+
+	resp, err = app.Test(httptest.NewRequest("HEAD", "/users?include_count=maybe", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 || resp.Header.Get("X-Total-Count") != "" {
+		t.Errorf("invalid head: got %d total %q", resp.StatusCode, resp.Header.Get("X-Total-Count"))
+	}
+}